@@ -0,0 +1,130 @@
+package authz.a2a
+
+import data.authz.lib
+import data.authz.request
+import data.authz.settings.config
+import data.authz.token
+
+# A2A (agent-to-agent) JSON-RPC envelopes. The target agent is the entry of
+# config.a2a.agents whose hosts and paths match the request; the skill is read
+# from the message metadata (config.a2a.skill_key, default "skill_id"), and the
+# task state says what the call does to a task:
+#
+#   new       message/send or message/stream without a taskId
+#   continue  a message for an existing task (taskId set)
+#   read      tasks/get and tasks/resubscribe
+#   cancel    tasks/cancel
+#
+# An agent with `callers` only accepts requests from the listed client ids (azp),
+# whatever their envelope: GETs, other methods and batches included. A caller
+# limited to `skills` or task `states` may only send single A2A calls, whose
+# skill and state can be checked, for example:
+#
+#   - name: booking-agent
+#     hosts: [booking-agent.localhost]
+#     callers:
+#       travel-planner: {skills: [quote], states: [new, continue, read]}
+
+message := request.body if {
+    request.body.jsonrpc == "2.0"
+    startswith(request.body.method, "message/")
+} else := request.body if {
+    request.body.jsonrpc == "2.0"
+    startswith(request.body.method, "tasks/")
+}
+
+method := message.method
+
+host_matches(agent) if not agent.hosts
+
+host_matches(agent) if request.hostname in agent.hosts
+
+path_matches(agent) if not agent.paths
+
+path_matches(agent) if lib.path_matches(agent.paths, request.path)
+
+default agents := []
+
+agents := config.a2a.agents
+
+agent := [a | some a in agents; host_matches(a); path_matches(a)][0]
+
+default skill_key := "skill_id"
+
+skill_key := config.a2a.skill_key
+
+default skill := ""
+
+skill := message.params.message.metadata[skill_key] if {
+    message.params.message.metadata[skill_key]
+} else := message.params.metadata[skill_key]
+
+default task_id := ""
+
+task_id := message.params.message.taskId if startswith(method, "message/")
+
+task_id := message.params.id if startswith(method, "tasks/")
+
+default task_state := ""
+
+task_state := "new" if {
+    method in {"message/send", "message/stream"}
+    task_id == ""
+} else := "continue" if {
+    method in {"message/send", "message/stream"}
+} else := "read" if {
+    method in {"tasks/get", "tasks/resubscribe"}
+} else := "cancel" if {
+    method == "tasks/cancel"
+}
+
+default caller := ""
+
+caller := token.client_id
+
+default agent_name := ""
+
+agent_name := agent.name
+
+# What policies and external authorizers see of an A2A call.
+envelope := {
+    "agent": agent_name,
+    "method": method,
+    "skill": skill,
+    "task_id": task_id,
+    "task_state": task_state,
+} if message
+
+caller_policy := agent.callers[caller]
+
+reason(code, text) := {
+    "rule": sprintf("a2a.%s", [agent.name]),
+    "code": code,
+    "message": text,
+}
+
+deny contains reason("caller_not_allowed", sprintf("%s does not accept tasks from %q", [agent.name, caller])) if {
+    agent.callers
+    not caller_policy
+}
+
+limited if caller_policy.skills
+
+limited if caller_policy.states
+
+deny contains reason("envelope_required", sprintf("%s only accepts single A2A calls from %q", [agent.name, caller])) if {
+    limited
+    not message
+}
+
+deny contains reason("skill_not_allowed", sprintf("%s may not use skill %q of %s", [caller, skill, agent.name])) if {
+    message
+    caller_policy.skills
+    not skill in caller_policy.skills
+}
+
+deny contains reason("task_state_not_allowed", sprintf("%s may not %s tasks of %s", [caller, task_state, agent.name])) if {
+    message
+    caller_policy.states
+    not task_state in caller_policy.states
+}
@@ -0,0 +1,85 @@
+package authz.actions
+
+import data.authz.degradation
+import data.authz.principal
+import data.authz.request
+import data.authz.routes
+import data.authz.rules
+import data.authz.settings
+import data.authz.token
+import data.authz.settings.config
+
+# Header actions on allowed requests. Each entry of config.header_actions applies
+# to requests matching its matchers (as operator rules: paths, methods,
+# context_extensions, mcp_tools, issuers, principals) and mutates headers:
+#
+#   request.add      set upstream request headers the caller did not send
+#   request.replace  set upstream request headers, overwriting the caller's
+#   request.append   append to the caller's value, comma-separated
+#   request.remove   strip request headers before the request goes upstream
+#   response.add     add headers to the response sent back to the client
+#
+# Values are Go text/templates over `vars` ({{.principal}}, {{.claims.email}},
+# {{index .roles 0}}, ...). Later actions win over earlier ones; the engine's own
+# upstream headers (the exchanged Authorization header, x-request-hash, the
+# delegation headers) cannot be overridden.
+
+default configured := []
+
+configured := config.header_actions
+
+applicable := [a | some a in configured; rules.applies(a)]
+
+vars["principal"] := principal.id
+
+vars["subject"] := token.subject
+
+vars["client_id"] := token.client_id
+
+vars["claims"] := token.claims
+
+vars["roles"] := sort(token.roles)
+
+vars["scopes"] := sort(token.scopes)
+
+vars["groups"] := sort(token.groups)
+
+vars["route"] := routes.route.name
+
+vars["environment"] := settings.environment
+
+vars["tier"] := degradation.tier
+
+vars["method"] := request.method
+
+vars["path"] := request.path
+
+vars["host"] := request.hostname
+
+vars["segments"] := [s | some s in split(request.path, "/"); s != ""]
+
+render(template) := strings.render_template(template, vars)
+
+# Rendered values by header name for one part and operation; the last applicable
+# action setting a header wins. Values that fail to render are left out.
+merged(part, operation) := object.union_n([object.get(a, [part, operation], {}) | some a in applicable])
+
+values(part, operation) := {lower(name): render(value) | some name, value in merged(part, operation)}
+
+added := {name: value | some name, value in values("request", "add"); not request.header(name)}
+
+appended := {name: concat(", ", [request.header(name), value]) |
+    some name, value in values("request", "append")
+    request.header(name)
+}
+
+started := {name: value | some name, value in values("request", "append"); not request.header(name)}
+
+request_headers := object.union_n([added, started, appended, values("request", "replace")])
+
+removed contains lower(name) if {
+    some a in applicable
+    some name in object.get(a, ["request", "remove"], [])
+}
+
+response_headers := values("response", "add")
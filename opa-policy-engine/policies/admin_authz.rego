@@ -0,0 +1,158 @@
+package system.authz
+
+import data.authz.lib
+import data.authz.settings.config
+
+# Authorization of OPA's REST API, enforced when OPA runs with
+# --authentication=token --authorization=basic (run-opa.sh --admin-rbac). The
+# Envoy plugin's gRPC port is not affected. Callers present a Keycloak access
+# token for config.admin.audience; its realm roles, and client roles of that
+# audience, map to capabilities through config.admin.roles:
+#
+#   read           GET documents, evaluate decisions and reports
+#   evaluate       ad-hoc queries and partial evaluation (/v1/query, /v1/compile),
+#                  which can call any builtin, opa.runtime() included
+#   edit_policies  replace policy modules and data.config, toggle rule enforcement
+#   manage_state   change overrides, grants and approvals (break-glass)
+#   replicate      write data.kubernetes (kube-mgmt's replicated resources)
+#   decide         evaluate gateway decisions (authz.result), for HTTP adapters
+#
+# Packages that read secrets (signing keys, client secrets, the deidentify key) and
+# whole-tree reads that would include them are denied to every role; only the
+# internal token's public keys are served. Every request that changes state is
+# printed to OPA's log as an audit record, allowed or not.
+
+write if input.method in {"PUT", "PATCH", "DELETE"}
+
+# Liveness and readiness probes.
+public if {
+    input.path[0] == "health"
+    input.method == "GET"
+}
+
+jwks := http.send(lib.pinned({
+    "method": "GET",
+    "url": config.admin.jwks_url,
+    "timeout": "2s",
+    "force_cache": true,
+    "force_cache_duration_seconds": 300,
+    "raise_error": false,
+})) if {
+    input.identity
+}
+
+verification := io.jwt.decode_verify(input.identity, {
+    "cert": json.marshal(jwks.body),
+    "iss": config.admin.issuer,
+    "aud": config.admin.audience,
+}) if {
+    jwks.status_code == 200
+}
+
+claims := verification[2] if verification[0]
+
+default subject := ""
+
+subject := claims.sub
+
+roles := {role | some role in claims.realm_access.roles} | {role |
+    some role in claims.resource_access[config.admin.audience].roles
+}
+
+capabilities contains capability if {
+    some role in roles
+    some capability in config.admin.roles[role]
+}
+
+# Documents derived from secrets, by package path under /v1/data.
+secret_packages := [["authz", "exchange"], ["authz", "token"], ["authz", "internal_token"], ["system"]]
+
+published := {["authz", "internal_token", "jwks"]}
+
+under(path, prefix) if array.slice(path, 0, count(prefix)) == prefix
+
+# The requested document under /v1/data, ignoring a trailing slash.
+document := [segment | some segment in array.slice(input.path, 2, count(input.path)); segment != ""] if {
+    input.path[1] == "data"
+}
+
+public_document if {
+    some p in published
+    under(document, p)
+}
+
+secret_bearing if {
+    some prefix in secret_packages
+    under(document, prefix)
+    not public_document
+}
+
+# Reads of /v1/data and /v1/data/authz evaluate every package at once.
+secret_bearing if document in {[], ["authz"]}
+
+# Documents holding the headers sent upstream on allow, the internal identity
+# token among them. Their input is whatever the caller posts, so reading them
+# would mint a token for any identity the caller claims.
+minting_documents := [["authz", "result"], ["authz", "upstream_headers"], ["authz", "engine_headers"]]
+
+minting if {
+    some prefix in minting_documents
+    under(document, prefix)
+}
+
+adhoc if input.path[1] in {"query", "compile"}
+
+default required := "none"
+
+required := "evaluate" if {
+    adhoc
+} else := "read" if {
+    not write
+} else := "edit_policies" if {
+    input.path[1] == "policies"
+} else := "edit_policies" if {
+    input.path[1] == "data"
+    input.path[2] in {"config", "enforcement"}
+} else := "manage_state" if {
+    input.path[1] == "data"
+    input.path[2] in {"overrides", "grants", "approvals"}
+} else := "replicate" if {
+    input.path[1] == "data"
+    input.path[2] == "kubernetes"
+}
+
+default permitted := false
+
+permitted if {
+    required in capabilities
+    not secret_bearing
+    not minting
+}
+
+permitted if {
+    required in capabilities
+    minting
+    "decide" in capabilities
+}
+
+audit(decision) := true if {
+    not write
+} else := true if {
+    print(json.marshal({
+        "msg": "admin audit",
+        "subject": subject,
+        "method": input.method,
+        "path": concat("/", input.path),
+        "capability": required,
+        "allowed": decision,
+    }))
+}
+
+default allow := false
+
+allow if public
+
+allow := permitted if {
+    not public
+    audit(permitted)
+}
@@ -0,0 +1,38 @@
+package authz.agent
+
+import data.authz.token
+import data.authz.settings.config
+
+# Agent metadata claims (agent_type, autonomy_level, owner) validated against the
+# JSON Schema in config.agent_claims.schema. Rules should read `metadata` only
+# through `validated`, which is undefined for tokens that fail validation.
+
+schema := config.agent_claims.schema
+
+metadata := {name: value |
+    some name in object.keys(schema.properties)
+    value := token.claims[name]
+}
+
+# Tokens carrying any agent claim, or issued to a client registered as an agent,
+# must satisfy the schema.
+applies if count(metadata) > 0
+
+applies if token.client_id in config.agent_claims.clients
+
+validation := json.match_schema(metadata, schema)
+
+valid if validation[0]
+
+validated := metadata if valid
+
+deny contains {
+    "rule": "agent.claims_schema",
+    "class": "token",
+    "code": "invalid_agent_claims",
+    "message": "token agent claims do not match the configured schema",
+    "details": {"errors": [e.desc | some e in validation[1]]},
+} if {
+    applies
+    not valid
+}
@@ -1,31 +1,84 @@
 package authz
 
+import data.authz.settings.config
+
 default allow := false
 
 # Debug rule to see full input context
 debug_input := input
 
+# The eight built-in rules below apply until config.access_rules exists; then the
+# configured rules replace them. `policyctl init --from-builtin` writes them out in
+# that format as a starting point. An access rule allows a non-empty user matching
+# its `users`, `actions` and `resources` globs (each omitted: any) and its optional
+# conditions: a source IP in one of `source_networks`, the user owning the
+# resource (`owner_only`), a request count below `max_request_count`.
+builtin if not config.access_rules
+
+access_fields := {"users": "user", "actions": "action", "resources": "resource"}
+
+access_matches(r, key, _) if not r[key]
+
+access_matches(r, key, field) if {
+    some pattern in r[key]
+    glob.match(pattern, [], input[field])
+}
+
+network_matches(r) if not r.source_networks
+
+network_matches(r) if {
+    some cidr in r.source_networks
+    net.cidr_contains(cidr, input.source_ip)
+}
+
+owner_matches(r) if not r.owner_only
+
+owner_matches(r) if {
+    r.owner_only == true
+    input.user == input.resource_owner
+}
+
+count_matches(r) if not r.max_request_count
+
+count_matches(r) if input.request_count < r.max_request_count
+
+allow if {
+    some r in config.access_rules
+    input.user != ""
+    every key, field in access_fields {
+        access_matches(r, key, field)
+    }
+    network_matches(r)
+    owner_matches(r)
+    count_matches(r)
+}
+
 allow if {
+    builtin
     input.user == "admin"
 }
 
 allow if {
+    builtin
     input.action == "read"
     input.user != ""
 }
 
 allow if {
+    builtin
     input.action == "write"
     input.user == "alice"
     input.resource == "data"
 }
 
 allow if {
+    builtin
     input.action == "delete"
     input.user == "admin"
 }
 
 allow if {
+    builtin
     input.action == "read"
     input.user == "bob"
     input.resource == "reports"
@@ -33,6 +86,7 @@ allow if {
 
 # IP-based access control (example)
 allow if {
+    builtin
     input.action == "admin"
     input.user == "admin"
     net.cidr_contains("192.168.1.0/24", input.source_ip)
@@ -40,12 +94,15 @@ allow if {
 
 # Resource ownership check (example)
 allow if {
+    builtin
     input.action == "write"
     input.user == input.resource_owner
 }
 
-# Simple rate limiting check (example)
+# Quota check on a caller-supplied count (example); token-bucket rate limits for
+# gateway traffic are in ratelimit.rego.
 allow if {
+    builtin
     input.action == "api_call"
     input.user == "user"
     input.request_count < 100
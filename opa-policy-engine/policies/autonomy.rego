@@ -0,0 +1,62 @@
+package authz.autonomy
+
+import data.authz.agent
+import data.authz.lib
+import data.authz.mcp
+import data.authz.principal
+import data.authz.request
+import data.authz.settings.config
+
+# Maps methods and MCP tools to the highest autonomy level allowed to use them.
+# Agents above that level need a human co-sign on the request: a JWT in the
+# co-sign header, signed by a key in config.autonomy.cosign_jwks for
+# cosign_audience, with the human as `sub`, an `exp`, and the action it approves:
+# the agent's principal (see principal.rego) as `agent`, the exact `method` and
+# `path`, and every MCP tool the request calls in `tools`.
+
+levels := {"supervised": 1, "autonomous": 2}
+
+matches(rule) if {
+    request.method in rule.methods
+    path_allowed(rule)
+}
+
+matches(rule) if {
+    some t in mcp.tools
+    t in rule.tools
+    path_allowed(rule)
+}
+
+path_allowed(rule) if not rule.paths
+
+path_allowed(rule) if lib.path_matches(rule.paths, request.path)
+
+cosign := claims if {
+    constraints := {
+        "cert": json.marshal(config.autonomy.cosign_jwks),
+        "aud": object.get(config.autonomy, "cosign_audience", "autonomy-cosign"),
+    }
+    [valid, _, claims] := io.jwt.decode_verify(request.header(config.autonomy.cosign_header), constraints)
+    valid
+    is_number(claims.exp)
+    claims.agent == principal.id
+    claims.sub != principal.id
+    claims.method == request.method
+    claims.path == request.path
+    every t in mcp.tools {
+        t in object.get(claims, "tools", [])
+    }
+}
+
+deny contains {
+    "rule": sprintf("autonomy.%s", [rule.name]),
+    "code": "cosign_required",
+    "message": sprintf("%s agents require a human co-sign for this operation", [level]),
+    "details": {"autonomy_level": level, "required_level": rule.required_level, "cosign_header": config.autonomy.cosign_header},
+} if {
+    level := agent.validated.autonomy_level
+    some rule in config.autonomy.rules
+    matches(rule)
+    levels[level] > levels[rule.required_level]
+    not cosign
+}
@@ -0,0 +1,30 @@
+package authz.binding
+
+import data.authz.routes
+import data.authz.token
+import data.authz.settings.config
+
+# Tokens bound to a route. A route with a `resource` indicator (RFC 8707) only
+# accepts tokens issued for it: the indicator must be one of the token's audiences
+# or the value of its config.token.route_claim claim, which may also name the
+# route. This stops an agent from replaying a token it was given for one service
+# at another.
+
+audiences := {token.claims.aud} if is_string(token.claims.aud)
+
+audiences := {aud | some aud in token.claims.aud} if is_array(token.claims.aud)
+
+bound if routes.route.resource in audiences
+
+bound if token.claims[config.token.route_claim] in {routes.route.resource, routes.route.name}
+
+deny contains {
+    "rule": sprintf("binding.%s", [routes.route.name]),
+    "class": "token",
+    "code": "token_not_bound_to_route",
+    "message": sprintf("the access token was not issued for %s", [routes.route.resource]),
+} if {
+    routes.route.resource
+    token.bearer
+    not bound
+}
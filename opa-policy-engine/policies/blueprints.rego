@@ -0,0 +1,115 @@
+package authz.blueprints
+
+import data.authz.lib
+import data.authz.mcp
+import data.authz.request
+import data.authz.settings.config
+
+# Parameterizable policies for common agent traffic shapes. Each entry of
+# config.blueprints names a type below and overrides any of its defaults; it
+# applies to requests whose path matches its `paths` globs.
+
+defaults := {
+    "mcp_server": {"methods": [
+        "initialize", "notifications/initialized", "notifications/cancelled", "ping",
+        "tools/list", "tools/call", "resources/list", "resources/read", "prompts/list", "prompts/get",
+    ]},
+    "openai_compatible": {"endpoints": ["/v1/chat/completions", "/v1/completions", "/v1/embeddings", "/v1/models"]},
+    "a2a": {
+        "methods": ["message/send", "message/stream", "tasks/get", "tasks/cancel", "tasks/resubscribe"],
+        "agent_card_paths": ["/.well-known/agent.json", "/.well-known/agent-card.json"],
+    },
+}
+
+active contains bp if {
+    some entry in config.blueprints
+    bp := object.union(defaults[entry.type], entry)
+    lib.path_matches(bp.paths, request.path)
+}
+
+reason(bp, code, message) := {
+    "rule": sprintf("blueprint.%s", [bp.name]),
+    "code": code,
+    "message": message,
+    "details": {"blueprint": bp.type},
+}
+
+# A blueprint of an unknown type would otherwise never apply; fail closed on its
+# paths until the configuration is fixed.
+deny contains {
+    "rule": sprintf("blueprint.%s", [entry.name]),
+    "class": "config",
+    "code": "invalid_config",
+    "status": 500,
+    "message": sprintf("blueprint %s has unknown type %q", [entry.name, entry.type]),
+} if {
+    some entry in config.blueprints
+    not defaults[entry.type]
+    lib.path_matches(entry.paths, request.path)
+}
+
+# MCP tool servers: JSON-RPC methods and, optionally, tool names.
+
+# Every message of a batch is checked, and a POST without a readable JSON-RPC
+# body fails closed.
+deny contains reason(bp, "unreadable_body", "MCP requests need a JSON-RPC body") if {
+    some bp in active
+    bp.type == "mcp_server"
+    request.method == "POST"
+    count(mcp.messages) == 0
+}
+
+deny contains reason(bp, "method_not_allowed", sprintf("MCP method %q is not allowed", [method])) if {
+    some bp in active
+    bp.type == "mcp_server"
+    some m in mcp.messages
+    method := m.method
+    not method in bp.methods
+}
+
+deny contains reason(bp, "tool_not_allowed", sprintf("MCP tool %q is not allowed", [t])) if {
+    some bp in active
+    bp.type == "mcp_server"
+    allowed := bp.tools
+    some t in mcp.tools
+    not t in allowed
+}
+
+# OpenAI-compatible model APIs: endpoints, models and max_tokens.
+
+deny contains reason(bp, "endpoint_not_allowed", sprintf("endpoint %s is not allowed", [request.path])) if {
+    some bp in active
+    bp.type == "openai_compatible"
+    not lib.path_matches(bp.endpoints, request.path)
+}
+
+deny contains reason(bp, "model_not_allowed", sprintf("model %q is not allowed", [model])) if {
+    some bp in active
+    bp.type == "openai_compatible"
+    allowed := bp.models
+    model := request.body.model
+    not model in allowed
+}
+
+deny contains reason(bp, "max_tokens_exceeded", sprintf("max_tokens may not exceed %d", [bp.max_tokens])) if {
+    some bp in active
+    bp.type == "openai_compatible"
+    request.body.max_tokens > bp.max_tokens
+}
+
+# A2A agents: the public agent card plus the allowed JSON-RPC methods.
+
+deny contains reason(bp, "method_not_allowed", sprintf("A2A method %q is not allowed", [method])) if {
+    some bp in active
+    bp.type == "a2a"
+    request.method == "POST"
+    method := request.body.method
+    not method in bp.methods
+}
+
+deny contains reason(bp, "method_not_allowed", sprintf("%s %s is not allowed", [request.method, request.path])) if {
+    some bp in active
+    bp.type == "a2a"
+    request.method != "POST"
+    not request.path in bp.agent_card_paths
+}
@@ -0,0 +1,28 @@
+package authz.bypass
+
+import data.authz.lib
+import data.authz.request
+import data.authz.settings.config
+
+# Infrastructure requests (health probes, discovery documents, CORS preflights)
+# that are allowed without evaluating tokens or any other rule, so probes neither
+# pay for a full evaluation nor fail on rules meant for agents. They are still
+# decision-logged.
+
+path if lib.path_matches(object.get(config.bypass, "paths", []), request.path)
+
+preflight if {
+    config.bypass.cors_preflight == true
+    request.method == "OPTIONS"
+    request.header("access-control-request-method")
+}
+
+bypassed if {
+    request.is_gateway
+    path
+}
+
+bypassed if {
+    request.is_gateway
+    preflight
+}
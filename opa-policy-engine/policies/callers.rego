@@ -0,0 +1,37 @@
+package authz.callers
+
+import data.authz.request
+import data.authz.settings.config
+
+# Authentication of the calling gateway. Each gateway in config.callers presents
+# its id and key as the `gateway_id` and `gateway_key` context extensions of its
+# extAuthz policy; only the SHA-256 of the key is kept in the data files. A
+# caller's `rules` (globs over rule ids) narrow the policy sets applied to its
+# traffic, so an egress gateway need not be subject to ingress rules.
+
+required if count(object.get(config, "callers", {})) > 0
+
+id := request.context_extensions.gateway_id
+
+caller := config.callers[id]
+
+authenticated if crypto.sha256(request.context_extensions.gateway_key) == caller.key_sha256
+
+in_scope(_) if not caller.rules
+
+in_scope(rule) if {
+    some pattern in caller.rules
+    glob.match(pattern, ["."], rule)
+}
+
+in_scope(rule) if startswith(rule, "callers.")
+
+deny contains {
+    "rule": "callers.authentication",
+    "code": "gateway_not_authenticated",
+    "message": "the calling gateway is not authenticated",
+} if {
+    required
+    request.is_gateway
+    not authenticated
+}
@@ -0,0 +1,73 @@
+package authz.claims
+
+import data.authz.settings.config
+
+# Claim transformations from config.claim_transforms, applied to the verified token
+# claims before any policy sees them, to smooth over differences between Keycloak
+# mappers and what rules and backends expect. Each transform reads the token's
+# original claims and writes one claim (`to`, default the claim it reads); when
+# several write the same claim, the last one wins. Identity providers can add
+# their own mappings.
+#
+#   {op: rename, from: preferred_username, to: user}
+#   {op: split, claim: scope, separator: " ", to: scopes}
+#   {op: regex_extract, claim: iss, pattern: "/realms/([^/]+)", to: realm}
+#   {op: coerce, claim: tier, type: number|boolean|string|array}
+
+target(t) := object.get(t, "to", object.get(t, "claim", ""))
+
+output(t, claims) := claims[t.from] if t.op == "rename"
+
+output(t, claims) := split(claims[t.claim], object.get(t, "separator", " ")) if t.op == "split"
+
+output(t, claims) := regex.find_all_string_submatch_n(t.pattern, claims[t.claim], 1)[0][object.get(t, "group", 1)] if {
+    t.op == "regex_extract"
+}
+
+output(t, claims) := coerce(claims[t.claim], t.type) if t.op == "coerce"
+
+coerce(value, "number") := to_number(value)
+
+coerce(value, "boolean") := value if {
+    is_boolean(value)
+} else := true if {
+    value == "true"
+} else := false
+
+coerce(value, "string") := value if {
+    is_string(value)
+} else := json.marshal(value)
+
+coerce(value, "array") := value if {
+    is_array(value)
+} else := [value]
+
+outputs(claims, transforms) := [[i, target(t), value] |
+    some i, t in transforms
+    value := output(t, claims)
+]
+
+overwritten(entries, i, name) if {
+    some entry in entries
+    entry[1] == name
+    entry[0] > i
+}
+
+renamed(claims, transforms) := {t.from |
+    some t in transforms
+    t.op == "rename"
+    claims[t.from]
+}
+
+transform(claims, transforms) := object.union(object.remove(claims, renamed(claims, transforms)), {name: value |
+    entries := outputs(claims, transforms)
+    some entry in entries
+    [i, name, value] := entry
+    not overwritten(entries, i, name)
+})
+
+apply(claims) := transform(claims, object.get(config, "claim_transforms", []))
+
+# A provider's own claim_transforms (see providers.rego) map its claims onto the
+# shapes the rules expect before the global ones run.
+apply_for(claims, provider) := apply(transform(claims, object.get(provider, "claim_transforms", [])))
@@ -0,0 +1,95 @@
+package authz.client
+
+import data.authz.lib
+import data.authz.request
+import data.authz.settings.config
+
+# Structured view of the calling client derived from the user-agent header.
+# Rules can match on info.kind ("agent_sdk", "bot", "script", "browser" or
+# "unknown") and, for known agent SDKs, on the SDK name and version.
+
+default user_agent := ""
+
+user_agent := request.header("user-agent")
+
+# Product tokens ("name/version") in the order they appear in the header.
+products := [{"name": m[1], "version": m[2]} |
+    some m in regex.find_all_string_submatch_n(`([A-Za-z][A-Za-z0-9._\-]*)/([0-9][A-Za-z0-9._+\-]*)`, user_agent, -1)
+]
+
+sdk := [p | some p in products; lower(p.name) in config.client.agent_sdks][0]
+
+script := [p | some p in products; lower(p.name) in config.client.scripts][0]
+
+is_bot if {
+    some marker in config.client.bot_markers
+    contains(lower(user_agent), marker)
+}
+
+default kind := "unknown"
+
+kind := "bot" if {
+    is_bot
+} else := "agent_sdk" if {
+    sdk
+} else := "script" if {
+    script
+} else := "browser" if {
+    startswith(user_agent, "Mozilla/")
+}
+
+default product := {}
+
+product := sdk if {
+    kind == "agent_sdk"
+} else := products[0] if {
+    count(products) > 0
+}
+
+info := object.union({"kind": kind, "user_agent": user_agent}, product)
+
+deny contains {
+    "rule": "client.kind",
+    "code": "client_not_allowed",
+    "message": sprintf("clients of kind %q are not allowed", [kind]),
+} if {
+    kind in config.client.deny_kinds
+}
+
+deny contains {
+    "rule": "client.min_sdk_version",
+    "code": "upgrade_required",
+    "status": 426,
+    "message": sprintf("%s %s is below the minimum supported version %s", [sdk.name, sdk.version, minimum]),
+    "details": {"client": sdk.name, "version": sdk.version, "minimum_version": minimum},
+} if {
+    minimum := config.client.min_sdk_versions[lower(sdk.name)]
+    lib.version_less(sdk.version, minimum)
+}
+
+# Version headers sent by agent runtimes, e.g. x-agent-sdk-version. Each entry of
+# config.client.required_versions applies to the paths matching its globs.
+deny contains {
+    "rule": "client.required_version",
+    "code": "upgrade_required",
+    "status": 426,
+    "message": sprintf("header %s is required for this route", [requirement.header]),
+    "details": {"header": requirement.header, "minimum_version": requirement.minimum},
+} if {
+    some requirement in config.client.required_versions
+    lib.path_matches(requirement.paths, request.path)
+    not request.header(requirement.header)
+}
+
+deny contains {
+    "rule": "client.required_version",
+    "code": "upgrade_required",
+    "status": 426,
+    "message": sprintf("%s %s is below the minimum supported version %s", [requirement.header, version, requirement.minimum]),
+    "details": {"header": requirement.header, "version": version, "minimum_version": requirement.minimum},
+} if {
+    some requirement in config.client.required_versions
+    lib.path_matches(requirement.paths, request.path)
+    version := request.header(requirement.header)
+    not lib.version_at_least(version, requirement.minimum)
+}
@@ -0,0 +1,181 @@
+package authz.combiner
+
+import data.authz
+import data.authz.actions
+import data.authz.degradation
+import data.authz.external
+import data.authz.lib
+import data.authz.principal
+import data.authz.request
+import data.authz.rules
+import data.authz.settings.config
+
+# Decision combiners. Besides the engine's own rules, config.combiner.backends may
+# vote on requests matching their matchers (as operator rules):
+#
+#   opa      another OPA (or compatible) engine: POSTs {"input": <external summary>}
+#            to `url`, a data API path; allows when the result is true or has allow: true
+#   webhook  POSTs the external summary to `url`; allows on {"allow": true}
+#   spicedb  asks SpiceDB's CheckPermission at `url` whether the principal has
+#            `permission` on `resource_type`:`resource_id` (templates as in header
+#            actions), with the preshared key from the variable named by token_env
+#
+# A backend that fails votes its `on_error` (deny, the default, allow or abstain).
+# `strategy` combines the votes with the engine's:
+#
+#   any_deny         deny if anyone votes deny (the default); abstentions are ignored
+#   unanimous_allow  deny unless every backend votes allow
+#   weighted         allow if the weight (default 1, the engine's engine_weight)
+#                    voting allow is more than `threshold` (default 0.5) of the
+#                    weight voting; may outvote the engine's policy denials, never
+#                    its token, config or dependency ones
+#
+# Under any_deny and unanimous_allow the backends are only asked when the engine
+# allows. Every vote is recorded in the decision log under `combiner`. Backends
+# are never cached, so under the cached_only and local_rules_only degradation
+# tiers they are skipped and the request is denied as degraded.
+
+settings := object.get(config, "combiner", {})
+
+strategy := object.get(settings, "strategy", "any_deny")
+
+backends := [b | some b in object.get(settings, "backends", []); rules.applies(b)]
+
+engine_allows if count(authz.engine_enforced_deny) == 0
+
+consulted if {
+    request.is_gateway
+    count(backends) > 0
+    strategy == "weighted"
+}
+
+consulted if {
+    request.is_gateway
+    count(backends) > 0
+    engine_allows
+}
+
+timeout(b) := sprintf("%dms", [object.get(b, "timeout_ms", 500)])
+
+call(b) := http.send(lib.pinned({
+    "method": "POST",
+    "url": b.url,
+    "headers": {"content-type": "application/json"},
+    "body": {"input": external.summary},
+    "timeout": timeout(b),
+    "raise_error": false,
+})) if b.type == "opa"
+
+call(b) := http.send(lib.pinned({
+    "method": "POST",
+    "url": b.url,
+    "headers": {"content-type": "application/json"},
+    "body": external.summary,
+    "timeout": timeout(b),
+    "raise_error": false,
+})) if b.type == "webhook"
+
+call(b) := http.send(lib.pinned({
+    "method": "POST",
+    "url": sprintf("%s/v1/permissions/check", [b.url]),
+    "headers": {
+        "content-type": "application/json",
+        "authorization": sprintf("Bearer %s", [opa.runtime().env[b.token_env]]),
+    },
+    "body": {
+        "resource": {"objectType": b.resource_type, "objectId": actions.render(b.resource_id)},
+        "permission": actions.render(b.permission),
+        "subject": {"object": {"objectType": object.get(b, "subject_type", "user"), "objectId": principal.id}},
+        "consistency": {"minimizeLatency": true},
+    },
+    "timeout": timeout(b),
+    "raise_error": false,
+})) if b.type == "spicedb"
+
+allowed(b, response) if {
+    b.type == "opa"
+    response.body.result == true
+}
+
+allowed(b, response) if {
+    b.type == "opa"
+    response.body.result.allow == true
+}
+
+allowed(b, response) if {
+    b.type == "webhook"
+    response.body.allow == true
+}
+
+allowed(b, response) if {
+    b.type == "spicedb"
+    response.body.permissionship == "PERMISSIONSHIP_HAS_PERMISSION"
+}
+
+vote(b) := "allow" if {
+    response := call(b)
+    response.status_code == 200
+    allowed(b, response)
+} else := "deny" if {
+    call(b).status_code == 200
+} else := object.get(b, "on_error", "deny")
+
+default engine_vote := "deny"
+
+engine_vote := "allow" if engine_allows
+
+votes["engine"] := engine_vote
+
+# Under degradation the backends are not called; degradation.rego denies instead.
+votes[b.name] := vote(b) if {
+    consulted
+    not degradation.skipped("combiner")
+    some b in backends
+}
+
+weight(name) := object.get(settings, "engine_weight", 1) if {
+    name == "engine"
+} else := object.get([b | some b in backends; b.name == name][0], "weight", 1)
+
+weight_voting(choice) := sum([weight(name) | some name, v in votes; v == choice])
+
+default verdict := "allow"
+
+verdict := "deny" if {
+    strategy == "any_deny"
+    some _, v in votes
+    v == "deny"
+}
+
+verdict := "deny" if {
+    strategy == "unanimous_allow"
+    some _, v in votes
+    v != "allow"
+}
+
+verdict := "deny" if {
+    strategy == "weighted"
+    cast := weight_voting("allow") + weight_voting("deny")
+    not weight_voting("allow") > object.get(settings, "threshold", 0.5) * cast
+}
+
+# The engine denied on policy grounds but was outvoted.
+outvoted if {
+    strategy == "weighted"
+    consulted
+    not engine_allows
+    verdict == "allow"
+}
+
+deny contains {
+    "rule": sprintf("combiner.%s", [strategy]),
+    "code": "combined_deny",
+    "message": sprintf("denied by %s: %s", [strategy, concat(", ", sort([name | some name, v in votes; v != "allow"]))]),
+    "details": {"votes": votes},
+} if {
+    consulted
+    engine_allows
+    verdict == "deny"
+}
+
+record := {"strategy": strategy, "votes": votes, "verdict": verdict} if consulted
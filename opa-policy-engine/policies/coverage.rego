@@ -0,0 +1,21 @@
+package authz.coverage
+
+import data.authz.mcp
+import data.authz.openapi
+import data.authz.rules as authz_rules
+import data.authz.settings.config
+
+# Everything live traffic can be matched against, for tools/coverage_report.py:
+# the named routes (config.routes, blueprints, generated OpenAPI and MCP policies,
+# as in routes.matched) and the operator rules (as rules.applied).
+routes contains r.name if some r in config.routes
+
+routes contains sprintf("blueprint.%s", [bp.name]) if some bp in config.blueprints
+
+routes contains sprintf("openapi.%s", [name]) if some name in openapi.backends
+
+routes contains sprintf("mcp.%s", [name]) if some name in mcp.servers
+
+rules contains sprintf("rules.%s", [r.name]) if some r in authz_rules.configured
+
+inventory := {"routes": sort(routes), "rules": sort(rules)}
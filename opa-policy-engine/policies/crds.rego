@@ -0,0 +1,55 @@
+package authz.crds
+
+import data.authz.settings.config
+
+# Operator rules from AgentAuthPolicy resources (examples/agentauthpolicy-crd.yaml).
+# With config.agent_auth_policies.enabled, the kube-mgmt sidecar of every replica
+# replicates them into data.kubernetes.agentauthpolicies.<namespace>.<name> and
+# their spec.rules join config.rules (see rules.configured), so policies applied
+# with kubectl or GitOps take effect on all replicas within seconds, without a
+# ConfigMap mount or a restart. A rule's name becomes <namespace>.<policy>.<rule>,
+# its id rules.<namespace>.<policy>.<rule>. Only namespaces matching
+# config.agent_auth_policies.namespaces (globs, default all) are accepted. Operator
+# rules only deny, so a policy can restrict traffic but never open it up.
+
+settings := object.get(config, "agent_auth_policies", {})
+
+default enabled := false
+
+enabled if settings.enabled == true
+
+default replicated := {}
+
+replicated := data.kubernetes.agentauthpolicies
+
+accepted(_) if not settings.namespaces
+
+accepted(namespace) if {
+    some pattern in settings.namespaces
+    glob.match(pattern, [], namespace)
+}
+
+compiled contains object.union(rule, {
+    "name": sprintf("%s.%s.%s", [namespace, name, rule.name]),
+    "source": sprintf("%s/%s", [namespace, name]),
+}) if {
+    enabled
+    some namespace, policies in replicated
+    accepted(namespace)
+    some name, policy in policies
+    some rule in policy.spec.rules
+}
+
+rules := sort(compiled)
+
+# Served at /v1/data/authz/crds/report.
+report := {
+    "enabled": enabled,
+    "accepted": sort({r.source | some r in compiled}),
+    "rejected": sort({sprintf("%s/%s", [namespace, name]) |
+        some namespace, policies in replicated
+        not accepted(namespace)
+        some name, _ in policies
+    }),
+    "rules": count(compiled),
+}
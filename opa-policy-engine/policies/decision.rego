@@ -0,0 +1,551 @@
+package authz
+
+import data.authz.a2a
+import data.authz.actions
+import data.authz.agent
+import data.authz.binding
+import data.authz.autonomy
+import data.authz.blueprints
+import data.authz.bypass
+import data.authz.callers
+import data.authz.client
+import data.authz.combiner
+import data.authz.degradation
+import data.authz.delegation
+import data.authz.dpop
+import data.authz.dual_control
+import data.authz.evaluation
+import data.authz.exchange
+import data.authz.fips
+import data.authz.grants
+import data.authz.images
+import data.authz.internal_token
+import data.authz.mcp
+import data.authz.namespaces
+import data.authz.labels
+import data.authz.lib
+import data.authz.openapi
+import data.authz.overrides
+import data.authz.principal
+import data.authz.profiles
+import data.authz.ratelimit
+import data.authz.request
+import data.authz.request_object
+import data.authz.residency
+import data.authz.routes
+import data.authz.rules
+import data.authz.scopes
+import data.authz.shedding
+import data.authz.token
+import data.authz.webhook
+import data.authz.workloads
+import data.authz.settings.config
+
+# Gateway requests are allowed unless one of the feature packages contributes a
+# deny reason. Each reason is an object with at least rule, code and message, and
+# optionally class, status, soft, headers and details.
+deny contains reason if {
+    some reason in client.deny
+}
+
+deny contains reason if {
+    some reason in agent.deny
+}
+
+deny contains reason if {
+    some reason in delegation.deny
+}
+
+deny contains reason if {
+    some reason in dpop.deny
+}
+
+deny contains reason if {
+    some reason in fips.deny
+}
+
+deny contains reason if {
+    some reason in scopes.deny
+}
+
+deny contains reason if {
+    some reason in autonomy.deny
+}
+
+deny contains reason if {
+    some reason in dual_control.deny
+}
+
+deny contains reason if {
+    some reason in blueprints.deny
+}
+
+deny contains reason if {
+    some reason in openapi.deny
+}
+
+deny contains reason if {
+    some reason in mcp.deny
+}
+
+deny contains reason if {
+    some reason in routes.deny
+}
+
+deny contains reason if {
+    some reason in residency.deny
+}
+
+deny contains reason if {
+    some reason in token.deny
+}
+
+deny contains reason if {
+    some reason in overrides.deny
+}
+
+deny contains reason if {
+    some reason in rules.deny
+}
+
+deny contains reason if {
+    some reason in webhook.deny
+}
+
+deny contains reason if {
+    some reason in callers.deny
+}
+
+deny contains reason if {
+    some reason in exchange.deny
+}
+
+deny contains reason if {
+    some reason in binding.deny
+}
+
+deny contains reason if {
+    some reason in request_object.deny
+}
+
+deny contains reason if {
+    some reason in workloads.deny
+}
+
+deny contains reason if {
+    some reason in namespaces.deny
+}
+
+deny contains reason if {
+    some reason in images.deny
+}
+
+deny contains reason if {
+    some reason in ratelimit.deny
+}
+
+deny contains reason if {
+    some reason in a2a.deny
+}
+
+deny contains reason if {
+    some reason in degradation.deny
+}
+
+deny contains reason if {
+    some reason in internal_token.deny
+}
+
+# Dependency failures are let through for gateways whose profile fails open,
+# unless the engine is degraded to deny_all.
+failed_open contains reason if {
+    some reason in deny
+    profiles.fail_open
+    error_class(reason) == "dependency"
+    not credential_check(reason)
+    not degradation.tier == "deny_all"
+}
+
+# Checks that establish or replace the caller's credentials never fail open: a
+# token that could not be verified, introspected or decrypted is no identity, and
+# a failed exchange would send the caller's own token upstream.
+credential_check(reason) if startswith(reason.rule, "token.")
+
+credential_check(reason) if startswith(reason.rule, "exchange.")
+
+credential_check(reason) if {
+    reason.rule in {"degradation.token_exchange", "degradation.introspection", "degradation.decryption"}
+}
+
+# Reasons from rules outside the calling gateway's policy sets are dropped. Gateway
+# authentication, degradation and token or config failures always apply: a
+# profile or caller listing only its policy rules must not switch them off.
+always_in_scope(reason) if error_class(reason) in {"token", "config"}
+
+always_in_scope(reason) if startswith(reason.rule, "callers.")
+
+always_in_scope(reason) if startswith(reason.rule, "degradation.")
+
+in_scope(reason) if always_in_scope(reason)
+
+in_scope(reason) if {
+    callers.in_scope(reason.rule)
+    profiles.in_scope(reason.rule)
+}
+
+applicable_deny contains reason if {
+    some reason in deny
+    not reason in failed_open
+    in_scope(reason)
+}
+
+# Extra context recorded in the decision log and passed to Envoy as dynamic metadata.
+metadata["correlation"] := request.correlation
+
+metadata["principal"] := principal.id
+
+metadata["identity_provider"] := token.provider.name if token.bearer
+
+# Stable hash of the request's salient fields (principal, method, host, path with
+# query, body), identical for exact retries so they can be grouped; request ids,
+# trace headers and timestamps are left out. Also sent upstream and on denials as
+# x-request-hash.
+salient["principal"] := principal.id
+
+salient["method"] := request.method
+
+salient["host"] := request.hostname
+
+salient["path"] := request.http.path
+
+salient["body"] := request.body
+
+request_hash := crypto.sha256(json.marshal(salient))
+
+metadata["request_hash"] := request_hash if request.is_gateway
+
+metadata["caller"] := callers.id if callers.authenticated
+
+metadata["trust_domain"] := workloads.trust_domain
+
+metadata["delegation"] := delegation.chain if delegation.delegated
+
+metadata["profile"] := profiles.name if request.is_gateway
+
+metadata["tier"] := degradation.tier if request.is_gateway
+
+metadata["failed_open"] := [{"rule": reason.rule, "code": reason.code} | some reason in failed_open] if {
+    count(failed_open) > 0
+}
+
+metadata["labels"] := labels.labels if request.is_gateway
+
+metadata["priority"] := shedding.class.name if request.is_gateway
+
+metadata["combiner"] := combiner.record
+
+metadata["trace"] := evaluation.steps if evaluation.recorded
+
+metadata["dual_control"] := dual_control.record
+
+metadata["override"] := {"id": o.id, "effect": o.effect, "reason": object.get(o, "reason", "")} if {
+    o := overrides.applied
+}
+
+metadata["discovery"] := routes.discovery
+
+# Routes and operator rules the request matched, for tools/coverage_report.py.
+metadata["coverage"] := {"routes": sort(routes.matched), "rules": sort(rules.applied)} if request.is_gateway
+
+# Allows that depend on a grant or an approval expire with it. The expiry and the
+# remaining lifetime bound how long a gateway may cache the decision.
+allow_expiries contains grants.covering(reason.rule).expires_at_ns if {
+    some reason in granted_deny
+}
+
+allow_expiries contains dual_control.expires_at_ns if dual_control.record
+
+metadata["grants"] := [grants.covering(reason.rule).id | some reason in granted_deny] if {
+    count(granted_deny) > 0
+}
+
+metadata["expires_at"] := time.format(min(allow_expiries)) if count(allow_expiries) > 0
+
+metadata["max_cache_ttl_seconds"] := floor((min(allow_expiries) - time.now_ns()) / 1000000000) if {
+    count(allow_expiries) > 0
+}
+
+# Decision caching (config.decision_cache): an allow may be reused for identical
+# requests for up to ttl_seconds, never past the token's exp. Denials and allows that
+# depend on the clock (grants, approvals, overrides, rule windows) are never cached,
+# nor are allows with monitored denials, which must reach the decision log each time.
+cache_bounds contains object.get(config, ["decision_cache", "ttl_seconds"], 0)
+
+cache_bounds contains floor((token.exp * 1000000000 - time.now_ns()) / 1000000000) if {
+    token.exp
+}
+
+# A cached allow replays its internal identity token, which must still be valid.
+cache_bounds contains internal_token.ttl_seconds if internal_token.minted
+
+uncacheable if count(allow_expiries) > 0
+
+uncacheable if overrides.applied
+
+# Every DPoP proof is single-use.
+uncacheable if dpop.checked
+
+uncacheable if rules.time_sensitive
+
+# Every rate-limited request must take a token.
+uncacheable if count(ratelimit.applicable) > 0
+
+uncacheable if count(monitored_deny) > 0
+
+# An allow that waived a failed dependency must ask it again once it is back.
+uncacheable if count(failed_open) > 0
+
+metadata["cache_ttl_seconds"] := ttl if {
+    allow
+    not uncacheable
+    ttl := min(cache_bounds)
+    ttl > 0
+}
+
+# Failure classes, so logs, metrics and clients can branch on the kind of denial:
+# "token" (missing, expired or malformed credentials), "config" (inconsistent
+# policy data), "dependency" (a service the decision needed failed) and "policy"
+# (a rule said no, the default).
+error_class(reason) := object.get(reason, "class", "policy")
+
+# Shape of denied responses: config.deny_response, overridden by the deciding
+# reason's `response` (operator rules set it). `format` is json (the default,
+# {"error", "class", "message"} plus the reason's details), problem (RFC 9457
+# application/problem+json with the same members as extensions), text (the
+# message) or template (`template`, a Go text/template over the JSON members plus
+# status, rule and path, served as `content_type`). `headers` are added.
+deny_response := object.union(object.get(config, "deny_response", {}), object.get(primary_deny, "response", {}))
+
+deny_format := object.get(deny_response, "format", "json")
+
+deny_status := object.get(primary_deny, "status", 403)
+
+deny_fields := object.union(
+    object.get(primary_deny, "details", {}),
+    {"error": primary_deny.code, "class": error_class(primary_deny), "message": primary_deny.message},
+)
+
+deny_content_type := {
+    "json": "application/json",
+    "problem": "application/problem+json",
+    "text": "text/plain; charset=utf-8",
+    "template": object.get(deny_response, "content_type", "text/plain; charset=utf-8"),
+}[deny_format]
+
+deny_body := json.marshal(deny_fields) if {
+    deny_format == "json"
+} else := json.marshal(object.union(deny_fields, {
+    "type": sprintf("%s%s", [object.get(deny_response, "type_base", "urn:authz:error:"), primary_deny.code]),
+    "title": primary_deny.code,
+    "status": deny_status,
+    "detail": primary_deny.message,
+    "instance": request.path,
+})) if {
+    deny_format == "problem"
+} else := primary_deny.message if {
+    deny_format == "text"
+} else := strings.render_template(deny_response.template, object.union(deny_fields, {
+    "status": deny_status,
+    "rule": primary_deny.rule,
+    "path": request.path,
+})) if {
+    deny_format == "template"
+}
+
+# Denials sampled for full capture (see tools/log_router.py): a stable share of
+# request hashes, so retries of a captured request are captured too, optionally
+# limited to rule ids matching config.capture.rules.
+capture_rule(_) if not config.capture.rules
+
+capture_rule(rule) if {
+    some pattern in config.capture.rules
+    glob.match(pattern, ["."], rule)
+}
+
+capture if {
+    request.is_gateway
+    not allow
+    capture_rule(primary_deny.rule)
+    lib.bucket(request_hash) < object.get(config, ["capture", "sample_percent"], 0)
+}
+
+metadata["capture"] := true if capture
+
+metadata["error_class"] := error_class(primary_deny) if not allow
+
+metadata["rule"] := primary_deny.rule if not allow
+
+metadata["code"] := primary_deny.code if not allow
+
+# Soft denies ask the client to retry (e.g. after a token refresh) and are counted
+# apart from hard denials.
+metadata["soft_deny"] := true if primary_deny.soft
+
+metadata["monitored"] := [{"rule": reason.rule, "code": reason.code} | some reason in monitored_deny] if {
+    count(monitored_deny) > 0
+}
+
+# Gradual rollouts: a rule listed in config.enforcement.rollouts is enforced for
+# `percent` of principals, picked by a stable hash so each principal sees a
+# consistent decision. Denials for the others are only recorded.
+rollout(rule) := [r | some r in config.enforcement.rollouts; glob.match(r.rule, ["."], rule)][0]
+
+# Dry runs: with config.enforcement.enforce: false nothing is enforced, and an
+# operator rule with `enforce: false` is only recorded. Either way the request is
+# allowed and the denial is listed under monitored. A toggle pushed to
+# data.enforcement.<rule id> through the admin API takes precedence over the
+# rule's own setting.
+dry_run(_) if config.enforcement.enforce == false
+
+dry_run(rule) if data.enforcement[rule].enforce == false
+
+dry_run(rule) if {
+    not data.enforcement[rule]
+    some r in rules.configured
+    r.enforce == false
+    rule == sprintf("rules.%s", [r.name])
+}
+
+enforced(reason) if {
+    not dry_run(reason.rule)
+    not rollout(reason.rule)
+}
+
+enforced(reason) if {
+    not dry_run(reason.rule)
+    rollout(reason.rule)
+    not principal.id
+}
+
+enforced(reason) if {
+    not dry_run(reason.rule)
+    lib.bucket(concat(":", [reason.rule, principal.id])) < rollout(reason.rule).percent
+}
+
+# Denials waived by a live grant for this principal (see grants.rego).
+granted_deny contains reason if {
+    some reason in applicable_deny
+    enforced(reason)
+    grants.covering(reason.rule)
+}
+
+engine_enforced_deny contains reason if {
+    some reason in applicable_deny
+    enforced(reason)
+    not grants.covering(reason.rule)
+}
+
+# The engine's own denials, less policy denials outvoted by weighted combiner
+# backends, plus the combiner's (see combiner.rego).
+enforced_deny contains reason if {
+    some reason in engine_enforced_deny
+    not outvoted(reason)
+}
+
+enforced_deny contains reason if {
+    some reason in combiner.deny
+}
+
+outvoted(reason) if {
+    combiner.outvoted
+    error_class(reason) == "policy"
+}
+
+monitored_deny contains reason if {
+    some reason in applicable_deny
+    not enforced(reason)
+}
+
+allow if {
+    request.is_gateway
+    count(enforced_deny) == 0
+}
+
+# Exemption overrides waive policy denials only: gateway authentication,
+# degradation, combiner votes and token, config or dependency failures still deny.
+exemptable(reason) if {
+    error_class(reason) == "policy"
+    not always_in_scope(reason)
+    not reason in combiner.deny
+}
+
+allow if {
+    request.is_gateway
+    overrides.exempt
+    every reason in enforced_deny {
+        exemptable(reason)
+    }
+}
+
+# The first enforced deny reason in set order determines the denied response, but a
+# hard deny always wins over a soft one: refreshing the token would not help.
+hard_deny := [reason | some reason in enforced_deny; not reason.soft]
+
+primary_deny := hard_deny[0] if {
+    count(hard_deny) > 0
+} else := [reason | some reason in enforced_deny][0]
+
+# Headers the engine sets on allowed requests before the gateway forwards them upstream.
+engine_headers["authorization"] := sprintf("Bearer %s", [exchange.exchanged])
+
+engine_headers["x-request-hash"] := request_hash if request.is_gateway
+
+# The resolved end user and acting agent of delegated tokens. Copies sent by the
+# caller are always stripped, so upstreams can trust them.
+engine_headers["x-end-user"] := delegation.end_user if request.is_gateway
+
+engine_headers["x-acting-agent"] := delegation.acting_agent if request.is_gateway
+
+# The engine-signed internal identity token (see internal_token.rego).
+engine_headers["x-internal-identity"] := internal_token.minted if request.is_gateway
+
+identity_headers := ["x-end-user", "x-acting-agent", "x-internal-identity"]
+
+# Header actions (see actions.rego) add to the engine's headers but never override them.
+upstream_headers := object.union(actions.request_headers, engine_headers)
+
+removable contains name if some name in identity_headers
+
+removable contains name if some name in actions.removed
+
+removed_headers := sort([name | some name in removable; not upstream_headers[name]])
+
+response_headers := object.union(actions.response_headers, {"x-authz-tier": degradation.tier})
+
+# Response handed back to the Envoy plugin. Bypassed infrastructure requests are
+# allowed before any rule is evaluated. Denied gateway requests carry a JSON body
+# describing the reason; reasons may override the default 403 status and add
+# response headers. Both tell the client the degradation tier in x-authz-tier.
+result := {"allowed": true, "dynamic_metadata": {"correlation": request.correlation, "bypass": true}} if {
+    bypass.bypassed
+} else := {
+    "allowed": true,
+    "headers": upstream_headers,
+    "request_headers_to_remove": removed_headers,
+    "response_headers_to_add": response_headers,
+    "dynamic_metadata": metadata,
+} if {
+    allow
+} else := {
+    "allowed": false,
+    "http_status": deny_status,
+    "headers": object.union_n([
+        object.get(primary_deny, "headers", {}),
+        object.get(deny_response, "headers", {}),
+        {"content-type": deny_content_type, "x-request-hash": request_hash, "x-authz-tier": degradation.tier},
+    ]),
+    "dynamic_metadata": metadata,
+    "body": deny_body,
+} if {
+    primary_deny
+} else := {"allowed": false}
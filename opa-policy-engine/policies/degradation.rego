@@ -0,0 +1,178 @@
+package authz.degradation
+
+import data.authz.combiner
+import data.authz.dpop
+import data.authz.exchange
+import data.authz.images
+import data.authz.lib
+import data.authz.ratelimit
+import data.authz.request
+import data.authz.request_object
+import data.authz.rules
+import data.authz.token
+import data.authz.webhook
+import data.authz.settings.config
+
+# Degradation tiers. When services the engine calls go down, it degrades in steps
+# instead of waiting on timeouts for every decision:
+#
+#   full              every check runs;
+#   cached_only       only calls whose answers OPA caches run (JWKS, introspection,
+#                     JWE decryption, token exchange, image lookups, CEL
+#                     evaluations, request object client keys, webhooks with
+#                     cache_seconds);
+#                     rate limits and DPoP replay checks are skipped;
+#   local_rules_only  no outbound calls but the signing keys, which are cached;
+#   deny_all          every gateway request is refused with 503.
+#
+# Each of config.degradation.dependencies is probed with GET on its `url` at most
+# every probe_seconds; while it does not answer 200 the engine drops to its
+# `on_failure` tier (default cached_only). The most degraded tier wins. The
+# AUTHZ_DEGRADATION_TIER environment variable, or config.degradation.tier, forces a
+# minimum tier. A request needing a skipped check is refused with a dependency-class
+# 503, which fail-open profiles let through as they do failed calls.
+
+settings := object.get(config, "degradation", {})
+
+tiers := ["full", "cached_only", "local_rules_only", "deny_all"]
+
+probe(dependency) := http.send(lib.pinned({
+    "method": "GET",
+    "url": dependency.url,
+    "timeout": sprintf("%dms", [object.get(settings, "timeout_ms", 300)]),
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(settings, "probe_seconds", 5),
+    "raise_error": false,
+}))
+
+unhealthy contains name if {
+    some name, dependency in object.get(settings, "dependencies", {})
+    not probe(dependency).status_code == 200
+}
+
+forced := opa.runtime().env.AUTHZ_DEGRADATION_TIER if {
+    opa.runtime().env.AUTHZ_DEGRADATION_TIER in tiers
+} else := settings.tier
+
+candidates contains "full"
+
+candidates contains forced if forced in tiers
+
+candidates contains object.get(settings.dependencies[name], "on_failure", "cached_only") if {
+    some name in unhealthy
+}
+
+tier := tiers[max({i | some i, t in tiers; t in candidates})]
+
+default cached_webhook := false
+
+cached_webhook if webhook.hook.cache_seconds > 0
+
+# Whether OPA caches the answers of each check that calls out. skipped() reads only
+# this and the tier: the checks consult it, so it must not depend on them.
+cacheable := {
+    "rate_limit": false,
+    "dpop_replay": false,
+    "webhook": cached_webhook,
+    "token_exchange": true,
+    "introspection": true,
+    "images": true,
+    "decryption": true,
+    "combiner": false,
+    "cel": true,
+    "request_object_keys": true,
+}
+
+skipped(check) if {
+    tier == "cached_only"
+    cacheable[check] == false
+}
+
+skipped(check) if {
+    tier in {"local_rules_only", "deny_all"}
+    check in object.keys(cacheable)
+}
+
+# Checks calling out for this request.
+calls contains "rate_limit" if count(ratelimit.applicable) > 0
+
+calls contains "dpop_replay" if dpop.checked
+
+calls contains "webhook" if webhook.hook
+
+calls contains "token_exchange" if {
+    exchange.target
+    token.bearer
+}
+
+calls contains "introspection" if {
+    token.opaque
+    config.token.introspection
+}
+
+calls contains "images" if images.sensitive
+
+calls contains "decryption" if {
+    token.encrypted
+    config.token.decryption
+}
+
+calls contains "cel" if {
+    some r in rules.configured
+    r.cel
+    rules.selects(r)
+}
+
+calls contains "request_object_keys" if {
+    request_object.object_jws
+    request_object.client.jwks_url
+    not request_object.client.jwks
+}
+
+# Combiner backends are asked afresh for every request. Keyed on the backends
+# matching the request, not on whether they are consulted, which depends on the
+# engine's denials and so on this package.
+calls contains "combiner" if {
+    request.is_gateway
+    count(combiner.backends) > 0
+}
+
+retry_after := sprintf("%d", [object.get(settings, "probe_seconds", 5)])
+
+deny contains {
+    "rule": sprintf("degradation.%s", [check]),
+    "class": "dependency",
+    "code": "degraded",
+    "status": 503,
+    "message": sprintf("%s is unavailable while authorization is degraded to %s", [check, tier]),
+    "headers": {"retry-after": retry_after},
+    "details": {"tier": tier},
+} if {
+    some check in calls
+    skipped(check)
+    not tier == "deny_all"
+}
+
+deny contains {
+    "rule": "degradation.deny_all",
+    "class": "dependency",
+    "code": "degraded",
+    "status": 503,
+    "message": "authorization is degraded to deny_all",
+    "headers": {"retry-after": retry_after},
+    "details": {"tier": tier, "unhealthy": sort(unhealthy)},
+} if {
+    tier == "deny_all"
+}
+
+default forced_tier := null
+
+forced_tier := forced
+
+# Served at /v1/data/authz/degradation/report.
+report := {
+    "tier": tier,
+    "forced": forced_tier,
+    "unhealthy": sort(unhealthy),
+    "dependencies": sort(object.keys(object.get(settings, "dependencies", {}))),
+}
@@ -0,0 +1,86 @@
+package authz.delegation
+
+import data.authz.token
+import data.authz.settings.config
+
+# Delegation chains (RFC 8693 `act` claims). A token obtained on behalf of a user
+# names the agent acting for them in `act`, and that agent's own delegator, if
+# any, in a nested `act`: `actors` lists them from the current actor outwards.
+# config.delegation bounds the chain (max_depth), restricts who may appear in it
+# (actors, globs over client ids) and can require an end-user subject. A `may_act`
+# claim, when present, must name the current actor.
+
+default settings := {}
+
+settings := config.delegation
+
+delegated if token.claims.act
+
+# The walk yields every object nested under `act` keys; the path length is the
+# position in the chain.
+links := {count(path): link |
+    walk(token.claims.act, [path, link])
+    is_object(link)
+    every key in path {
+        key == "act"
+    }
+}
+
+actors := [links[i] | some i in numbers.range(0, count(links) - 1)] if delegated
+
+actor_id(actor) := actor.client_id
+
+actor_id(actor) := actor.sub if not actor.client_id
+
+chain := [actor_id(actor) | some actor in actors]
+
+end_user := token.subject if delegated
+
+acting_agent := chain[0]
+
+max_depth := object.get(settings, "max_depth", 1)
+
+allowed(id) if {
+    some pattern in settings.actors
+    glob.match(pattern, [], id)
+}
+
+reason(code, message) := {
+    "rule": "delegation.chain",
+    "class": "token",
+    "code": code,
+    "message": message,
+    "details": {"chain": chain},
+}
+
+deny contains reason("delegation_too_deep", sprintf("the delegation chain has %d actors, at most %d are allowed", [count(actors), max_depth])) if {
+    count(actors) > max_depth
+}
+
+deny contains reason("actor_not_allowed", sprintf("%s may not act on behalf of others", [id])) if {
+    count(object.get(settings, "actors", [])) > 0
+    some id in chain
+    not allowed(id)
+}
+
+deny contains reason("delegation_subject_missing", "delegated tokens must name the end user in `sub`") if {
+    delegated
+    settings.require_subject == true
+    not end_user
+}
+
+deny contains reason("delegation_subject_not_allowed", sprintf("%s may not delegate", [end_user])) if {
+    count(object.get(settings, "subjects", [])) > 0
+    end_user
+    not subject_allowed
+}
+
+subject_allowed if {
+    some pattern in settings.subjects
+    glob.match(pattern, [], end_user)
+}
+
+deny contains reason("actor_not_permitted", sprintf("the token's may_act claim does not name %s", [acting_agent])) if {
+    token.claims.may_act
+    actor_id(token.claims.may_act) != acting_agent
+}
@@ -0,0 +1,192 @@
+package authz.dpop
+
+import data.authz.degradation
+import data.authz.fips
+import data.authz.lib
+import data.authz.request
+import data.authz.token
+import data.authz.settings.config
+
+# DPoP (RFC 9449) proof of possession. A token bound to a key carries the key's
+# JWK thumbprint in cnf.jkt and must be presented under the DPoP scheme with a
+# proof in the DPoP header: a JWT of type dpop+jwt, signed with an asymmetric
+# algorithm by the key in its jwk header, for this request's method (htm) and
+# URL (htu), recent (iat within max_age_seconds), bound to the access token
+# (ath) and never seen before (jti). Replay is tracked by the rate limiter's
+# /nonce endpoint (tools/rate_limiter.py), so every replica sees every jti.
+# config.dpop.required demands bound tokens of every caller.
+
+default settings := {}
+
+settings := config.dpop
+
+proof := request.header("dpop")
+
+decoded := io.jwt.decode(proof)
+
+header := decoded[0]
+
+payload := decoded[1]
+
+bound if token.claims.cnf.jkt
+
+presented if token.scheme == "dpop"
+
+# In FIPS mode only the approved algorithms among them (see fips.rego).
+algorithms := [alg |
+    some alg in object.get(settings, "algorithms", ["ES256", "ES384", "RS256", "PS256", "EdDSA"])
+    fips.allowed(alg)
+]
+
+# RFC 7638 thumbprint: SHA-256 over the key's required members, serialized with
+# sorted keys and no whitespace, which is exactly what json.marshal produces.
+required_members := {"EC": ["crv", "kty", "x", "y"], "RSA": ["e", "kty", "n"], "OKP": ["crv", "kty", "x"]}
+
+thumbprint(jwk) := base64url.encode_no_pad(hex.decode(crypto.sha256(json.marshal({member: jwk[member] |
+    some member in required_members[jwk.kty]
+}))))
+
+signature_valid if {
+    header.alg in algorithms
+    io.jwt.decode_verify(proof, {"cert": json.marshal({"keys": [header.jwk]}), "alg": header.alg})[0]
+}
+
+# htu is compared without its scheme: TLS usually ends at the gateway, so the
+# engine may see http for an https request.
+htu_parts := regex.find_all_string_submatch_n(`^[a-zA-Z][a-zA-Z0-9+.-]*://([^/?#]+)([^?#]*)`, payload.htu, 1)[0]
+
+max_age := object.get(settings, "max_age_seconds", 300)
+
+problems contains "the DPoP header is missing" if not proof
+
+problems contains "the DPoP proof is not a JWT" if {
+    proof
+    not decoded
+}
+
+problems contains "the DPoP proof must have typ dpop+jwt" if {
+    decoded
+    not header.typ == "dpop+jwt"
+}
+
+problems contains "the DPoP proof's signature or algorithm is not valid" if {
+    decoded
+    not signature_valid
+}
+
+problems contains "the DPoP proof is for another method" if {
+    decoded
+    not payload.htm == request.method
+}
+
+problems contains "the DPoP proof is for another URL" if {
+    decoded
+    not htu_matches
+}
+
+problems contains "the DPoP proof is too old or issued in the future" if {
+    decoded
+    not fresh
+}
+
+problems contains "the DPoP proof is not bound to this access token" if {
+    decoded
+    not payload.ath == base64url.encode_no_pad(hex.decode(crypto.sha256(token.bearer)))
+}
+
+problems contains "the DPoP proof has no jti" if {
+    decoded
+    not payload.jti
+}
+
+problems contains "the DPoP proof's key does not match the token's cnf.jkt" if {
+    bound
+    decoded
+    not thumbprint(header.jwk) == token.claims.cnf.jkt
+}
+
+problems contains "the DPoP proof has already been used" if {
+    replay.status_code == 200
+    replay.body.fresh == false
+}
+
+htu_matches if {
+    split(htu_parts[1], ":")[0] == request.hostname
+    htu_parts[2] == request.path
+}
+
+fresh if {
+    is_number(payload.iat)
+    abs(time.now_ns() / 1000000000 - payload.iat) <= max_age
+}
+
+replay_url := object.get(settings, "replay_url", "http://localhost:9393/nonce")
+
+# The jti is remembered for twice the accepted age, covering clock skew either way.
+replay := http.send(lib.pinned({
+    "method": "POST",
+    "url": replay_url,
+    "headers": {"content-type": "application/json"},
+    "body": {"key": sprintf("dpop:%s:%s", [thumbprint(header.jwk), payload.jti]), "ttl_seconds": 2 * max_age},
+    "timeout": "500ms",
+    "raise_error": false,
+})) if {
+    signature_valid
+    payload.jti
+    not degradation.skipped("dpop_replay")
+}
+
+checked if bound
+
+checked if presented
+
+challenge := sprintf(`DPoP error="invalid_dpop_proof", algs="%s"`, [concat(" ", algorithms)])
+
+deny contains {
+    "rule": "dpop.proof",
+    "class": "token",
+    "code": "invalid_dpop_proof",
+    "status": 401,
+    "message": concat("; ", sort(problems)),
+    "headers": {"www-authenticate": challenge},
+} if {
+    checked
+    count(problems) > 0
+}
+
+deny contains {
+    "rule": "dpop.proof",
+    "class": "token",
+    "code": "invalid_token",
+    "status": 401,
+    "message": "DPoP-bound tokens must be presented with the DPoP scheme",
+    "headers": {"www-authenticate": challenge},
+} if {
+    bound
+    not presented
+}
+
+deny contains {
+    "rule": "dpop.required",
+    "class": "token",
+    "code": "dpop_required",
+    "status": 401,
+    "message": "the access token must be bound to a key with DPoP",
+    "headers": {"www-authenticate": challenge},
+} if {
+    settings.required == true
+    token.bearer
+    not bound
+}
+
+deny contains {
+    "rule": "dpop.proof",
+    "class": "dependency",
+    "code": "dpop_replay_check_failed",
+    "status": 503,
+    "message": "the DPoP proof could not be checked for replay",
+} if {
+    checked
+    replay
+    replay.status_code != 200
+}
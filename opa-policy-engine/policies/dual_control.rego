@@ -0,0 +1,77 @@
+package authz.dual_control
+
+import data.authz.lib
+import data.authz.principal
+import data.authz.readiness
+import data.authz.request
+import data.authz.settings.config
+
+# Two-person rule for high-risk routes: besides the requester's own token, a
+# second, distinct identity must approve the exact operation. An approval is a JWT
+# signed by a key in config.dual_control.approval_jwks for approval_audience, with
+# the approver as `sub`, the requester's principal (see principal.rego) as
+# `requester`, the exact `method` and `path`, and an `exp`. It arrives either in
+# the approval header or inside a record pushed to data.approvals through the OPA
+# data API and referenced by id; either way the approver is whoever signed it, so
+# writing data.approvals is not enough to approve.
+
+matches(route) if {
+    request.method in route.methods
+    lib.path_matches(route.paths, request.path)
+}
+
+route := [r | some r in config.dual_control.routes; matches(r)][0]
+
+requester := principal.id
+
+verified(jws) := claims if {
+    constraints := {
+        "cert": json.marshal(config.dual_control.approval_jwks),
+        "aud": config.dual_control.approval_audience,
+    }
+    [valid, _, claims] := io.jwt.decode_verify(jws, constraints)
+    valid
+    is_number(claims.exp)
+    claims.requester == requester
+    claims.method == request.method
+    claims.path == request.path
+}
+
+header_approval := verified(request.header(config.dual_control.approval_header))
+
+# Disabled in stateless mode: pushed approvals exist on a single replica.
+api_approval := verified(record.token) if {
+    not readiness.stateless
+    record := data.approvals[request.header(config.dual_control.approval_id_header)]
+}
+
+approval := header_approval if {
+    header_approval
+} else := api_approval
+
+approver := approval.sub
+
+# Nobody approves their own request.
+approved if {
+    is_string(approver)
+    approver != requester
+}
+
+# When the approval stops being valid; allows that rely on it expire with it.
+expires_at_ns := approval.exp * 1000000000
+
+# Both identities, recorded in the decision log for approved requests.
+record := {"route": route.name, "requester": requester, "approver": approver} if {
+    route
+    approved
+}
+
+deny contains {
+    "rule": sprintf("dual_control.%s", [route.name]),
+    "code": "approval_required",
+    "message": "this operation requires an approval from a second identity",
+    "details": {"approval_header": config.dual_control.approval_header, "approval_id_header": config.dual_control.approval_id_header},
+} if {
+    route
+    not approved
+}
@@ -0,0 +1,130 @@
+package authz.evaluation
+
+import data.authz
+import data.authz.combiner
+import data.authz.overrides
+import data.authz.request
+import data.authz.routes
+import data.authz.rules
+import data.authz.settings.config
+
+# Evaluation traces. For traced routes, denied decisions carry the ordered steps
+# that led to them in the decision log, under `trace`, so a compliance review can
+# reconstruct why access was refused from the archived record alone: the routes
+# matched, each operator rule in configured order (not applicable, passed or
+# denied), every other deny reason, the combiner votes and the final decision.
+# A denial's outcome says what the engine did with it: enforced, monitored (dry
+# run or rollout), granted, failed open, out of the caller's scope or outvoted.
+#
+# A route is traced when config.evaluation_trace.enabled is set and one of its
+# matched names matches config.evaluation_trace.routes (globs, default all), or
+# when its config.routes entry sets trace: true; trace: false opts a route out.
+# include_allows traces allowed decisions too. Rego cannot time its own rules:
+# tools/trace_timing.py replays each step of a recorded trace through the REST
+# API and adds the step's evaluation time to it.
+
+settings := object.get(config, "evaluation_trace", {})
+
+route_selected if not settings.routes
+
+route_selected if {
+    some pattern in settings.routes
+    some name in routes.matched
+    glob.match(pattern, [], name)
+}
+
+default traced := false
+
+traced if {
+    settings.enabled == true
+    route_selected
+    not routes.route.trace == false
+}
+
+traced if routes.route.trace == true
+
+recorded if {
+    request.is_gateway
+    traced
+    not authz.allow
+}
+
+recorded if {
+    request.is_gateway
+    traced
+    settings.include_allows == true
+}
+
+outcome(reason) := "failed_open" if {
+    reason in authz.failed_open
+} else := "out_of_scope" if {
+    not authz.in_scope(reason)
+} else := "granted" if {
+    reason in authz.granted_deny
+} else := "monitored" if {
+    reason in authz.monitored_deny
+} else := "outvoted" if {
+    not reason in authz.enforced_deny
+} else := "enforced"
+
+denial(reason) := {
+    "rule": reason.rule,
+    "code": reason.code,
+    "class": authz.error_class(reason),
+    "result": "deny",
+    "outcome": outcome(reason),
+}
+
+default route_result := "matched"
+
+route_result := "unmatched" if routes.unmatched
+
+route_steps := [{"rule": "routes.match", "result": route_result, "routes": sort(routes.matched)}]
+
+# An operator rule's result, evaluated on its own by tools/trace_timing.py.
+rule_result(r) := "deny" if {
+    rules.applies(r)
+    rules.violated(r)
+} else := "deny" if {
+    rules.selects(r)
+    rules.cel_failed(r)
+} else := "pass" if {
+    rules.applies(r)
+} else := "not_applicable"
+
+rule_step(r) := [denial(reason) | some reason in rules.deny; reason.rule == sprintf("rules.%s", [r.name])] if {
+    rule_result(r) == "deny"
+} else := [{"rule": sprintf("rules.%s", [r.name]), "result": rule_result(r)}]
+
+rule_steps := [step | some r in rules.configured; some step in rule_step(r)]
+
+# Deny reasons from the built-in checks and the other packages, ordered by rule.
+other_steps := [denial(reason) |
+    some reason in sort([r | some r in authz.deny; not startswith(r.rule, "rules.")])
+]
+
+default combiner_steps := []
+
+combiner_steps := [{"rule": sprintf("combiner.%s", [name]), "result": vote} |
+    some name, vote in combiner.record.votes
+    name != "engine"
+]
+
+default override_steps := []
+
+override_steps := [{"rule": "overrides.exempt", "result": "allow", "override": overrides.exempt.id}] if {
+    overrides.exempt
+}
+
+default decided := "deny"
+
+decided := "allow" if authz.allow
+
+final_step := {"rule": "decision", "result": decided, "deciding_rule": authz.primary_deny.rule} if {
+    not authz.allow
+} else := {"rule": "decision", "result": decided}
+
+steps := array.concat(
+    array.concat(array.concat(route_steps, rule_steps), array.concat(other_steps, combiner_steps)),
+    array.concat(override_steps, [final_step]),
+)
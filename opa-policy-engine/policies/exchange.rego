@@ -0,0 +1,78 @@
+package authz.exchange
+
+import data.authz.degradation
+import data.authz.lib
+import data.authz.routes
+import data.authz.token
+import data.authz.settings.config
+
+# OAuth 2.0 Token Exchange (RFC 8693). On routes with a `token_exchange` entry the
+# caller's token is exchanged at Keycloak for a narrower one, limited to the
+# route's audience and scope, which replaces the Authorization header of the
+# allowed request on its way upstream. The engine authenticates to Keycloak as
+# config.token_exchange.client_id with the secret from the environment variable
+# named by client_secret_env. The secret is added to the form only inside the
+# call (see with_secret), so no rule served by the data API holds it.
+
+target := routes.route.token_exchange
+
+form["grant_type"] := "urn:ietf:params:oauth:grant-type:token-exchange"
+
+form["subject_token"] := token.bearer
+
+form["subject_token_type"] := "urn:ietf:params:oauth:token-type:access_token"
+
+form["requested_token_type"] := "urn:ietf:params:oauth:token-type:access_token"
+
+form["client_id"] := config.token_exchange.client_id
+
+form["audience"] := target.audience
+
+form["scope"] := target.scope
+
+with_secret(params) := object.union(params, {"client_secret": opa.runtime().env[config.token_exchange.client_secret_env]})
+
+# Cached per subject token, so an agent's burst of calls costs one exchange.
+response := http.send(lib.pinned({
+    "method": "POST",
+    "url": config.token_exchange.token_url,
+    "headers": {"content-type": "application/x-www-form-urlencoded"},
+    "raw_body": urlquery.encode_object(with_secret(form)),
+    "timeout": "2s",
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(config.token_exchange, "cache_seconds", 60),
+    "raise_error": false,
+})) if {
+    target
+    token.bearer
+    not degradation.skipped("token_exchange")
+}
+
+exchanged := response.body.access_token if {
+    response.status_code == 200
+    is_string(response.body.access_token)
+}
+
+# Keycloak's OAuth error code, when the answer is a JSON object carrying one. A
+# failed exchange denies whatever the body holds, or the caller's unnarrowed token
+# would go upstream; so does one never sent, as when the secret is not set.
+default error := ""
+
+error := response.body.error if {
+    is_object(response.body)
+    is_string(response.body.error)
+}
+
+deny contains {
+    "rule": sprintf("exchange.%s", [routes.route.name]),
+    "class": "dependency",
+    "code": "token_exchange_failed",
+    "status": 503,
+    "message": sprintf("could not obtain a token for %s", [routes.route.name]),
+    "details": {"error": error},
+} if {
+    target
+    token.bearer
+    not exchanged
+    not degradation.skipped("token_exchange")
+}
@@ -0,0 +1,67 @@
+package authz.external
+
+import data.authz.a2a
+import data.authz.mcp
+import data.authz.principal
+import data.authz.request
+import data.authz.request_object
+import data.authz.routes
+import data.authz.token
+
+# Request summary handed to external authorizers (webhooks, other policy engines).
+# It gives them the same view the built-in rules have: the normalized request, all
+# gateway context extensions and the matched route. The shape is versioned and
+# described by schemas/external-input.v1.json; additive changes keep the version,
+# anything else bumps it.
+
+version := "v1"
+
+# Credentials never leave the engine.
+redacted_headers := {"authorization", "cookie", "proxy-authorization"}
+
+summary["version"] := version
+
+summary["request"] := {
+    "id": object.get(request.correlation, "request_id", ""),
+    "method": request.method,
+    "host": request.hostname,
+    "path": request.path,
+    "headers": {name: value | some name, value in request.headers; not name in redacted_headers},
+} if {
+    request.is_gateway
+}
+
+source["address"] := request.source_address
+
+source["principal"] := request.source_principal
+
+summary["source"] := source
+
+summary["context_extensions"] := request.context_extensions
+
+summary["route"] := routes.route
+
+summary["matched_routes"] := sort([name | some name in routes.matched])
+
+summary["principal"] := principal.id
+
+# MCP tool call in the body, with its arguments.
+summary["mcp"] := {"tool": mcp.tool, "arguments": mcp.arguments} if mcp.tool
+
+# Every tool call of the body, batches included.
+summary["mcp_calls"] := [{"tool": t, "arguments": a} | some [t, a] in mcp.invocations] if mcp.tool_call
+
+# A2A envelope: target agent, method, skill and task state.
+summary["a2a"] := a2a.envelope
+
+# Verified request object parameters (see request_object.rego).
+summary["request_object"] := request_object.params
+
+summary["token"] := {
+    "issuer": object.get(token.claims, "iss", ""),
+    "subject": object.get(token.claims, "sub", ""),
+    "client_id": object.get(token.claims, "azp", ""),
+    "scopes": sort([scope | some scope in token.scopes]),
+} if {
+    token.bearer
+}
@@ -0,0 +1,77 @@
+package authz.fips
+
+import data.authz.request_object
+import data.authz.token
+import data.authz.settings.config
+
+# Restricted-crypto (FIPS) mode, for regulated environments: on when
+# config.crypto.mode is "fips" or the engine runs with AUTHZ_CRYPTO_MODE=fips
+# (run-opa.sh --fips). Tokens, request objects and DPoP proofs must then be signed
+# with a FIPS 186 / SP 800-131A approved algorithm (RSA PKCS#1 v1.5, RSA-PSS or
+# ECDSA over P-256/384/521; no HMAC shared secrets, no EdDSA). TLS on the REST API
+# and outbound calls is restricted by running OPA's Go runtime in its FIPS 140-3
+# mode, which run-opa.sh --fips also turns on; `report` shows what is in force.
+
+default enabled := false
+
+enabled if opa.runtime().env.AUTHZ_CRYPTO_MODE == "fips"
+
+enabled if config.crypto.mode == "fips"
+
+approved_algorithms := {"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+# Outside FIPS mode any JWS signature algorithm, but never "none".
+signature_algorithms := approved_algorithms | {"HS256", "HS384", "HS512", "EdDSA"}
+
+allowed(alg) if {
+    not enabled
+    alg in signature_algorithms
+}
+
+allowed(alg) if alg in approved_algorithms
+
+default go_fips := false
+
+go_fips if contains(object.get(opa.runtime().env, "GODEBUG", ""), "fips140=on")
+
+go_fips if contains(object.get(opa.runtime().env, "GODEBUG", ""), "fips140=only")
+
+default mode := "standard"
+
+mode := "fips" if enabled
+
+default jws_algorithms := "any"
+
+jws_algorithms := sort(approved_algorithms) if enabled
+
+report := {
+    "mode": mode,
+    "jws_algorithms": jws_algorithms,
+    "go_fips140": go_fips,
+    "warnings": sort(warnings),
+}
+
+warnings contains "FIPS mode is on, but OPA's Go runtime is not in FIPS 140 mode: set GODEBUG=fips140=on" if {
+    enabled
+    not go_fips
+}
+
+reason(what, alg) := {
+    "rule": "fips.algorithms",
+    "class": "token",
+    "code": "algorithm_not_allowed",
+    "status": 401,
+    "message": sprintf("%s is signed with %s, which is not FIPS-approved", [what, alg]),
+}
+
+deny contains reason("the access token", alg) if {
+    enabled
+    alg := token.header.alg
+    not allowed(alg)
+}
+
+deny contains reason("the request object", alg) if {
+    enabled
+    alg := io.jwt.decode(request_object.object_jws)[0].alg
+    not allowed(alg)
+}
@@ -0,0 +1,25 @@
+package authz.grants
+
+import data.authz.principal
+import data.authz.readiness
+
+# Temporary exceptions pushed through the OPA data API to data.grants.<id>:
+#
+#   {"subject": "agent-1", "rules": ["openapi.orders.*"], "expires_at": "2026-01-01T12:00:00Z", "reason": "..."}
+#
+# While a grant is live, denials from the rules it names are waived for its
+# subject. Allows that depend on a grant expire with it.
+
+active contains object.union(grant, {"id": id, "expires_at_ns": expires_at_ns}) if {
+    not readiness.stateless
+    some id, grant in data.grants
+    grant.subject == principal.id
+    expires_at_ns := time.parse_rfc3339_ns(grant.expires_at)
+    time.now_ns() < expires_at_ns
+}
+
+# The live grant that waives denials from a rule, preferring the longest-lived.
+covering(rule) := grant if {
+    matching := [g | some g in active; some pattern in g.rules; glob.match(pattern, ["."], rule)]
+    grant := [g | some g in matching; g.expires_at_ns == max([m.expires_at_ns | some m in matching])][0]
+}
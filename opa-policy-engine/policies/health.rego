@@ -0,0 +1,37 @@
+package system.health
+
+import data.authz.degradation
+import data.authz.providers
+import data.authz.settings.config
+
+# Custom health checks served by OPA at /health/live and /health/ready. An
+# engine is ready once the policy configuration is loaded and, when it verifies
+# tokens itself, the JWKS of every identity provider has been fetched; until then Kubernetes and Istio keep
+# traffic away from it. The fetch fills the cache token verification reads from.
+
+default live := true
+
+config_loaded if is_array(config.routes)
+
+jwks_fetched if not config.token.verify
+
+jwks_fetched if {
+    config.token.verify
+    every p in providers.configured {
+        http.send(providers.jwks_request(p)).status_code == 200
+    }
+}
+
+default ready := false
+
+ready if {
+    config_loaded
+    jwks_fetched
+}
+
+# /health/undegraded fails while the engine runs below the full tier (see
+# degradation.rego), for monitors rather than Kubernetes probes: every replica sees
+# the same outage, and taking them all out of rotation helps no one.
+default undegraded := false
+
+undegraded if degradation.tier == "full"
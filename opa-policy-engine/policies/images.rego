@@ -0,0 +1,141 @@
+package authz.images
+
+import data.authz.degradation
+import data.authz.lib
+import data.authz.mcp
+import data.authz.request
+import data.authz.settings.config
+import data.authz.workloads
+
+# Signed-image requirements for sensitive operations. A request matching an entry
+# of config.images.sensitive (paths and/or MCP tool globs) is only allowed when
+# every image the calling workload runs is allow-listed and signed:
+#
+#   1. The workload's SPIFFE id (spiffe://<td>/ns/<namespace>/sa/<service account>)
+#      names its pods; the Kubernetes API (config.images.kube_api, typically a
+#      `kubectl proxy` sidecar) lists them with the digests of their images.
+#   2. Each digest must belong to a repository in config.images.allowed (globs).
+#   3. The verifier (config.images.verifier_url, an API in the style of the
+#      sigstore policy-controller) must report the image as signed; its SBOM
+#      attestation is required too when config.images.require_sbom is set.
+#
+# Both lookups are cached for cache_seconds, so the checks cost one round trip
+# per workload and image per period.
+
+sensitive if {
+    some entry in object.get(config, ["images", "sensitive"], [])
+    path_matches(entry)
+    tool_matches(entry)
+}
+
+path_matches(entry) if not entry.paths
+
+path_matches(entry) if lib.path_matches(entry.paths, request.path)
+
+tool_matches(entry) if not entry.mcp_tools
+
+tool_matches(entry) if {
+    some pattern in entry.mcp_tools
+    some t in mcp.tools
+    glob.match(pattern, [], t)
+}
+
+identity := workloads.spiffe
+
+cache_seconds := object.get(config.images, "cache_seconds", 300)
+
+cached(params) := http.send(lib.pinned(object.union(params, {
+    "timeout": "2s",
+    "raise_error": false,
+    "force_cache": true,
+    "force_cache_duration_seconds": cache_seconds,
+})))
+
+pods := cached({
+    "method": "GET",
+    "url": sprintf("%s/api/v1/namespaces/%s/pods?fieldSelector=%s", [
+        config.images.kube_api,
+        identity[1],
+        urlquery.encode(sprintf("spec.serviceAccountName=%s,status.phase=Running", [identity[2]])),
+    ]),
+}) if {
+    sensitive
+    not degradation.skipped("images")
+}
+
+# imageID is "<repository>@sha256:<digest>", possibly with a docker-pullable:// prefix.
+images contains trim_prefix(status.imageID, "docker-pullable://") if {
+    some pod in pods.body.items
+    some status in pod.status.containerStatuses
+}
+
+repository(image) := split(image, "@")[0]
+
+allow_listed(image) if {
+    some pattern in config.images.allowed
+    glob.match(pattern, ["/"], repository(image))
+}
+
+verification(image) := cached({
+    "method": "POST",
+    "url": config.images.verifier_url,
+    "headers": {"content-type": "application/json"},
+    "body": {"image": image, "require_sbom": object.get(config.images, "require_sbom", false)},
+})
+
+verified(image) if {
+    response := verification(image)
+    response.status_code == 200
+    response.body.verified == true
+}
+
+reason(code, message) := {
+    "rule": "images.signed",
+    "code": code,
+    "status": 403,
+    "message": message,
+    "details": {"source_principal": workloads.principal},
+}
+
+deny contains reason("workload_unidentified", "sensitive operations need a verified workload identity") if {
+    sensitive
+    not identity
+}
+
+deny contains object.union(reason("image_lookup_failed", "the calling workload's images could not be looked up"), {
+    "class": "dependency",
+    "status": 503,
+}) if {
+    identity
+    pods.status_code != 200
+}
+
+deny contains reason("workload_images_unknown", "no running pods found for the calling workload") if {
+    pods.status_code == 200
+    count(images) == 0
+}
+
+deny contains reason("image_not_allowed", sprintf("image %s is not allow-listed", [image])) if {
+    some image in images
+    not allow_listed(image)
+}
+
+deny contains object.union(reason("image_verification_failed", "the image verifier is unavailable"), {
+    "class": "dependency",
+    "status": 503,
+}) if {
+    some image in images
+    allow_listed(image)
+    verification(image).status_code != 200
+}
+
+default unsigned_message := "image %s is not signed"
+
+unsigned_message := "image %s is not signed or lacks an SBOM attestation" if config.images.require_sbom == true
+
+deny contains reason("image_not_signed", sprintf(unsigned_message, [image])) if {
+    some image in images
+    allow_listed(image)
+    verification(image).status_code == 200
+    not verified(image)
+}
@@ -0,0 +1,89 @@
+package authz.internal_token
+
+import data.authz.delegation
+import data.authz.fips
+import data.authz.principal
+import data.authz.routes
+import data.authz.rules
+import data.authz.token
+import data.authz.workloads
+import data.authz.settings.config
+
+# Internal identity tokens. With config.internal_token.enabled, every allowed
+# request carries a short-lived JWT in x-internal-identity, signed by the engine,
+# stating what it verified: the principal, the token's roles and client, the
+# acting agent and workload, and the route and operator rules that applied.
+# Backends verify it against the engine's public key (`jwks`) instead of
+# re-validating the caller's token. The signing key is a private JWK (with kid and
+# alg) in the environment variable named by key_env, so it never sits in the data
+# files; it is read only inside signing_key, never held by a rule, so the data API
+# cannot serve it.
+
+settings := object.get(config, "internal_token", {})
+
+enabled if settings.enabled == true
+
+key_env := object.get(settings, "key_env", "AUTHZ_INTERNAL_TOKEN_KEY")
+
+signing_key(name) := json.unmarshal(opa.runtime().env[name])
+
+ttl_seconds := object.get(settings, "ttl_seconds", 60)
+
+now := floor(time.now_ns() / 1000000000)
+
+claims["iss"] := object.get(settings, "issuer", "opa-policy-engine")
+
+audience := settings.audience if {
+    settings.audience
+} else := routes.route.name
+
+claims["aud"] := audience
+
+claims["iat"] := now
+
+claims["exp"] := now + ttl_seconds
+
+claims["jti"] := uuid.rfc4122(sprintf("%s:%d", [principal.id, time.now_ns()]))
+
+claims["sub"] := principal.id
+
+claims["client_id"] := token.client_id
+
+claims["roles"] := sort(token.roles)
+
+claims["scope"] := concat(" ", sort(token.scopes))
+
+claims["agent"] := delegation.acting_agent
+
+claims["end_user"] := delegation.end_user
+
+claims["workload"] := workloads.principal
+
+claims["route"] := routes.route.name
+
+claims["rules"] := sort(rules.applied)
+
+minted := io.jwt.encode_sign(
+    {"typ": "JWT", "alg": signing_key(key_env).alg, "kid": signing_key(key_env).kid},
+    claims,
+    signing_key(key_env),
+) if {
+    enabled
+    fips.allowed(signing_key(key_env).alg)
+}
+
+# The public half of the signing key, served at /v1/data/authz/internal_token/jwks.
+private_members := ["d", "p", "q", "dp", "dq", "qi", "k"]
+
+jwks := {"keys": [json.remove(signing_key(key_env), private_members)]} if enabled
+
+deny contains {
+    "rule": "internal_token.signing",
+    "class": "config",
+    "code": "internal_token_unavailable",
+    "status": 500,
+    "message": "the internal identity token could not be signed",
+} if {
+    enabled
+    not minted
+}
@@ -0,0 +1,35 @@
+package authz.labels
+
+import data.authz.routes
+import data.authz.token
+import data.authz.settings.config
+
+# Bounded label values for decision metrics. Issuers and tenants outside the
+# configured allow-lists collapse into "other" so a new realm or a forged claim
+# cannot blow up metric cardinality; "none" marks a missing value.
+
+guard(value, allowed) := value if {
+    value in allowed
+} else := "other"
+
+default issuer := "none"
+
+issuer := guard(token.issuer, config.metric_labels.issuers)
+
+# Tenant claim if configured, else the Keycloak realm of the issuer.
+tenant_value := token.claims[config.metric_labels.tenant_claim]
+
+tenant_value := token.realm if {
+    not token.claims[config.metric_labels.tenant_claim]
+}
+
+default tenant := "none"
+
+tenant := guard(tenant_value, config.metric_labels.tenants)
+
+# Route names come from config.routes, which is already bounded.
+default route := "unmatched"
+
+route := routes.route.name
+
+labels := {"issuer": issuer, "tenant": tenant, "route": route}
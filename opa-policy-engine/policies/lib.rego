@@ -0,0 +1,54 @@
+package authz.lib
+
+import data.authz.settings.config
+
+# Shared helpers used by the feature packages.
+
+# Normalizes loose version strings ("1.2", "v0.3.1-beta") to "major.minor.patch"
+# so they can be compared with semver.compare.
+normalize_version(v) := sprintf("%s.%s.%s", [version_part(m[1]), version_part(m[2]), version_part(m[3])]) if {
+    m := regex.find_all_string_submatch_n(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?`, v, 1)[0]
+}
+
+version_part(s) := "0" if {
+    s == ""
+} else := format_int(to_number(s), 10)
+
+version_less(a, b) if semver.compare(normalize_version(a), normalize_version(b)) < 0
+
+# Unparseable versions never satisfy a minimum.
+version_at_least(a, b) if semver.compare(normalize_version(a), normalize_version(b)) >= 0
+
+# Matches a request path against a list of globs, e.g. "/mcp/**".
+path_matches(globs, path) if {
+    some pattern in globs
+    glob.match(pattern, ["/"], path)
+}
+
+hex_digits := {"0": 0, "1": 1, "2": 2, "3": 3, "4": 4, "5": 5, "6": 6, "7": 7, "8": 8, "9": 9, "a": 10, "b": 11, "c": 12, "d": 13, "e": 14, "f": 15}
+
+# Stable bucket in [0, 100) for a key, from the first 16 bits of its SHA-256.
+bucket(key) := (((d[0] * 16 + d[1]) * 16 + d[2]) * 16 + d[3]) % 100 if {
+    h := crypto.sha256(key)
+    d := [hex_digits[substring(h, i, 1)] | some i in numbers.range(0, 3)]
+}
+
+# Pinned trust for outbound calls. config.outbound.pinned maps host names to the only
+# certificates trusted for them (ca_cert_file or ca_cert_env: the server's own
+# certificate or its private CA, instead of the system pool) and an optional
+# server_name to verify instead of the URL's host. pinned(params) adds them to the
+# parameters of an http.send call; settings already in params win.
+pin_options := {
+    "ca_cert_file": "tls_ca_cert_file",
+    "ca_cert_env": "tls_ca_cert_env_variable",
+    "server_name": "tls_server_name",
+}
+
+pin(url) := config.outbound.pinned[regex.find_all_string_submatch_n(`^https://([^/:?#]+)`, url, 1)[0][1]]
+
+pinned(params) := object.union(
+    object.union({"tls_use_system_certs": false}, {name: p[key] | some key, name in pin_options; p[key]}),
+    params,
+) if {
+    p := pin(params.url)
+} else := params
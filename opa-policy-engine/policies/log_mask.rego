@@ -0,0 +1,125 @@
+package system.log
+
+import data.authz.settings.config
+
+# Decision log masking (OPA evaluates data.system.log.mask for every decision log
+# event). With config.privacy.deidentify on, subject identifiers and IP addresses
+# are replaced by keyed HMAC pseudonyms: the same value always maps to the same
+# pseudonym, so events can still be correlated internally, but the key
+# (AUTHZ_DEIDENTIFY_KEY) is needed to link a pseudonym back to a person or host.
+
+deidentify if config.privacy.deidentify == true
+
+# The key is read inside the function, so no rule served by the data API holds it.
+keyed if opa.runtime().env.AUTHZ_DEIDENTIFY_KEY != ""
+
+pseudonym(value) := sprintf("anon:%s", [substring(crypto.hmac.sha256(value, opa.runtime().env.AUTHZ_DEIDENTIFY_KEY), 0, 16)])
+
+http := input.input.attributes.request.http
+
+# Tokens are credentials and carry the subject; they are never logged, whether or
+# not deidentify is on.
+mask contains "/input/attributes/request/http/headers/authorization" if {
+    http.headers.authorization
+}
+
+mask contains "/input/request/headers/authorization" if {
+    input.input.request.headers.authorization
+}
+
+# Exchanged tokens (see exchange.rego) are credentials for the upstream.
+mask contains "/result/headers/authorization" if {
+    input.result.headers.authorization
+}
+
+# So are internal identity tokens (see internal_token.rego).
+mask contains "/result/headers/x-internal-identity" if {
+    input.result.headers["x-internal-identity"]
+}
+
+# Profiles can limit decision logging to denies, or turn it off (see profiles.rego).
+log_level := level if {
+    level := data.authz.profiles.profile.log with input as input.input
+}
+
+drop if log_level == "none"
+
+drop if {
+    log_level == "denies"
+    input.result.allowed == true
+}
+
+# Gateway keys (see callers.rego) are secrets and are always removed.
+mask contains "/input/attributes/contextExtensions/gateway_key" if {
+    input.input.attributes.contextExtensions.gateway_key
+}
+
+pseudonymized["/input/attributes/source/address/socketAddress/address"] := input.input.attributes.source.address.socketAddress.address
+
+pseudonymized["/input/attributes/request/http/headers/x-forwarded-for"] := http.headers["x-forwarded-for"]
+
+pseudonymized["/input/attributes/request/http/headers/x-real-ip"] := http.headers["x-real-ip"]
+
+pseudonymized["/result/dynamic_metadata/correlation/source"] := input.result.dynamic_metadata.correlation.source
+
+pseudonymized["/result/dynamic_metadata/principal"] := input.result.dynamic_metadata.principal
+
+pseudonymized["/result/dynamic_metadata/dual_control/requester"] := input.result.dynamic_metadata.dual_control.requester
+
+pseudonymized["/result/dynamic_metadata/dual_control/approver"] := input.result.dynamic_metadata.dual_control.approver
+
+# The delegated end user and acting agent sent upstream (see delegation.rego).
+pseudonymized["/result/headers/x-end-user"] := input.result.headers["x-end-user"]
+
+pseudonymized["/result/headers/x-acting-agent"] := input.result.headers["x-acting-agent"]
+
+# Subjects a record can name anywhere: the principal, the delegation chain and the
+# token's (unverified) subject. Deny bodies and evaluation traces are rewritten
+# with every one of them replaced by its pseudonym.
+authorization := http.headers.authorization if {
+    http.headers.authorization
+} else := input.input.request.headers.authorization
+
+subject_values contains input.result.dynamic_metadata.principal
+
+subject_values contains id if some id in input.result.dynamic_metadata.delegation
+
+subject_values contains input.result.headers["x-end-user"]
+
+subject_values contains input.result.headers["x-acting-agent"]
+
+subject_values contains io.jwt.decode(trim_space(substring(authorization, indexof(authorization, " ") + 1, -1)))[1].sub
+
+subjects := {s | some s in subject_values; is_string(s); s != ""}
+
+scrub(text) := strings.replace_n({s: pseudonym(s) | some s in subjects}, text)
+
+rewritten["/result/dynamic_metadata/delegation"] := [pseudonym(id) | some id in input.result.dynamic_metadata.delegation]
+
+rewritten["/result/body"] := scrub(input.result.body) if is_string(input.result.body)
+
+rewritten["/result/dynamic_metadata/trace"] := json.unmarshal(scrub(json.marshal(input.result.dynamic_metadata.trace)))
+
+mask contains {"op": "upsert", "path": path, "value": pseudonym(value)} if {
+    deidentify
+    some path, value in pseudonymized
+}
+
+mask contains {"op": "upsert", "path": path, "value": value} if {
+    deidentify
+    keyed
+    some path, value in rewritten
+}
+
+# Without a key, erase the values rather than log them in the clear.
+mask contains path if {
+    deidentify
+    not keyed
+    some path, _ in pseudonymized
+}
+
+mask contains path if {
+    deidentify
+    not keyed
+    some path, _ in rewritten
+}
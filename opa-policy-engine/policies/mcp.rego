@@ -0,0 +1,165 @@
+package authz.mcp
+
+import data.authz.lib
+import data.authz.request
+
+# JSON-RPC messages in an MCP request body: a single message, or every element of
+# a batch.
+default messages := []
+
+messages := [request.body] if is_object(request.body)
+
+messages := [m | some m in request.body; is_object(m)] if is_array(request.body)
+
+calls := [m | some m in messages; m.method == "tools/call"]
+
+tool_call if count(calls) > 0
+
+# Name and arguments of each tool call, for rules over tool calls (see the
+# mcp_tools matcher in rules.rego). `tool` and `arguments` are only defined for a
+# single call; matchers iterate over `tools` so a batch cannot hide a call.
+args(call) := call.params.arguments if {
+    is_object(call.params.arguments)
+} else := {}
+
+invocations contains [call.params.name, args(call)] if {
+    some call in calls
+    is_string(call.params.name)
+}
+
+tools contains name if some [name, _] in invocations
+
+tool := calls[0].params.name if {
+    count(calls) == 1
+    is_string(calls[0].params.name)
+}
+
+default arguments := {}
+
+arguments := args(calls[0]) if count(calls) == 1
+
+# Tool manifests generated by tools/mcp_manifest_to_policy.py and loaded as
+# data.mcp.<server>. Tools missing from the manifest, or not yet allowed, are denied.
+
+servers contains name if {
+    some name, server in data.mcp
+    lib.path_matches(server.paths, request.path)
+}
+
+# A POST to an MCP server must carry a JSON-RPC body the engine can read; one sent
+# with another content type, over the gateway's body limit or not as JSON would
+# otherwise skip every tool check.
+deny contains {
+    "rule": sprintf("mcp.%s", [name]),
+    "code": "unreadable_body",
+    "status": 400,
+    "message": sprintf("requests to the %s MCP server need a JSON-RPC body", [name]),
+} if {
+    some name in servers
+    request.method == "POST"
+    count(messages) == 0
+}
+
+deny contains {
+    "rule": sprintf("mcp.%s", [name]),
+    "code": "tool_not_allowed",
+    "message": "tools/call names no tool",
+} if {
+    some name in servers
+    some call in calls
+    not is_string(object.get(object.get(call, "params", {}), "name", null))
+}
+
+deny contains {
+    "rule": sprintf("mcp.%s", [name]),
+    "code": "tool_not_allowed",
+    "message": sprintf("tool %q is not in the %s manifest", [t, name]),
+} if {
+    some name in servers
+    some t in tools
+    not data.mcp[name].tools[t]
+}
+
+deny contains {
+    "rule": sprintf("mcp.%s.%s", [name, t]),
+    "code": "tool_not_allowed",
+    "message": sprintf("tool %q has not been allowed", [t]),
+} if {
+    some name in servers
+    some t in tools
+    data.mcp[name].tools[t].allowed == false
+}
+
+# Argument constraints of a tool, in its manifest entry:
+#   "constraints": {"<argument>": {"required": true, "pattern": "<regex>",
+#                   "not_pattern": "<regex>", "enum": [...], "min": n, "max": n}}
+# Only `required` applies to absent arguments. Patterns are matched against string
+# arguments as they are and against other values as JSON, so a nested argument
+# cannot hide a forbidden word. Every call of a batch is checked.
+constrained contains [name, t, values, arg, c] if {
+    some name in servers
+    some [t, values] in invocations
+    some arg, c in object.get(data.mcp[name].tools[t], "constraints", {})
+}
+
+present(values, arg) if arg in object.keys(values)
+
+text(value) := value if is_string(value) else := json.marshal(value)
+
+below(value, _) if not is_number(value)
+
+below(value, min) if value < min
+
+above(value, _) if not is_number(value)
+
+above(value, max) if value > max
+
+violations contains [name, t, sprintf("argument %q is required", [arg])] if {
+    some [name, t, values, arg, c] in constrained
+    c.required == true
+    not present(values, arg)
+}
+
+violations contains [name, t, sprintf("argument %q does not match %s", [arg, c.pattern])] if {
+    some [name, t, values, arg, c] in constrained
+    present(values, arg)
+    c.pattern
+    not regex.match(c.pattern, text(values[arg]))
+}
+
+violations contains [name, t, sprintf("argument %q matches the forbidden pattern %s", [arg, c.not_pattern])] if {
+    some [name, t, values, arg, c] in constrained
+    present(values, arg)
+    regex.match(c.not_pattern, text(values[arg]))
+}
+
+violations contains [name, t, sprintf("argument %q must be one of %v", [arg, c.enum])] if {
+    some [name, t, values, arg, c] in constrained
+    present(values, arg)
+    c.enum
+    not values[arg] in c.enum
+}
+
+violations contains [name, t, sprintf("argument %q must be a number of at least %v", [arg, c.min])] if {
+    some [name, t, values, arg, c] in constrained
+    present(values, arg)
+    is_number(c.min)
+    below(values[arg], c.min)
+}
+
+violations contains [name, t, sprintf("argument %q must be a number of at most %v", [arg, c.max])] if {
+    some [name, t, values, arg, c] in constrained
+    present(values, arg)
+    is_number(c.max)
+    above(values[arg], c.max)
+}
+
+deny contains {
+    "rule": sprintf("mcp.%s.%s.arguments", [name, t]),
+    "code": "argument_not_allowed",
+    "message": sprintf("tool %q: %s", [t, problems[0]]),
+    "details": {"violations": problems},
+} if {
+    some [name, t, _] in violations
+    problems := sort([problem | some [server, called, problem] in violations; server == name; called == t])
+}
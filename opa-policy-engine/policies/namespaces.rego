@@ -0,0 +1,78 @@
+package authz.namespaces
+
+import data.authz.request
+import data.authz.routes
+import data.authz.settings.config
+import data.authz.workloads
+
+# Namespace policies: Kubernetes NetworkPolicy semantics at L7, by the namespace
+# and service account of the caller's SPIFFE id. A policy in
+# config.workloads.policies selects routes (`routes`, names; absent selects all)
+# and lists the sources in `from`, each a `namespace` glob with optional
+# `service_accounts` globs. As with NetworkPolicies, allow policies are additive
+# and a route selected by any of them admits only their sources; `action: deny`
+# policies, like Istio's DENY authorization policies, reject their sources and
+# win over allows.
+
+default policies := []
+
+policies := config.workloads.policies
+
+namespace := workloads.spiffe[1]
+
+service_account := workloads.spiffe[2]
+
+action(policy) := object.get(policy, "action", "allow")
+
+selects(policy) if not policy.routes
+
+selects(policy) if routes.route.name in policy.routes
+
+source_matches(source) if {
+    glob.match(source.namespace, [], namespace)
+    account_matches(source)
+}
+
+account_matches(source) if not source.service_accounts
+
+account_matches(source) if {
+    some pattern in source.service_accounts
+    glob.match(pattern, [], service_account)
+}
+
+admits(policy) if {
+    some source in policy.from
+    source_matches(source)
+}
+
+selecting := [policy |
+    some policy in policies
+    action(policy) == "allow"
+    selects(policy)
+]
+
+reason(rule, message) := {
+    "rule": rule,
+    "code": "namespace_not_allowed",
+    "message": message,
+    "details": {"source_principal": workloads.principal},
+}
+
+deny contains reason(sprintf("namespaces.%s", [policy.name]), sprintf("workloads in %s may not make this call", [namespace])) if {
+    request.is_gateway
+    some policy in policies
+    action(policy) == "deny"
+    selects(policy)
+    admits(policy)
+}
+
+deny contains reason(sprintf("namespaces.%s", [selecting[0].name]), "the calling workload's namespace is not allowed by any namespace policy") if {
+    request.is_gateway
+    count(selecting) > 0
+    not admitted
+}
+
+admitted if {
+    some policy in selecting
+    admits(policy)
+}
@@ -0,0 +1,87 @@
+package authz.openapi
+
+import data.authz.lib
+import data.authz.request
+import data.authz.token
+
+# Route policies generated from backend OpenAPI specs by tools/openapi_to_policy.py
+# and loaded as data.openapi.<backend>. Requests under a backend's paths must hit
+# a declared operation, hold its scopes and respect its size limit.
+
+backends contains name if {
+    some name, backend in data.openapi
+    lib.path_matches(backend.paths, request.path)
+}
+
+path_operations(name) := [op |
+    some op in data.openapi[name].operations
+    glob.match(op.path, ["/"], request.path)
+]
+
+operation[name] := ops[0] if {
+    some name in backends
+    ops := [op | some op in path_operations(name); op.method == request.method]
+}
+
+scopes_satisfied(op) if count(op.scopes) == 0
+
+scopes_satisfied(op) if {
+    some alternative in op.scopes
+    every scope in alternative {
+        scope in token.scopes
+    }
+}
+
+deny contains {
+    "rule": sprintf("openapi.%s", [name]),
+    "code": "operation_not_found",
+    "status": 404,
+    "message": sprintf("%s is not part of the %s API", [request.path, name]),
+} if {
+    some name in backends
+    count(path_operations(name)) == 0
+}
+
+deny contains {
+    "rule": sprintf("openapi.%s", [name]),
+    "code": "method_not_allowed",
+    "status": 405,
+    "message": sprintf("%s is not allowed on %s", [request.method, request.path]),
+} if {
+    some name in backends
+    count(path_operations(name)) > 0
+    not operation[name]
+}
+
+deny contains {
+    "rule": sprintf("openapi.%s.%s", [name, op.operation_id]),
+    "code": "insufficient_scope",
+    "message": sprintf("operation %s requires one of the scope sets %v", [op.operation_id, op.scopes]),
+    "details": {"required_scopes": op.scopes},
+} if {
+    some name, op in operation
+    not scopes_satisfied(op)
+}
+
+deny contains {
+    "rule": sprintf("openapi.%s.%s", [name, op.operation_id]),
+    "code": "request_too_large",
+    "status": 413,
+    "message": sprintf("request body exceeds %d bytes", [op.max_body_bytes]),
+} if {
+    some name, op in operation
+    request.body_size > op.max_body_bytes
+}
+
+# A limit cannot be checked on a body of unknown size: forward the body with the
+# check, or send content-length.
+deny contains {
+    "rule": sprintf("openapi.%s.%s", [name, op.operation_id]),
+    "code": "length_required",
+    "status": 411,
+    "message": sprintf("the size of the request body must be known; the limit is %d bytes", [op.max_body_bytes]),
+} if {
+    some name, op in operation
+    op.max_body_bytes
+    not request.body_size
+}
@@ -0,0 +1,67 @@
+package authz.overrides
+
+import data.authz.readiness
+import data.authz.token
+
+# Per-subject overrides, managed at runtime through the data API (see
+# `policyctl override`) and consulted before any rule:
+#
+#   data.overrides.<id> = {"subject": "agent-1" | "client_id": "...", "effect": "allow" | "deny",
+#                          "expires_at": "<RFC 3339, optional>", "reason": "..."}
+#
+# A block denies regardless of the rules; an exemption allows regardless of the
+# policy rules, but not of token, config or dependency failures, gateway
+# authentication, degradation or combiner votes (see decision.rego). Blocks win
+# over exemptions.
+
+matches(override) if override.subject == token.subject
+
+matches(override) if override.client_id == token.client_id
+
+expired(override) if time.parse_rfc3339_ns(override.expires_at) <= time.now_ns()
+
+# An expires_at that is not an RFC 3339 time never keeps an override alive: the
+# override is ignored, and requests it matches are denied as misconfigured until
+# it is fixed or removed, so a broken block does not quietly lift.
+unparseable(override) if {
+    override.expires_at
+    not time.parse_rfc3339_ns(override.expires_at)
+}
+
+expired(override) if unparseable(override)
+
+live contains object.union(override, {"id": id}) if {
+    not readiness.stateless
+    some id, override in data.overrides
+    matches(override)
+    not expired(override)
+}
+
+block := [o | some o in live; o.effect == "deny"][0]
+
+exempt := [o | some o in live; o.effect == "allow"][0] if not block
+
+applied := block if {
+    block
+} else := exempt
+
+deny contains {
+    "rule": sprintf("overrides.%s", [block.id]),
+    "code": "subject_blocked",
+    "message": "this subject has been blocked by an administrator",
+} if {
+    block
+}
+
+deny contains {
+    "rule": sprintf("overrides.%s", [id]),
+    "class": "config",
+    "code": "invalid_config",
+    "status": 500,
+    "message": sprintf("override %s has an expires_at that is not an RFC 3339 time", [id]),
+} if {
+    not readiness.stateless
+    some id, override in data.overrides
+    matches(override)
+    unparseable(override)
+}
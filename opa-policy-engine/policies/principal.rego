@@ -0,0 +1,19 @@
+package authz.principal
+
+import data.authz.request
+import data.authz.token
+import data.authz.workloads
+
+# Identity a request is attributed to: the token subject, else the client id,
+# else the source workload's SPIFFE id (from its client certificate, normalized
+# across trust domains, see workloads.rego), else the source address.
+
+id := token.subject if {
+    token.subject
+} else := token.client_id if {
+    token.client_id
+} else := workloads.principal if {
+    workloads.peer_id
+} else := request.source_address if {
+    request.is_gateway
+}
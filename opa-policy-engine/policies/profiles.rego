@@ -0,0 +1,30 @@
+package authz.profiles
+
+import data.authz.request
+import data.authz.settings.config
+
+# Per-gateway profiles. Gateways with different jobs (ingress, egress, the MCP
+# gateway) name a profile of config.profiles in the `profile` context extension;
+# it selects the rule ids applied to their traffic (`rules`, globs), whether a
+# failing dependency denies or lets requests through (`fail_open`) and which
+# decisions are logged (`log`: all, denies or none, see log_mask.rego). Gateways
+# without the extension, or naming an unknown profile, get `default`.
+
+name := request.context_extensions.profile if {
+    config.profiles[request.context_extensions.profile]
+} else := "default"
+
+default profile := {}
+
+profile := config.profiles[name]
+
+in_scope(_) if not profile.rules
+
+in_scope(rule) if {
+    some pattern in profile.rules
+    glob.match(pattern, ["."], rule)
+}
+
+default fail_open := false
+
+fail_open if profile.fail_open == true
@@ -0,0 +1,135 @@
+package authz.providers
+
+import data.authz.lib
+import data.authz.settings.config
+
+# Identity providers the engine accepts tokens from. Each entry of
+# config.token.providers has a name, a type, its issuer and expected audience;
+# tokens are matched to a provider by their iss claim. A type is implemented by
+# the functions below, which every type defines: where its signing keys are
+# (jwks_url) and how its claims carry the client id, scopes, roles and groups.
+#
+#   keycloak  keys under <issuer>/protocol/openid-connect/certs; realm and
+#             client roles, space-separated `scope`
+#   oidc      keys from the issuer's discovery document
+#             (<issuer>/.well-known/openid-configuration)
+#   auth0     keys at <issuer>/.well-known/jwks.json; roles from roles_claim
+#             (Auth0 namespaces custom claims), else `permissions`
+#   azure_ad  Microsoft Entra ID v2.0 tenants: keys under the tenant's
+#             discovery/v2.0/keys, client id in azp or appid, scopes in `scp`,
+#             app roles in `roles`
+#
+# Any provider can set jwks_url, roles_claim and groups_claim to override its
+# type's defaults, and pins its signature algorithms with `algorithms` and
+# hmac_secret_env (see token.rego). Without config.token.providers, config.token's issuer,
+# audience and jwks_url form a single Keycloak provider.
+
+legacy := object.union(
+    {"name": "keycloak", "type": "keycloak"},
+    object.filter(config.token, ["issuer", "audience", "jwks_url", "jwks_cache_seconds", "algorithms", "hmac_secret_env"]),
+)
+
+default configured := []
+
+configured := config.token.providers if {
+    count(config.token.providers) > 0
+} else := [legacy] if {
+    config.token.issuer
+}
+
+for_issuer(iss) := [p | some p in configured; p.issuer == iss][0]
+
+# Opaque tokens name no issuer; they are introspected at the first provider.
+default default_provider := {"name": "keycloak", "type": "keycloak"}
+
+default_provider := configured[0]
+
+# --- jwks_url(provider) ---
+
+jwks_url(p) := p.jwks_url
+
+jwks_url(p) := sprintf("%s/protocol/openid-connect/certs", [p.issuer]) if {
+    not p.jwks_url
+    p.type == "keycloak"
+}
+
+jwks_url(p) := discovery(p).jwks_uri if {
+    not p.jwks_url
+    p.type == "oidc"
+}
+
+jwks_url(p) := sprintf("%s/.well-known/jwks.json", [trim_suffix(p.issuer, "/")]) if {
+    not p.jwks_url
+    p.type == "auth0"
+}
+
+jwks_url(p) := sprintf("%s/discovery/v2.0/keys", [trim_suffix(p.issuer, "/v2.0")]) if {
+    not p.jwks_url
+    p.type == "azure_ad"
+}
+
+discovery(p) := http.send(lib.pinned({
+    "method": "GET",
+    "url": sprintf("%s/.well-known/openid-configuration", [trim_suffix(p.issuer, "/")]),
+    "timeout": "2s",
+    "force_cache": true,
+    "force_cache_duration_seconds": 3600,
+    "raise_error": false,
+})).body
+
+# Cached across requests, so each provider sees one fetch per replica and cache
+# period. Other packages that need a provider's keys send the same request to
+# share the cache entry.
+jwks_request(p) := lib.pinned({
+    "method": "GET",
+    "url": jwks_url(p),
+    "timeout": "2s",
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(p, "jwks_cache_seconds", 300),
+    "raise_error": false,
+})
+
+# --- client_id(provider, claims) ---
+
+client_id(_, claims) := claims.azp
+
+client_id(p, claims) := claims.client_id if {
+    p.type == "oidc"
+    not claims.azp
+}
+
+client_id(p, claims) := claims.appid if {
+    p.type == "azure_ad"
+    not claims.azp
+}
+
+# --- scopes(provider, claims) ---
+
+scope_claim := {"keycloak": "scope", "oidc": "scope", "auth0": "scope", "azure_ad": "scp"}
+
+scopes(p, claims) := {scope | some scope in split(claims[scope_claim[p.type]], " "); scope != ""}
+
+# --- roles(provider, claims) ---
+
+roles(p, claims) := {role | some role in claims[p.roles_claim]}
+
+roles(p, claims) := {role | some role in claims.realm_access.roles} | {role |
+    some role in claims.resource_access[client_id(p, claims)].roles
+} if {
+    not p.roles_claim
+    p.type == "keycloak"
+}
+
+roles(p, claims) := {role | some role in claims.roles} if {
+    not p.roles_claim
+    p.type in {"oidc", "azure_ad"}
+}
+
+roles(p, claims) := {role | some role in claims.permissions} if {
+    not p.roles_claim
+    p.type == "auth0"
+}
+
+# --- groups(provider, claims) ---
+
+groups(p, claims) := {group | some group in claims[object.get(p, "groups_claim", "groups")]}
@@ -0,0 +1,86 @@
+package authz.ratelimit
+
+import data.authz.degradation
+import data.authz.lib
+import data.authz.request
+import data.authz.settings.config
+import data.authz.token
+
+# Token-bucket rate limits from config.rate_limits.limits. A limit applies to
+# requests matching its paths and methods and keeps one bucket per key: the token's
+# subject (`sub`), its client id (`client_id`) or the source address (`source`),
+# falling back to the source address when the token lacks the claim. Buckets hold
+# up to `burst` requests and refill at `rate` per second. OPA keeps no state between
+# decisions, so the buckets live in tools/rate_limiter.py at limiter_url.
+
+default configured := []
+
+configured := config.rate_limits.limits
+
+path_matches(limit) if not limit.paths
+
+path_matches(limit) if lib.path_matches(limit.paths, request.path)
+
+method_matches(limit) if not limit.methods
+
+method_matches(limit) if request.method in limit.methods
+
+applicable contains limit if {
+    some limit in configured
+    path_matches(limit)
+    method_matches(limit)
+}
+
+key_value(limit) := token.claims.sub if {
+    limit.key == "sub"
+    token.claims.sub
+} else := token.client_id if {
+    limit.key == "client_id"
+    token.client_id
+} else := request.source_address
+
+bucket(limit) := {
+    "key": sprintf("%s/%s", [limit.name, key_value(limit)]),
+    "rate": limit.rate,
+    "burst": object.get(limit, "burst", limit.rate),
+}
+
+# Taking a token changes the bucket, so responses are never cached.
+take(limit) := http.send(lib.pinned({
+    "method": "POST",
+    "url": sprintf("%s/take", [config.rate_limits.limiter_url]),
+    "headers": {"content-type": "application/json"},
+    "body": bucket(limit),
+    "timeout": sprintf("%dms", [object.get(config.rate_limits, "timeout_ms", 200)]),
+    "raise_error": false,
+}))
+
+responses[limit.name] := take(limit) if {
+    some limit in applicable
+    not degradation.skipped("rate_limit")
+}
+
+deny contains {
+    "rule": sprintf("rate_limit.%s", [name]),
+    "class": "dependency",
+    "code": "rate_limiter_unavailable",
+    "status": 503,
+    "message": "the rate limiter is unavailable",
+} if {
+    some name, response in responses
+    response.status_code != 200
+}
+
+deny contains {
+    "rule": sprintf("rate_limit.%s", [name]),
+    "code": "rate_limited",
+    "status": 429,
+    "message": sprintf("rate limit %s exceeded; retry in %d seconds", [name, retry_after]),
+    "headers": {"retry-after": sprintf("%d", [retry_after])},
+    "details": {"retry_after_seconds": retry_after},
+} if {
+    some name, response in responses
+    response.status_code == 200
+    response.body.allowed == false
+    retry_after := ceil(response.body.retry_after_seconds)
+}
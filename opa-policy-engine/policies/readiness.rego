@@ -0,0 +1,90 @@
+package authz.readiness
+
+import data.authz.settings.config
+
+# Horizontal scaling readiness. Features that rely on data pushed to a single
+# replica through the data API give inconsistent decisions behind a Service with
+# more than one replica; stateless mode turns them off.
+
+default stateless := false
+
+stateless if opa.runtime().env.AUTHZ_STATELESS == "true"
+
+stateless if config.stateless == true
+
+# Data pushed through the data API, if any.
+default pushed_grants := {}
+
+pushed_grants := data.grants
+
+default pushed_overrides := {}
+
+pushed_overrides := data.overrides
+
+default pushed_enforcement := {}
+
+pushed_enforcement := data.enforcement
+
+features := [
+    {
+        "feature": "dual_control.approval_header",
+        "configured": count(object.get(config, ["dual_control", "routes"], [])) > 0,
+        "replica_safe": true,
+    },
+    {
+        "feature": "dual_control.approval_api",
+        "configured": count(object.get(config, ["dual_control", "routes"], [])) > 0,
+        "replica_safe": false,
+        "note": "approvals in data.approvals exist only on the replica they were pushed to",
+    },
+    {
+        "feature": "webhook",
+        "configured": count([r | some r in config.routes; r.webhook]) > 0,
+        "replica_safe": true,
+        "note": "each replica caches webhook responses separately",
+    },
+    {
+        "feature": "rate_limits",
+        "configured": count(object.get(config, ["rate_limits", "limits"], [])) > 0,
+        "replica_safe": true,
+        "note": "limits are per limiter unless every limiter uses the same Redis",
+    },
+    {
+        "feature": "workload_labels",
+        "configured": count([r | some r in config.routes; r.workload_labels]) > 0,
+        "replica_safe": true,
+        "note": "each replica needs its own kube-mgmt sidecar replicating pods",
+    },
+    {
+        "feature": "agent_auth_policies",
+        "configured": object.get(config, ["agent_auth_policies", "enabled"], false) == true,
+        "replica_safe": true,
+        "note": "each replica needs its own kube-mgmt sidecar replicating agentauthpolicies",
+    },
+    {
+        "feature": "grants",
+        "configured": count(pushed_grants) > 0,
+        "replica_safe": false,
+        "note": "grants in data.grants exist only on the replica they were pushed to",
+    },
+    {
+        "feature": "overrides",
+        "configured": count(pushed_overrides) > 0,
+        "replica_safe": false,
+        "note": "overrides in data.overrides exist only on the replica they were pushed to",
+    },
+    {
+        "feature": "enforcement_toggles",
+        "configured": count(pushed_enforcement) > 0,
+        "replica_safe": false,
+        "note": "toggles in data.enforcement exist only on the replica they were pushed to",
+    },
+]
+
+# Queried by run-opa.sh at startup and available at /v1/data/authz/readiness/report.
+report := {
+    "stateless": stateless,
+    "features": features,
+    "unsafe": [f.feature | some f in features; f.configured; not f.replica_safe],
+    "disabled": [f.feature | some f in features; f.configured; not f.replica_safe; stateless],
+}
@@ -0,0 +1,143 @@
+package authz.request
+
+# Normalized accessors for the request being authorized. Policies only use these,
+# never `input` directly: each supported input protocol has an adapter below that
+# maps it onto the same fields, so a new protocol or protocol revision needs an
+# adapter here rather than changes to the policies.
+#
+#   envoy.v3  Envoy ext_authz CheckRequest, as sent to the plugin by agentgateway
+#             and Istio sidecars (v2 has the same shape).
+#   authz.v1  the engine's own request model, {"version": "v1", "request": {...},
+#             "source": {...}, "context_extensions": {...}, "body": ...}, with the
+#             fields of schemas/external-input.v1.json, for REST clients.
+#
+# Header names are lowercase in both.
+
+protocol := "envoy.v3" if {
+    input.attributes.request.http
+} else := "authz.v1" if {
+    input.version == "v1"
+    input.request
+}
+
+is_gateway if protocol
+
+http := input.attributes.request.http if {
+    protocol == "envoy.v3"
+} else := {
+    "method": input.request.method,
+    "path": input.request.path,
+    "host": object.get(input.request, "host", ""),
+    "headers": object.get(input.request, "headers", {}),
+} if {
+    protocol == "authz.v1"
+}
+
+default headers := {}
+
+headers := http.headers
+
+method := upper(http.method)
+
+path := split(http.path, "?")[0]
+
+# Host without the port, as matched by route hosts.
+hostname := split(http.host, ":")[0]
+
+header(name) := headers[lower(name)]
+
+# Context extensions configured on the gateway's extAuthz policy (environment,
+# region, service, ...).
+default context_extensions := {}
+
+context_extensions := input.attributes.contextExtensions if {
+    protocol == "envoy.v3"
+} else := input.context_extensions if {
+    protocol == "authz.v1"
+}
+
+# JSON request body, parsed by the Envoy plugin when the gateway forwards it.
+body := input.parsed_body if {
+    protocol == "envoy.v3"
+} else := input.body if {
+    protocol == "authz.v1"
+}
+
+# Size of the request body in bytes, when anything tells it: the content-length
+# header, Envoy's size attribute (-1 when unknown, as for chunked requests), the
+# body forwarded with the check, or the parsed body. The largest wins, so a
+# chunked request cannot pass as smaller than the body the gateway saw. Requests
+# of bodiless methods without content-length or transfer-encoding have none.
+body_sizes contains to_number(header("content-length")) if regex.match(`^[0-9]+$`, header("content-length"))
+
+body_sizes contains to_number(http.size) if {
+    protocol == "envoy.v3"
+    to_number(http.size) >= 0
+}
+
+body_sizes contains count(http.body) if is_string(http.body)
+
+body_sizes contains count(base64.decode(http.raw_body)) if is_string(http.raw_body)
+
+body_sizes contains count(json.marshal(body)) if body != null
+
+body_sizes contains 0 if {
+    method in {"GET", "HEAD", "OPTIONS"}
+    not header("content-length")
+    not header("transfer-encoding")
+}
+
+body_size := max(body_sizes) if count(body_sizes) > 0
+
+# Address of the downstream connection (the agent, or the last proxy before the
+# gateway).
+source_address := input.attributes.source.address.socketAddress.address if {
+    protocol == "envoy.v3"
+} else := input.source.address if {
+    protocol == "authz.v1"
+}
+
+# SPIFFE id of the downstream workload, from its mTLS peer certificate (set by
+# Istio sidecars and gateways in the mesh).
+source_principal := input.attributes.source.principal if {
+    protocol == "envoy.v3"
+    input.attributes.source.principal != ""
+} else := input.source.principal if {
+    protocol == "authz.v1"
+}
+
+# PEM of the downstream's mTLS client certificate, when the gateway forwards it
+# (Envoy URL-encodes it).
+source_certificate := urlquery.decode(input.attributes.source.certificate) if {
+    protocol == "envoy.v3"
+    input.attributes.source.certificate != ""
+} else := input.source.certificate if {
+    protocol == "authz.v1"
+}
+
+# Identifiers that join a decision with the gateway's access log entry: the
+# x-request-id the gateway generated or forwarded, Envoy's per-request id, and
+# the downstream connection's address and port.
+correlation["request_id"] := header("x-request-id")
+
+correlation["stream_id"] := http.id
+
+# Trace of the gateway request, from a W3C traceparent or B3 headers.
+correlation["trace_id"] := split(header("traceparent"), "-")[1]
+
+correlation["trace_id"] := header("x-b3-traceid") if not header("traceparent")
+
+correlation["trace_id"] := split(header("b3"), "-")[0] if {
+    not header("traceparent")
+    not header("x-b3-traceid")
+}
+
+correlation["source"] := sprintf("%s:%v", [address.address, address.portValue]) if {
+    address := input.attributes.source.address.socketAddress
+}
+
+correlation["source"] := source_address if protocol == "authz.v1"
+
+correlation["destination"] := sprintf("%s:%v", [address.address, address.portValue]) if {
+    address := input.attributes.destination.address.socketAddress
+}
@@ -0,0 +1,89 @@
+package authz.request_object
+
+import data.authz.degradation
+import data.authz.lib
+import data.authz.request
+import data.authz.token
+import data.authz.settings.config
+
+# Signed request objects (JAR, RFC 9101, as pushed with PAR) and JARM responses
+# that agents present in headers, e.g. to carry authorization_details for a
+# delegated call. A request object must be signed with a key of the calling
+# client (its entry in config.request_objects.clients), issued by that client for
+# config.request_objects.audience and unexpired; a JARM response must be signed by
+# the token issuer for the calling client. Their claims are available to rules as
+# `params` and `jarm`. Encrypted (JWE) objects are rejected, as OPA has no
+# builtin to decrypt them.
+
+settings := object.get(config, "request_objects", {})
+
+object_jws := request.header(object.get(settings, "header", "x-request-object"))
+
+jarm_jws := request.header(object.get(settings, "jarm_header", "x-jarm-response"))
+
+encrypted(jws) if count(split(jws, ".")) == 5
+
+client := settings.clients[token.client_id]
+
+client_keys := json.marshal(client.jwks) if {
+    client.jwks
+} else := json.marshal(response.body) if {
+    client_keys_fetched
+    response := http.send(lib.pinned({
+        "method": "GET",
+        "url": client.jwks_url,
+        "timeout": "2s",
+        "force_cache": true,
+        "force_cache_duration_seconds": 300,
+        "raise_error": false,
+    }))
+    response.status_code == 200
+}
+
+# Client keys fetched from jwks_url are cached, but not fetched at all while
+# degraded to local rules (see degradation.rego).
+client_keys_fetched if {
+    client.jwks_url
+    not client.jwks
+    not degradation.skipped("request_object_keys")
+}
+
+object_verification := io.jwt.decode_verify(object_jws, {
+    "cert": client_keys,
+    "iss": token.client_id,
+    "aud": settings.audience,
+})
+
+params := object_verification[2] if object_verification[0]
+
+jarm_verification := io.jwt.decode_verify(jarm_jws, {
+    "cert": json.marshal(http.send(token.jwks_request).body),
+    "iss": config.token.issuer,
+    "aud": token.client_id,
+})
+
+jarm := jarm_verification[2] if jarm_verification[0]
+
+reason(kind, message) := {
+    "rule": sprintf("request_object.%s", [kind]),
+    "class": "token",
+    "code": sprintf("invalid_%s", [kind]),
+    "status": 400,
+    "message": message,
+}
+
+deny contains reason("request_object", "request objects must be signed, not encrypted") if encrypted(object_jws)
+
+deny contains reason("request_object", "the request object could not be verified") if {
+    object_jws
+    not encrypted(object_jws)
+    not params
+}
+
+deny contains reason("jarm_response", "JARM responses must be signed, not encrypted") if encrypted(jarm_jws)
+
+deny contains reason("jarm_response", "the JARM response could not be verified") if {
+    jarm_jws
+    not encrypted(jarm_jws)
+    not jarm
+}
@@ -0,0 +1,44 @@
+package authz.residency
+
+import data.authz.request
+import data.authz.routes
+import data.authz.token
+import data.authz.settings.config
+
+# Data residency. Routes carry a data `classification` and the residency zones
+# (`residency`) their backends keep data in. For classifications listed in
+# config.residency.restricted, the request must be served by a gateway in one of
+# those zones (its `region` context extension, mapped through
+# config.residency.regions), and a user whose token names a residency zone may
+# only reach routes in that zone.
+
+restricted if routes.route.classification in config.residency.restricted
+
+gateway_zone := config.residency.regions[request.context_extensions.region]
+
+user_zone := token.claims[config.residency.claim]
+
+reason(message, details) := {
+    "rule": sprintf("residency.%s", [routes.route.name]),
+    "code": "residency_violation",
+    "message": message,
+    "details": object.union(details, {"route_zones": routes.route.residency}),
+}
+
+deny contains reason(
+    sprintf("%s data may not be processed in region %s", [routes.route.classification, region]),
+    {"region": region},
+) if {
+    restricted
+    region := request.context_extensions.region
+    not gateway_zone in routes.route.residency
+}
+
+deny contains reason(
+    sprintf("users resident in %s may not reach %s data outside their zone", [user_zone, routes.route.classification]),
+    {"user_zone": user_zone},
+) if {
+    restricted
+    user_zone
+    not user_zone in routes.route.residency
+}
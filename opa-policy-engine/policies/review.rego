@@ -0,0 +1,59 @@
+package authz.review
+
+import data.authz
+
+# Bulk evaluation for access reviews. POST /v1/data/authz/review/report with an
+# input listing subjects (token claims plus optional headers) and resources
+# (method, path and optional host, headers and body); every pair is evaluated
+# against the current policies and config:
+#
+#   {"input": {
+#     "subjects": [{"name": "supply-chain-agent", "claims": {"sub": "...", "azp": "...", "scope": "..."}}],
+#     "resources": [{"name": "list orders", "method": "GET", "path": "/orders", "host": "api.localhost"}]
+#   }}
+
+gateway_input(subject, resource) := object.union(
+    {"attributes": {"request": {"http": {
+        "method": resource.method,
+        "path": resource.path,
+        "host": object.get(resource, "host", "localhost"),
+        "headers": object.union(object.get(subject, "headers", {}), object.get(resource, "headers", {})),
+    }}}},
+    {k: v | some k, v in {"parsed_body": object.get(resource, "body", null)}; v != null},
+)
+
+subject_name(subject) := object.get(subject, "name", object.get(subject.claims, "sub", ""))
+
+resource_name(resource) := object.get(resource, "name", sprintf("%s %s", [resource.method, resource.path]))
+
+error_code(response) := response.dynamic_metadata.code if {
+    response.dynamic_metadata.code
+} else := ""
+
+entries := [entry |
+    some subject in input.subjects
+    some resource in input.resources
+    response := authz.result with input as gateway_input(subject, resource)
+        with data.authz.token.bearer as "access-review"
+        with data.authz.token.verified as true
+        with data.authz.token.claims as subject.claims
+        with data.authz.ratelimit.configured as []
+    entry := {
+        "subject": subject_name(subject),
+        "resource": resource_name(resource),
+        "allowed": response.allowed,
+        "status": object.get(response, "http_status", 200),
+        "error": error_code(response),
+    }
+]
+
+report := {
+    "generated_at": time.format(time.now_ns()),
+    "summary": {
+        "subjects": count(input.subjects),
+        "resources": count(input.resources),
+        "allowed": count([e | some e in entries; e.allowed]),
+        "denied": count([e | some e in entries; not e.allowed]),
+    },
+    "entries": entries,
+}
@@ -0,0 +1,75 @@
+package authz.routes
+
+import data.authz.blueprints
+import data.authz.lib
+import data.authz.mcp
+import data.authz.openapi
+import data.authz.request
+import data.authz.settings.config
+
+# Route table. A request is covered when it matches a route in config.routes or
+# one of the generated or blueprint policies; what happens to requests covered by
+# none of them is set by config.unmatched_route.decision.
+
+host_matches(route) if not route.hosts
+
+host_matches(route) if request.hostname in route.hosts
+
+method_matches(route) if not route.methods
+
+method_matches(route) if request.method in route.methods
+
+matches(route) if {
+    host_matches(route)
+    method_matches(route)
+    lib.path_matches(route.paths, request.path)
+}
+
+# First configured route matching the request.
+route := [r | some r in config.routes; matches(r)][0]
+
+matched contains r.name if {
+    some r in config.routes
+    matches(r)
+}
+
+matched contains sprintf("blueprint.%s", [bp.name]) if {
+    some bp in blueprints.active
+}
+
+matched contains sprintf("openapi.%s", [name]) if {
+    some name in openapi.backends
+}
+
+matched contains sprintf("mcp.%s", [name]) if {
+    some name in mcp.servers
+}
+
+unmatched if count(matched) == 0
+
+# "deny", "allow" or "monitor" (allow, recording the request for discovery).
+default unmatched_decision := "monitor"
+
+unmatched_decision := config.unmatched_route.decision
+
+# Recorded in the decision log so tools/discovery_report.py can list coverage gaps.
+discovery := {
+    "method": request.method,
+    "host": request.hostname,
+    "path": request.path,
+    "decision": unmatched_decision,
+} if {
+    request.is_gateway
+    unmatched
+    unmatched_decision != "allow"
+}
+
+deny contains {
+    "rule": "routes.unmatched",
+    "code": "route_not_found",
+    "status": 404,
+    "message": sprintf("no policy route covers %s %s", [request.method, request.path]),
+} if {
+    unmatched
+    unmatched_decision == "deny"
+}
@@ -0,0 +1,325 @@
+package authz.rules
+
+import data.authz.crds
+import data.authz.degradation
+import data.authz.lib
+import data.authz.mcp
+import data.authz.principal
+import data.authz.request
+import data.authz.token
+import data.authz.workloads
+import data.authz.settings.config
+
+# Operator-defined rules from config.rules and AgentAuthPolicy resources, so common policies (blocked paths,
+# business hours, required headers) change with the data files instead of the
+# Rego. A rule applies to requests matching all of its matchers (paths, methods,
+# context_extensions, mcp_tools, issuers, principals, cel) and denies them unless all of its conditions
+# (required_headers, window and the token conditions below) hold; a rule without
+# conditions always denies. A rule with `enforce: false` only records its denials
+# (see decision.rego).
+
+# config.rules, followed by those of AgentAuthPolicy resources (see crds.rego).
+configured := array.concat(object.get(config, "rules", []), crds.rules)
+
+path_matches(r) if not r.paths
+
+path_matches(r) if lib.path_matches(r.paths, request.path)
+
+method_matches(r) if not r.methods
+
+method_matches(r) if request.method in r.methods
+
+extensions_match(r) if {
+    every key, values in object.get(r, "context_extensions", {}) {
+        request.context_extensions[key] in values
+    }
+}
+
+# Globs over the name of the MCP tool called, any call of a batch; requests that
+# are not tools/call calls never match.
+tool_matches(r) if not r.mcp_tools
+
+tool_matches(r) if {
+    some pattern in r.mcp_tools
+    some t in mcp.tools
+    glob.match(pattern, [], t)
+}
+
+# Names of the identity providers (see providers.rego) whose tokens the rule
+# applies to, so partner realms can get other rules than internal ones.
+issuer_matches(r) if not r.issuers
+
+issuer_matches(r) if token.provider.name in r.issuers
+
+# Globs over the principal (see principal.rego), so a window or a requirement can
+# apply to some agents or users only.
+principal_matches(r) if not r.principals
+
+principal_matches(r) if {
+    some pattern in r.principals
+    glob.match(pattern, [], principal.id)
+}
+
+# A CEL expression (`cel`) the request must satisfy, such as
+# `request.method == 'DELETE' && !('admin' in roles)`. OPA cannot evaluate CEL, so
+# the expression is POSTed with the request's attributes to config.cel.url
+# (tools/cel_evaluator.py, run beside the engine). CEL sees `request` (method,
+# path, host, headers), `claims` (the token's, after claim transforms), `roles`,
+# `scopes`, `context` (context extensions) and `source` (address, principal). An
+# expression that errors on a request, such as on a missing claim, matches it, so
+# the rule denies rather than lets it through.
+cel_request["method"] := request.method
+
+cel_request["path"] := request.path
+
+cel_request["host"] := request.hostname
+
+cel_request["headers"] := request.headers
+
+cel_source["address"] := request.source_address
+
+cel_source["principal"] := request.source_principal
+
+default cel_claims := {}
+
+cel_claims := token.claims
+
+activation := {
+    "request": cel_request,
+    "claims": cel_claims,
+    "roles": [role | some role in token.roles],
+    "scopes": [scope | some scope in token.scopes],
+    "context": request.context_extensions,
+    "source": cel_source,
+}
+
+# One call per expression and request, cached for config.cel.cache_seconds: the
+# same expression over the same attributes always gets the same answer. Skipped
+# while degraded to a tier that does not allow it (see degradation.rego).
+cel_evaluation(expression) := http.send(lib.pinned({
+    "method": "POST",
+    "url": config.cel.url,
+    "headers": {"content-type": "application/json"},
+    "body": {"expression": expression, "activation": activation},
+    "timeout": sprintf("%dms", [object.get(config.cel, "timeout_ms", 200)]),
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(config.cel, "cache_seconds", 60),
+    "raise_error": false,
+})) if not degradation.skipped("cel")
+
+# The evaluator's verdict: "match", "no_match", "invalid" when it rejected the
+# expression itself, or "failed" when it could not be asked or gave no answer.
+cel_verdict(response) := "invalid" if {
+    response.status_code == 400
+} else := "match" if {
+    response.status_code == 200
+    response.body.result == true
+} else := "match" if {
+    response.status_code == 200
+    is_string(response.body.error)
+} else := "no_match" if {
+    response.status_code == 200
+    response.body.result == false
+} else := "failed"
+
+cel_outcome(expression) := cel_verdict(response) if {
+    response := cel_evaluation(expression)
+} else := "failed"
+
+cel_matches(r) if not r.cel
+
+cel_matches(r) if cel_outcome(r.cel) == "match"
+
+cel_invalid(r) if cel_outcome(r.cel) == "invalid"
+
+cel_failed(r) if cel_outcome(r.cel) == "failed"
+
+# The matchers but `cel`, which is only evaluated for requests the others select.
+selects(r) if {
+    path_matches(r)
+    method_matches(r)
+    extensions_match(r)
+    tool_matches(r)
+    issuer_matches(r)
+    principal_matches(r)
+}
+
+applies(r) if {
+    selects(r)
+    cel_matches(r)
+}
+
+# Rules that applied to the request, whether or not they denied it.
+applied contains sprintf("rules.%s", [r.name]) if {
+    some r in configured
+    applies(r)
+}
+
+missing_header(r) if {
+    some name in r.required_headers
+    not request.header(name)
+}
+
+# Token conditions: every listed role (realm or client role), scope and group, and
+# one of the listed client ids (azp). `workloads` holds callers to the same
+# standard by their client certificate: one of its globs must match the caller's
+# SPIFFE id or a DNS SAN (see workloads.rego).
+token_conditions := ["required_roles", "required_scopes", "required_groups", "clients", "workloads"]
+
+missing_claim(r) if {
+    some role in r.required_roles
+    not role in token.roles
+}
+
+missing_claim(r) if {
+    some scope in r.required_scopes
+    not scope in token.scopes
+}
+
+missing_claim(r) if {
+    some group in r.required_groups
+    not group in token.groups
+}
+
+missing_claim(r) if {
+    r.clients
+    not token.client_id in r.clients
+}
+
+missing_claim(r) if {
+    r.workloads
+    not workloads.matches(r.workloads)
+}
+
+all_days := ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"]
+
+# A rule's window is one window or a list of them, any of which admits the request.
+# Windows are days (default all), start and end as "HH:MM" (end exclusive; an end
+# before the start spans midnight and belongs to the day it opened on), an IANA
+# timezone and a `calendar` of config.calendars whose holidays ("YYYY-MM-DD", or
+# "MM-DD" every year) are closed. The timezone defaults to the calendar's, then UTC.
+windows(r) := r.window if is_array(r.window) else := [r.window]
+
+zone(window) := window.timezone if {
+    window.timezone
+} else := config.calendars[window.calendar].timezone if {
+    config.calendars[window.calendar].timezone
+} else := "UTC"
+
+open_at(window, clock) if {
+    window.start <= window.end
+    clock >= window.start
+    clock < window.end
+}
+
+open_at(window, clock) if {
+    window.start > window.end
+    clock >= window.start
+}
+
+open_at(window, clock) if {
+    window.start > window.end
+    clock < window.end
+}
+
+opened_ns(window, clock, now) := now - 86400000000000 if {
+    window.start > window.end
+    clock < window.end
+} else := now
+
+holiday(window, ns, tz) if {
+    [year, month, day] := time.date([ns, tz])
+    date := sprintf("%04d-%02d-%02d", [year, month, day])
+    some entry in config.calendars[window.calendar].holidays
+    entry in {date, substring(date, 5, 5)}
+}
+
+in_window(window) if {
+    tz := zone(window)
+    now := time.now_ns()
+    [hour, minute, _] := time.clock([now, tz])
+    clock := sprintf("%02d:%02d", [hour, minute])
+    open_at(window, clock)
+    opened := opened_ns(window, clock, now)
+    substring(time.weekday([opened, tz]), 0, 3) in object.get(window, "days", all_days)
+    not holiday(window, opened, tz)
+}
+
+# Decisions under a rule with a window change with the clock and are not cached.
+time_sensitive if {
+    some r in configured
+    r.window
+    applies(r)
+}
+
+violated(r) if missing_header(r)
+
+violated(r) if missing_claim(r)
+
+violated(r) if {
+    r.window
+    not any_window(r)
+}
+
+any_window(r) if {
+    some window in windows(r)
+    in_window(window)
+}
+
+violated(r) if {
+    not r.required_headers
+    not r.window
+    every condition in token_conditions {
+        not r[condition]
+    }
+}
+
+# Denials for missing scopes carry the RFC 6750 challenge, as do rules answering
+# 401, so clients know to get another token.
+challenge(r) := {"www-authenticate": sprintf(`Bearer error="insufficient_scope", scope="%s"`, [concat(" ", r.required_scopes)])} if {
+    some scope in r.required_scopes
+    not scope in token.scopes
+} else := {"www-authenticate": "Bearer"} if {
+    r.status == 401
+} else := {}
+
+# A CEL rule that cannot be evaluated fails closed on the requests it would select:
+# a rejected expression is a configuration error, an unreachable evaluator a
+# failed dependency.
+deny contains {
+    "rule": sprintf("rules.%s", [r.name]),
+    "class": "config",
+    "code": "invalid_config",
+    "status": 500,
+    "message": sprintf("rule %s has a CEL expression that does not compile", [r.name]),
+} if {
+    some r in configured
+    selects(r)
+    cel_invalid(r)
+}
+
+deny contains {
+    "rule": sprintf("rules.%s", [r.name]),
+    "class": "dependency",
+    "code": "cel_unavailable",
+    "status": 503,
+    "message": sprintf("the CEL condition of rule %s could not be evaluated", [r.name]),
+} if {
+    some r in configured
+    selects(r)
+    cel_failed(r)
+}
+
+# A rule's `response` (format, template, content_type, headers) shapes its denied
+# response (see decision.rego).
+deny contains object.union(object.filter(r, ["response"]), {
+    "rule": sprintf("rules.%s", [r.name]),
+    "code": object.get(r, "code", "rule_denied"),
+    "status": object.get(r, "status", 403),
+    "message": object.get(r, "message", sprintf("denied by rule %s", [r.name])),
+    "headers": challenge(r),
+}) if {
+    some r in configured
+    applies(r)
+    violated(r)
+}
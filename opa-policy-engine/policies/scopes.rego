@@ -0,0 +1,56 @@
+package authz.scopes
+
+import data.authz.rules
+import data.authz.token
+import data.authz.settings.config
+
+# Scope narrowing for agent tokens. Agents (clients matching a glob of
+# config.scope_narrowing.agents) must call with tokens scoped to what the call
+# needs: the policies matching the request (by paths, methods, mcp_tools and
+# context_extensions, as operator rules) name the scopes it requires, and the
+# token may carry nothing beyond those and the `baseline` scopes. A broader
+# token, such as a user's admin-scoped token passed straight through, is denied
+# with a hint to exchange it first (see exchange.rego).
+
+default settings := {}
+
+settings := config.scope_narrowing
+
+agent if {
+    some pattern in settings.agents
+    glob.match(pattern, [], token.client_id)
+}
+
+matching := [policy | some policy in settings.policies; rules.applies(policy)] if agent
+
+required := {scope | some policy in matching; some scope in policy.scopes}
+
+allowed := required | {scope | some scope in object.get(settings, "baseline", [])}
+
+missing := required - token.scopes
+
+excess := token.scopes - allowed
+
+reason(code, message) := {
+    "rule": sprintf("scopes.%s", [matching[0].name]),
+    "class": "token",
+    "code": code,
+    "message": message,
+    "details": {"client_id": token.client_id, "required": sort(required), "excess": sort(excess)},
+}
+
+deny contains object.union(reason("insufficient_scope", sprintf("the token lacks the scopes %s", [concat(" ", sort(missing))])), {
+    "headers": {"www-authenticate": sprintf(`Bearer error="insufficient_scope", scope="%s"`, [concat(" ", sort(required))])},
+}) if {
+    count(matching) > 0
+    count(missing) > 0
+}
+
+deny contains reason("scope_too_broad", sprintf("the token's scopes %s exceed what %s may use here; exchange it for one limited to %s first", [
+    concat(" ", sort(excess)),
+    token.client_id,
+    concat(" ", sort(required)),
+])) if {
+    count(matching) > 0
+    count(excess) > 0
+}
@@ -0,0 +1,87 @@
+package authz.selftest
+
+import data.authz
+import data.authz.providers
+
+# Synthetic gateway requests evaluated against the loaded policies and config, used
+# as a deployment smoke test by `policyctl self-test` and `run-opa.sh --self-test`.
+# The test tokens are signed with a local HMAC key, so they are treated as verified
+# and their algorithm as accepted.
+
+key := {"kty": "oct", "k": base64url.encode_no_pad("policy-engine-self-test")}
+
+token(claims) := sprintf("Bearer %s", [io.jwt.encode_sign({"alg": "HS256", "typ": "JWT"}, claims, key)])
+
+agent_claims := {
+    "sub": "self-test-agent",
+    "azp": "self-test",
+    "agent_type": "self-test",
+    "autonomy_level": "supervised",
+    "owner": "platform",
+}
+
+gateway_input(method, path, headers) := {"attributes": {
+    "request": {"http": {"method": method, "path": path, "host": "self-test.localhost", "headers": headers}},
+    "source": {"address": {"socketAddress": {"address": "127.0.0.1"}}},
+}}
+
+cases := [
+    {
+        "name": "valid agent token",
+        "input": gateway_input("GET", "/general/mcp", {"authorization": token(agent_claims), "user-agent": "a2a-python/0.3.0"}),
+        "expect": {"allowed": true, "status": 200, "error": ""},
+    },
+    {
+        "name": "malformed agent claims",
+        "input": gateway_input("GET", "/general/mcp", {"authorization": token(object.union(agent_claims, {"autonomy_level": "unbounded"}))}),
+        "expect": {"allowed": false, "status": 403, "error": "invalid_agent_claims"},
+    },
+    {
+        "name": "outdated agent SDK",
+        "input": gateway_input("GET", "/general/mcp", {"authorization": token(agent_claims), "user-agent": "a2a-python/0.1.0"}),
+        "expect": {"allowed": false, "status": 426, "error": "upgrade_required"},
+    },
+    {
+        "name": "expired token",
+        "input": gateway_input("GET", "/general/mcp", {"authorization": token(object.union(agent_claims, {"exp": 1}))}),
+        "expect": {"allowed": false, "status": 401, "error": "token_expired"},
+    },
+    {
+        "name": "crawler",
+        "input": gateway_input("GET", "/general/mcp", {"user-agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"}),
+        "expect": {"allowed": false, "status": 403, "error": "client_not_allowed"},
+    },
+]
+
+error_code(response) := response.dynamic_metadata.code if {
+    response.dynamic_metadata.code
+} else := ""
+
+summary(response) := {
+    "allowed": response.allowed,
+    "status": object.get(response, "http_status", 200),
+    "error": error_code(response),
+}
+
+results := [{"name": c.name, "passed": actual == c.expect, "expected": c.expect, "actual": actual} |
+    some c in cases
+    actual := summary(authz.result) with input as c.input with data.authz.token.verified as true
+        with data.authz.token.algorithm_allowed as true
+        with data.authz.ratelimit.configured as []
+        with data.authz.fips.enabled as false
+        with data.authz.degradation.tier as "full"
+        with data.authz.token.provider as providers.default_provider
+]
+
+report := {
+    "passed": every_passed,
+    "results": results,
+}
+
+default every_passed := false
+
+every_passed if {
+    every r in results {
+        r.passed
+    }
+}
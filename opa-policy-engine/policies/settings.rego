@@ -0,0 +1,41 @@
+package authz.settings
+
+# Per-environment configuration. policies/environments/<name>/data.yaml overlays the
+# base configuration (data.yaml, plus a --rules file) for the environment named by
+# AUTHZ_ENV, default dev. The overlay takes precedence: objects merge key by key,
+# while lists and scalars in the overlay replace the base value. Every policy reads
+# the merged result as `config`.
+
+default environment := "dev"
+
+environment := opa.runtime().env.AUTHZ_ENV if opa.runtime().env.AUTHZ_ENV != ""
+
+default overlay := {}
+
+overlay := data.environments[environment]
+
+config := object.union(data.config, overlay)
+
+default environments := []
+
+environments := sort(object.keys(data.environments))
+
+# Settings the overlay changed, as dotted paths; lists are reported whole.
+overridden := sort([concat(".", path) |
+    walk(overlay, [path, value])
+    count(path) > 0
+    every segment in path {
+        is_string(segment)
+    }
+    not is_object(value)
+])
+
+# The configuration policies are evaluated against, for
+# GET /v1/data/authz/settings/effective.
+effective := {
+    "environment": environment,
+    "overlay_found": environment in environments,
+    "environments": environments,
+    "overridden": overridden,
+    "config": config,
+}
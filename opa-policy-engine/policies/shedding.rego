@@ -0,0 +1,39 @@
+package authz.shedding
+
+import data.authz.lib
+import data.authz.request
+import data.authz.settings.config
+
+# Priority classes for load shedding. config.load_shedding.classes are tried in
+# order; the first whose paths, methods and headers (name to accepted values) all
+# match classifies the request, else `default`. tools/http_authz_adapter.py reads
+# the same classes to admit requests by priority and fast-deny the lowest ones
+# under overload, before they queue for OPA; the engine records each decision's
+# class so the decision log shows what was served at which priority.
+
+settings := object.get(config, "load_shedding", {})
+
+default_class := object.union({"name": "default", "priority": 0}, object.get(settings, "default", {}))
+
+path_matches(c) if not c.paths
+
+path_matches(c) if lib.path_matches(c.paths, request.path)
+
+method_matches(c) if not c.methods
+
+method_matches(c) if request.method in c.methods
+
+headers_match(c) if {
+    every name, values in object.get(c, "headers", {}) {
+        request.header(name) in values
+    }
+}
+
+matching := [c |
+    some c in object.get(settings, "classes", [])
+    path_matches(c)
+    method_matches(c)
+    headers_match(c)
+]
+
+class := matching[0] if count(matching) > 0 else := default_class
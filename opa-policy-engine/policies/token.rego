@@ -0,0 +1,387 @@
+package authz.token
+
+import data.authz.claims as transforms
+import data.authz.degradation
+import data.authz.lib
+import data.authz.providers
+import data.authz.request
+import data.authz.settings.config
+
+# Access token from the Authorization header, under the Bearer or DPoP scheme.
+# Usually agentgateway's jwtAuth policy has already verified it and the claims are
+# only decoded here; with config.token.verify the engine checks the signature,
+# exp/nbf, issuer and audience itself against the JWKS of the identity provider
+# the token's issuer names (see providers.rego).
+bearer := t if {
+    value := request.header("authorization")
+    some prefix in ["bearer ", "dpop "]
+    startswith(lower(value), prefix)
+    t := trim_space(substring(value, count(prefix), -1))
+}
+
+# Authorization scheme: "dpop" for DPoP-bound tokens (see dpop.rego).
+scheme := lower(split(request.header("authorization"), " ")[0]) if bearer
+
+# Compact JWE has five segments. OPA has no builtin to decrypt it: with
+# config.token.decryption the JWE is POSTed to an external decryptor holding the
+# configured private keys (tools/jwe_decryptor.py), which answers with the signed
+# JWT nested inside; that is decoded and verified like any other token. Without a
+# decryptor, encrypted tokens are introspected when introspection is configured
+# and rejected otherwise.
+encrypted if count(split(bearer, ".")) == 5
+
+decryption := http.send(lib.pinned({
+    "method": "POST",
+    "url": config.token.decryption.url,
+    "headers": {"content-type": "application/json"},
+    "body": {"token": bearer},
+    "timeout": sprintf("%dms", [object.get(config.token.decryption, "timeout_ms", 500)]),
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(config.token.decryption, "cache_seconds", 30),
+    "raise_error": false,
+})) if {
+    encrypted
+    config.token.decryption
+    not degradation.skipped("decryption")
+}
+
+decryption_available if decryption.status_code in {200, 400}
+
+decrypted := decryption.body.token if {
+    decryption.status_code == 200
+    is_string(decryption.body.token)
+}
+
+# The JWT whose claims are used: the bearer, or the signed token inside a JWE.
+jwt := bearer if not encrypted
+
+jwt := decrypted if encrypted
+
+decoded := io.jwt.decode(jwt)
+
+header := decoded[0]
+
+# Identity provider of the token, by its (unverified) issuer. Opaque tokens, and
+# tokens agentgateway has verified (verify off), fall back to the first provider.
+provider := p if {
+    p := providers.for_issuer(decoded[1].iss)
+} else := providers.default_provider if {
+    opaque
+} else := providers.default_provider if {
+    not verify
+}
+
+# Opaque (non-JWT) access tokens are looked up with RFC 7662 introspection at
+# the provider when config.token.introspection is set: the engine authenticates as its
+# client_id with the secret from the environment variable named by
+# client_secret_env, and the response's claims stand in for the JWT's. Responses
+# are cached per token for cache_seconds.
+opaque if {
+    bearer
+    not decoded
+    not encrypted
+}
+
+opaque if {
+    encrypted
+    not config.token.decryption
+}
+
+introspection_url := object.get(config.token.introspection, "url", sprintf("%s/protocol/openid-connect/token/introspect", [provider.issuer]))
+
+# Secrets are read inside functions only, so no rule served by the data API holds them.
+basic_credentials(settings) := base64.encode(sprintf("%s:%s", [
+    settings.client_id,
+    opa.runtime().env[settings.client_secret_env],
+]))
+
+introspection := http.send(lib.pinned({
+    "method": "POST",
+    "url": introspection_url,
+    "headers": {
+        "content-type": "application/x-www-form-urlencoded",
+        "authorization": sprintf("Basic %s", [basic_credentials(config.token.introspection)]),
+    },
+    "raw_body": urlquery.encode_object({"token": bearer, "token_type_hint": "access_token"}),
+    "timeout": "2s",
+    "force_cache": true,
+    "force_cache_duration_seconds": object.get(config.token.introspection, "cache_seconds", 30),
+    "raise_error": false,
+})) if {
+    opaque
+    config.token.introspection
+    not degradation.skipped("introspection")
+}
+
+introspection_available if introspection.status_code == 200
+
+# A cached response may outlive the token, so its exp is checked again.
+active if {
+    introspection.body.active == true
+    not introspection.body.exp * 1000000000 <= time.now_ns()
+}
+
+introspected := introspection.body if active
+
+verify if config.token.verify == true
+
+# Signature algorithms are pinned per provider (`algorithms`, default the
+# asymmetric ones), so a token cannot pick how it is checked: `none` is always
+# rejected, and HMAC (HS*) only accepted when listed and the provider names the
+# environment variable holding the shared secret (hmac_secret_env). Tokens whose
+# alg differs from the one published for their key (kid) in the JWKS are rejected
+# too, which stops RS/HS confusion with the public key as the HMAC secret.
+default_algorithms := ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"]
+
+algorithms := object.get(provider, "algorithms", default_algorithms)
+
+hmac if startswith(header.alg, "HS")
+
+hmac_secret if opa.runtime().env[provider.hmac_secret_env] != ""
+
+with_secret(c) := object.union(c, {"secret": opa.runtime().env[provider.hmac_secret_env]}) if {
+    hmac
+} else := c
+
+algorithm_allowed if {
+    header.alg in algorithms
+    lower(header.alg) != "none"
+    not hmac
+}
+
+algorithm_allowed if {
+    hmac
+    header.alg in algorithms
+    hmac_secret
+}
+
+jwks_request := providers.jwks_request(provider)
+
+jwks := http.send(jwks_request) if {
+    verify
+    bearer
+    not hmac
+}
+
+jwks_available if jwks.status_code == 200
+
+key_alg_mismatch if {
+    some key in jwks.body.keys
+    key.kid == header.kid
+    key.alg != header.alg
+}
+
+key_ready if jwks_available
+
+key_ready if hmac_secret
+
+constraints["cert"] := json.marshal(jwks.body) if jwks_available
+
+constraints["alg"] := header.alg
+
+constraints["iss"] := provider.issuer
+
+constraints["aud"] := provider.audience
+
+verification := io.jwt.decode_verify(jwt, with_secret(constraints)) if {
+    key_ready
+    algorithm_allowed
+    not key_alg_mismatch
+}
+
+verified if verification[0]
+
+trusted if not verify
+
+trusted if verified
+
+default claims := {}
+
+# Verified claims after the provider's and config.claim_transforms (see claims.rego).
+claims := transforms.apply_for(decoded[1], provider) if trusted
+
+claims := transforms.apply_for(introspected, provider)
+
+exp := decoded[1].exp
+
+exp := introspected.exp if not decoded
+
+default scopes := set()
+
+scopes := providers.scopes(provider, claims)
+
+issuer := claims.iss
+
+subject := claims.sub
+
+client_id := providers.client_id(provider, claims)
+
+# Keycloak realm of the issuer URL.
+realm := regex.find_all_string_submatch_n(`/realms/([^/]+)`, issuer, 1)[0][1]
+
+# Roles and groups as the provider's type carries them; for Keycloak, realm roles
+# plus the client roles granted for the calling client.
+default roles := set()
+
+roles := providers.roles(provider, claims)
+
+default groups := set()
+
+groups := providers.groups(provider, claims)
+
+# An expired token fails verification, and the client should still be told to
+# refresh it rather than that it is invalid, but only if it is otherwise genuine:
+# under verify its signature, issuer and audience are checked as of a second
+# before its exp, so a forged token with a past exp is still invalid_token.
+expired if {
+    is_number(decoded[1].exp)
+    decoded[1].exp * 1000000000 <= time.now_ns()
+    genuine_when_issued
+}
+
+genuine_when_issued if not verify
+
+genuine_when_issued if {
+    key_ready
+    algorithm_allowed
+    not key_alg_mismatch
+    io.jwt.decode_verify(jwt, object.union(with_secret(constraints), {"time": (decoded[1].exp - 1) * 1000000000}))[0]
+}
+
+# An expired but otherwise well-formed token is a soft deny: the agent SDK should
+# refresh the token and retry transparently instead of surfacing an error.
+deny contains {
+    "rule": "token.expiry",
+    "class": "token",
+    "code": "token_expired",
+    "status": 401,
+    "soft": true,
+    "message": "the access token has expired; refresh it and retry",
+    "headers": {
+        "www-authenticate": `Bearer error="invalid_token", error_description="The access token expired"`,
+        "x-authz-retry": "refresh-token",
+    },
+    "details": {"retry": "refresh_token"},
+} if {
+    expired
+}
+
+unauthorized(code, description) := {
+    "rule": "token.verification",
+    "class": "token",
+    "code": code,
+    "status": 401,
+    "message": description,
+    "headers": {"www-authenticate": sprintf(`Bearer error="invalid_token", error_description="%s"`, [description])},
+}
+
+anonymous if lib.path_matches(object.get(config.token, "anonymous_paths", []), request.path)
+
+deny contains unauthorized("token_missing", "a bearer token is required") if {
+    verify
+    request.is_gateway
+    not bearer
+    not anonymous
+}
+
+deny contains unauthorized("invalid_token", "the access token could not be verified") if {
+    key_ready
+    algorithm_allowed
+    not introspection
+    not verified
+    not expired
+}
+
+# A bearer that is neither a JWT nor a JWE, with no introspection to look it up.
+deny contains unauthorized("invalid_token", "the access token is malformed") if {
+    verify
+    bearer
+    not decoded
+    not encrypted
+    not config.token.introspection
+}
+
+# A JWE the decryptor could not open, or whose content is not a signed JWT.
+deny contains unauthorized("invalid_token", "the encrypted access token could not be decrypted") if {
+    decryption.status_code == 400
+}
+
+deny contains unauthorized("invalid_token", "the encrypted access token does not hold a signed token") if {
+    decrypted
+    not decoded
+}
+
+deny contains {
+    "rule": "token.decryption",
+    "class": "dependency",
+    "code": "decryption_unavailable",
+    "status": 503,
+    "message": "the encrypted access token could not be decrypted",
+} if {
+    decryption
+    not decryption_available
+}
+
+# For messages: the alg as the token states it, if it states one.
+default stated_alg := "an unstated algorithm"
+
+stated_alg := header.alg if is_string(header.alg)
+
+deny contains unauthorized("algorithm_not_allowed", sprintf("tokens signed with %s are not accepted from this issuer", [stated_alg])) if {
+    verify
+    decoded
+    provider
+    not algorithm_allowed
+}
+
+deny contains unauthorized("algorithm_not_allowed", sprintf("the token's alg %s does not match its signing key", [stated_alg])) if {
+    algorithm_allowed
+    key_alg_mismatch
+}
+
+# Unsigned tokens are never accepted, even when agentgateway verifies tokens.
+deny contains unauthorized("algorithm_not_allowed", "unsigned (alg none) tokens are not accepted") if {
+    not verify
+    lower(header.alg) == "none"
+}
+
+deny contains unauthorized("invalid_token", "the access token's issuer is not trusted") if {
+    verify
+    decoded
+    not provider
+}
+
+deny contains {
+    "rule": "token.verification",
+    "class": "dependency",
+    "code": "jwks_unavailable",
+    "status": 503,
+    "message": "the token issuer's signing keys could not be fetched",
+} if {
+    jwks
+    not jwks_available
+}
+
+deny contains unauthorized("encrypted_token_unsupported", "encrypted (JWE) access tokens are not supported") if {
+    encrypted
+    not config.token.decryption
+    not config.token.introspection
+}
+
+deny contains unauthorized("invalid_token", "the access token is not active") if {
+    introspection_available
+    not active
+}
+
+deny contains {
+    "rule": "token.introspection",
+    "class": "dependency",
+    "code": "introspection_unavailable",
+    "status": 503,
+    "message": "the opaque access token could not be introspected",
+} if {
+    opaque
+    config.token.introspection
+    not introspection_available
+    not degradation.skipped("introspection")
+}
@@ -0,0 +1,85 @@
+package authz.webhook
+
+import data.authz.degradation
+import data.authz.external
+import data.authz.lib
+import data.authz.routes
+
+# Per-route external authorizers. A route with a `webhook` is also decided by the
+# owning team's service: the engine POSTs the external request summary (see
+# external.rego) to webhook.url and expects {"allow": bool, "code": ..., "message": ...}
+# back. Identical summaries are answered from a cache for cache_seconds. For mTLS and
+# private CAs the certificates come from environment variables named in
+# webhook.tls, so no key material sits in the data files.
+
+hook := routes.route.webhook
+
+params["method"] := "POST"
+
+params["url"] := hook.url
+
+params["headers"] := {"content-type": "application/json"}
+
+# The request id would make every summary unique and defeat the cache.
+params["body"] := json.remove(external.summary, ["request/id"])
+
+params["timeout"] := sprintf("%dms", [object.get(hook, "timeout_ms", 500)])
+
+params["raise_error"] := false
+
+params["force_cache"] := true if hook.cache_seconds > 0
+
+params["force_cache_duration_seconds"] := hook.cache_seconds if hook.cache_seconds > 0
+
+params["tls_ca_cert_env_variable"] := hook.tls.ca_cert_env
+
+params["tls_client_cert_env_variable"] := hook.tls.client_cert_env
+
+params["tls_client_key_env_variable"] := hook.tls.client_key_env
+
+response := http.send(lib.pinned(params)) if {
+    hook
+    not degradation.skipped("webhook")
+}
+
+reachable if response.status_code == 200
+
+allowed if {
+    reachable
+    response.body.allow == true
+}
+
+# The authorizer's answer, when it is a JSON object; its code and message are only
+# taken when they are strings, so a malformed answer still denies.
+default answer := {}
+
+answer := response.body if is_object(response.body)
+
+default denial_code := "webhook_denied"
+
+denial_code := answer.code if is_string(answer.code)
+
+denial_message := answer.message if {
+    is_string(answer.message)
+} else := "denied by the route's authorizer"
+
+# Fail closed when the authorizer is down, slow or answers with an error.
+deny contains {
+    "rule": sprintf("webhook.%s", [routes.route.name]),
+    "class": "dependency",
+    "code": "authorizer_unavailable",
+    "status": 503,
+    "message": sprintf("the authorizer for %s is unavailable", [routes.route.name]),
+} if {
+    response
+    not reachable
+}
+
+deny contains {
+    "rule": sprintf("webhook.%s", [routes.route.name]),
+    "code": denial_code,
+    "message": denial_message,
+} if {
+    reachable
+    not allowed
+}
@@ -0,0 +1,181 @@
+package authz.workloads
+
+import data.authz.request
+import data.authz.routes
+import data.authz.settings.config
+
+# Workload identity of callers. In the mesh, the peer certificate carries the
+# caller's SPIFFE id (spiffe://<trust domain>/ns/<namespace>/sa/<service account>).
+# config.workloads.callers (globs) restricts which workloads may be checked at all,
+# and a route's `workloads` which of them may reach it. Requests without a
+# verified peer identity fail both checks.
+#
+# In-cluster, kube-mgmt replicates pods into data.kubernetes.pods[namespace][name]
+# (an informer cache, so lookups cost no API call). The caller's pod is found by
+# its IP, or else by the namespace and service account of its SPIFFE id, and
+# `workload` exposes its namespace, service account and labels; a route's
+# `workload_labels` then requires label values of its callers.
+#
+# Callers from other clusters, for example through Istio east-west gateways, keep
+# the SPIFFE id of their own trust domain. config.workloads.trust_domains lists the
+# trust domains accepted (when empty, any), each optionally with `alias_of`, the
+# trust domain its ids are normalized to so that globs written for the local mesh
+# match them, and `namespaces`, globs over the namespaces trusted from it.
+#
+# The caller's identity comes from its client certificate: the SPIFFE id Envoy
+# reports for the mTLS peer, else the certificate's URI SAN, and its DNS SANs.
+# Behind a proxy that terminates the caller's mTLS, such as an ingress gateway, the
+# certificate is described by the x-forwarded-client-cert header instead; it is
+# only believed when the direct peer matches config.workloads.xfcc.trusted_proxies,
+# and then its last element (the one the proxy added for its own client) wins.
+
+xfcc_elements := [m[0] | some m in regex.find_all_string_submatch_n(`(?:[^,"]|"[^"]*")+`, request.header("x-forwarded-client-cert"), -1)]
+
+xfcc_client := xfcc_elements[count(xfcc_elements) - 1]
+
+xfcc_values(key) := [trim(m[2], `"`) |
+    some m in regex.find_all_string_submatch_n(`(?:^|;)\s*([A-Za-z]+)=("[^"]*"|[^;]*)`, xfcc_client, -1)
+    lower(m[1]) == lower(key)
+]
+
+xfcc_trusted if {
+    xfcc_client
+    some pattern in config.workloads.xfcc.trusted_proxies
+    glob.match(pattern, ["/"], request.source_principal)
+}
+
+certificate := crypto.x509.parse_certificates(request.source_certificate)[0]
+
+default uris := []
+
+uris := xfcc_values("URI") if {
+    xfcc_trusted
+} else := [sprintf("%s://%s%s", [u.Scheme, u.Host, u.Path]) | some u in certificate.URIs]
+
+default dns_names := []
+
+dns_names := xfcc_values("DNS") if {
+    xfcc_trusted
+} else := certificate.DNSNames
+
+peer_id := [uri | some uri in uris; startswith(uri, "spiffe://")][0] if {
+    xfcc_trusted
+} else := request.source_principal if {
+    request.source_principal
+} else := [uri | some uri in uris; startswith(uri, "spiffe://")][0]
+
+default principal := ""
+
+principal := normalized if {
+    trust_domain
+} else := peer_id
+
+# Everything the caller can be matched by in globs: its (normalized) SPIFFE id and
+# its DNS SANs.
+identities := {id | some id in array.concat([principal], dns_names); id != ""}
+
+parsed := regex.find_all_string_submatch_n(`^spiffe://([^/]+)(/.*)?$`, peer_id, 1)[0]
+
+trust_domain := parsed[1]
+
+default trust_domains := {}
+
+trust_domains := config.workloads.trust_domains
+
+domain := object.get(trust_domains, trust_domain, {})
+
+normalized := sprintf("spiffe://%s%s", [object.get(domain, "alias_of", trust_domain), parsed[2]])
+
+spiffe := regex.find_all_string_submatch_n(`^spiffe://[^/]+/ns/([^/]+)/sa/([^/]+)$`, principal, 1)[0]
+
+default pods := {}
+
+pods := data.kubernetes.pods
+
+source_pod := [pod |
+    some namespace, name
+    pod := pods[namespace][name]
+    pod.status.podIP == request.source_address
+][0]
+
+# Pods of the SPIFFE id's service account; labels are those they all share.
+account_pods := [pod |
+    some pod in pods[spiffe[1]]
+    pod.spec.serviceAccountName == spiffe[2]
+]
+
+shared_labels := {key: value |
+    some key, value in object.get(account_pods[0].metadata, "labels", {})
+    every pod in account_pods {
+        pod.metadata.labels[key] == value
+    }
+}
+
+default workload := {}
+
+workload := {
+    "namespace": source_pod.metadata.namespace,
+    "service_account": source_pod.spec.serviceAccountName,
+    "pod": source_pod.metadata.name,
+    "labels": object.get(source_pod.metadata, "labels", {}),
+} if {
+    source_pod
+} else := {
+    "namespace": spiffe[1],
+    "service_account": spiffe[2],
+    "labels": shared_labels,
+} if {
+    count(account_pods) > 0
+}
+
+matches(patterns) if {
+    some pattern in patterns
+    some identity in identities
+    glob.match(pattern, ["/"], identity)
+}
+
+namespace_trusted if not domain.namespaces
+
+namespace_trusted if {
+    some pattern in domain.namespaces
+    glob.match(pattern, [], spiffe[1])
+}
+
+reason(rule, message) := {
+    "rule": rule,
+    "code": "workload_not_allowed",
+    "message": message,
+    "details": {"source_principal": principal},
+}
+
+deny contains reason("workloads.callers", "the calling workload may not use this engine") if {
+    request.is_gateway
+    count(object.get(config.workloads, "callers", [])) > 0
+    not matches(config.workloads.callers)
+}
+
+deny contains object.union(reason("workloads.trust_domains", sprintf("trust domain %s is not trusted", [trust_domain])), {
+    "details": {"source_principal": peer_id},
+}) if {
+    count(trust_domains) > 0
+    not trust_domains[trust_domain]
+}
+
+deny contains object.union(reason("workloads.trust_domains", sprintf("namespace %s of trust domain %s is not trusted", [spiffe[1], trust_domain])), {
+    "details": {"source_principal": peer_id},
+}) if {
+    trust_domains[trust_domain]
+    not namespace_trusted
+}
+
+deny contains reason(sprintf("workloads.%s", [routes.route.name]), sprintf("the calling workload may not reach %s", [routes.route.name])) if {
+    routes.route.workloads
+    not matches(routes.route.workloads)
+}
+
+deny contains object.union(reason(sprintf("workloads.%s.labels", [routes.route.name]), sprintf("the calling workload lacks the labels required by %s", [routes.route.name])), {
+    "details": {"source_principal": principal, "workload": workload, "required": routes.route.workload_labels},
+}) if {
+    some key, value in routes.route.workload_labels
+    object.get(workload, ["labels", key], null) != value
+}
@@ -0,0 +1,88 @@
+# Windows (Docker Desktop) equivalent of run-opa.sh.
+# Usage: .\run-opa.ps1 [-Environment NAME] [-Stateless] [-SelfTest] [-Rules FILE] [-AdminRbac] [-Dns ADDR] [-Fips]
+param(
+    [string]$Environment = $(if ($env:AUTHZ_ENV) { $env:AUTHZ_ENV } else { "dev" }),
+    [switch]$Stateless,
+    [switch]$SelfTest,
+    [string]$Rules,
+    [switch]$AdminRbac,
+    [string]$Dns,
+    [switch]$Fips
+)
+
+$platform = if ($env:OPA_PLATFORM) { $env:OPA_PLATFORM } else { "linux/amd64" }
+if ($env:PROCESSOR_ARCHITECTURE -eq "ARM64" -and -not $env:OPA_PLATFORM) { $platform = "linux/arm64" }
+
+$adminArgs = @()
+if ($AdminRbac) { $adminArgs = @("--authentication=token", "--authorization=basic") }
+$headers = @{}
+if ($env:OPA_TOKEN) { $headers["Authorization"] = "Bearer $($env:OPA_TOKEN)" }
+
+$dnsArgs = @()
+if ($Dns) { $dnsArgs = @("--dns", $Dns) }
+
+# Restricted-crypto mode, as run-opa.sh --fips (no TLS here, so only the runtime).
+$cryptoMode = "standard"
+$fipsEnv = @()
+if ($Fips) {
+    $cryptoMode = "fips"
+    $fipsEnv = @("-e", "GODEBUG=fips140=on")
+}
+
+$rulesArgs = @()
+$rulesPath = @()
+if ($Rules) {
+    $rulesArgs = @("-v", "$((Resolve-Path $Rules).Path):/rules/rules.yaml")
+    $rulesPath = @("/rules")
+}
+
+docker run -d `
+  --name opa-policy-engine `
+  --rm `
+  --platform $platform `
+  -p 8181:8181 `
+  -p 9191:9191 `
+  @dnsArgs `
+  -v "${PWD}\policies:/policies" `
+  -v "${PWD}\config:/config" `
+  @rulesArgs `
+  -e OPA_LOG_LEVEL=info `
+  -e AUTHZ_ENV=$Environment `
+  -e AUTHZ_STATELESS=$($Stateless.IsPresent.ToString().ToLower()) `
+  -e AUTHZ_DEIDENTIFY_KEY `
+  -e AUTHZ_EXCHANGE_CLIENT_SECRET `
+  -e AUTHZ_INTROSPECTION_CLIENT_SECRET `
+  -e AUTHZ_DEGRADATION_TIER `
+  -e AUTHZ_INTERNAL_TOKEN_KEY `
+  -e AUTHZ_CRYPTO_MODE=$cryptoMode `
+  @fipsEnv `
+  openpolicyagent/opa:1.8.0-envoy `
+  run --server --watch --addr=0.0.0.0:8181 @adminArgs --config-file=/config/opa-config.yaml /policies @rulesPath
+
+# Report which configured features are safe to run with more than one replica.
+while ($true) {
+    try {
+        Invoke-RestMethod "http://localhost:8181/health?plugins" | Out-Null
+        Invoke-RestMethod http://localhost:8181/health/ready | Out-Null
+        break
+    } catch { Start-Sleep -Seconds 1 }
+}
+$effective = (Invoke-RestMethod -Headers $headers http://localhost:8181/v1/data/authz/settings/effective).result
+Write-Output "Environment: $($effective.environment) ($($effective.overridden.Count) settings overridden)"
+$crypto = (Invoke-RestMethod -Headers $headers http://localhost:8181/v1/data/authz/fips/report).result
+Write-Output "Crypto: $($crypto.mode) (signatures: $($crypto.jws_algorithms -join ', '))"
+foreach ($w in $crypto.warnings) { Write-Output "WARNING: $w" }
+$degradation = (Invoke-RestMethod -Headers $headers http://localhost:8181/v1/data/authz/degradation/report).result
+Write-Output "Degradation tier: $($degradation.tier)$(if ($degradation.unhealthy.Count -gt 0) { " (unhealthy: $($degradation.unhealthy -join ', '))" })"
+Write-Output "Replica readiness:"
+(Invoke-RestMethod -Method Post -Headers $headers http://localhost:8181/v1/data/authz/readiness/report).result | ConvertTo-Json -Depth 5
+
+if ($SelfTest) {
+    $report = (Invoke-RestMethod -Method Post -Headers $headers http://localhost:8181/v1/data/authz/selftest/report).result
+    foreach ($r in $report.results) {
+        if ($r.passed) { Write-Output "PASS  $($r.name)" } else { Write-Output "FAIL  $($r.name)" }
+    }
+    if ($report.passed) { exit 0 } else { exit 1 }
+}
+
+docker logs -f opa-policy-engine
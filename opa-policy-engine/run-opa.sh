@@ -1,10 +1,107 @@
-docker run -it \
+# Usage: ./run-opa.sh [--env NAME] [--stateless] [--self-test] [--route-logs] [--rules FILE]
+#   --env NAME    apply the overlay in policies/environments/NAME (default dev, or $AUTHZ_ENV)
+#   --stateless   disable features that keep per-replica state (see authz.readiness)
+#   --self-test   run `policyctl self-test` once OPA is up and exit with its status
+#   --route-logs  send logs to the destinations in config/log-destinations.json
+#   --rules FILE  load operator rules (config.rules, see policies/rules.rego) from FILE
+#   --tls DIR     serve the REST API over TLS with DIR/server.crt and DIR/server.key;
+#                 DIR/ca.crt (the issuing CA) is what curl and policyctl trust
+#   --admin-rbac  require Keycloak tokens with admin roles on the REST API (see
+#                 policies/admin_authz.rego); set OPA_TOKEN for the calls below
+#   --fips        restricted-crypto mode: FIPS-approved signature algorithms only (see
+#                 policies/fips.rego), Go's FIPS 140-3 mode and approved TLS suites
+#   --dns ADDR    resolve outbound calls through the resolver at ADDR, such as a local
+#                 DNS-over-TLS stub (see examples/dot-resolver.Corefile)
+AUTHZ_ENV=${AUTHZ_ENV:-dev}
+STATELESS=false
+SELF_TEST=false
+ROUTE_LOGS=false
+ADMIN_RBAC=()
+TLS_MOUNT=()
+TLS_ARGS=()
+export OPA_URL=http://localhost:8181
+RULES_MOUNT=()
+RULES_PATH=()
+DNS_ARGS=()
+CRYPTO_MODE=standard
+FIPS_ARGS=()
+FIPS_ENV=()
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --env) AUTHZ_ENV=$2; shift ;;
+    --stateless) STATELESS=true ;;
+    --self-test) SELF_TEST=true ;;
+    --route-logs) ROUTE_LOGS=true ;;
+    --tls)
+      TLS_MOUNT=(-v "$(cd "$2" && pwd):/tls")
+      TLS_ARGS=(--tls-cert-file=/tls/server.crt --tls-private-key-file=/tls/server.key)
+      OPA_URL=https://localhost:8181
+      export CURL_CA_BUNDLE="$(cd "$2" && pwd)/ca.crt"
+      shift ;;
+    --admin-rbac) ADMIN_RBAC=(--authentication=token --authorization=basic) ;;
+    --dns) DNS_ARGS=(--dns "$2"); shift ;;
+    --fips)
+      CRYPTO_MODE=fips
+      FIPS_ENV=(-e GODEBUG=fips140=on)
+      FIPS_ARGS=(--min-tls-version=1.2 --tls-cipher-suites=TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384) ;;
+    --rules)
+      RULES_MOUNT=(-v "$(cd "$(dirname "$2")" && pwd)/$(basename "$2"):/rules/rules.yaml")
+      RULES_PATH=(/rules)
+      shift ;;
+  esac
+  shift
+done
+
+# The OPA image is published for linux/amd64 and linux/arm64; OPA_PLATFORM overrides
+# the host architecture, e.g. to run the amd64 image under emulation.
+case "$(uname -m)" in
+  arm64|aarch64) PLATFORM=${OPA_PLATFORM:-linux/arm64} ;;
+  *) PLATFORM=${OPA_PLATFORM:-linux/amd64} ;;
+esac
+
+docker run -d \
   --name opa-policy-engine \
   --rm \
+  --platform $PLATFORM \
   -p 8181:8181 \
   -p 9191:9191 \
+  "${DNS_ARGS[@]}" \
   -v $(pwd)/policies:/policies \
   -v $(pwd)/config:/config \
+  "${RULES_MOUNT[@]}" \
+  "${TLS_MOUNT[@]}" \
   -e OPA_LOG_LEVEL=info \
+  -e AUTHZ_ENV=$AUTHZ_ENV \
+  -e AUTHZ_STATELESS=$STATELESS \
+  -e AUTHZ_DEIDENTIFY_KEY \
+  -e AUTHZ_EXCHANGE_CLIENT_SECRET \
+  -e AUTHZ_INTROSPECTION_CLIENT_SECRET \
+  -e AUTHZ_DEGRADATION_TIER \
+  -e AUTHZ_INTERNAL_TOKEN_KEY \
+  -e AUTHZ_CRYPTO_MODE=$CRYPTO_MODE \
+  "${FIPS_ENV[@]}" \
   openpolicyagent/opa:1.8.0-envoy \
-  run --server --addr=0.0.0.0:8181 --config-file=/config/opa-config.yaml /policies
+  run --server --watch --addr=0.0.0.0:8181 "${ADMIN_RBAC[@]}" "${TLS_ARGS[@]}" "${FIPS_ARGS[@]}" --config-file=/config/opa-config.yaml /policies "${RULES_PATH[@]}"
+
+# Report which configured features are safe to run with more than one replica.
+until curl -sf "$OPA_URL/health?plugins" >/dev/null &&
+  curl -sf "$OPA_URL/health/ready" >/dev/null; do sleep 1; done
+echo "Environment: $(curl -s ${OPA_TOKEN:+-H "Authorization: Bearer $OPA_TOKEN"} "$OPA_URL/v1/data/authz/settings/effective" |
+  jq -r '.result | "\(.environment) (\(.overridden | length) settings overridden)"')"
+echo "Crypto: $(curl -s ${OPA_TOKEN:+-H "Authorization: Bearer $OPA_TOKEN"} "$OPA_URL/v1/data/authz/fips/report" |
+  jq -r '.result | "\(.mode) (signatures: \(.jws_algorithms | if type == "array" then join(", ") else . end))\(.warnings | map("\nWARNING: " + .) | join(""))"')"
+echo "Degradation tier: $(curl -s ${OPA_TOKEN:+-H "Authorization: Bearer $OPA_TOKEN"} "$OPA_URL/v1/data/authz/degradation/report" |
+  jq -r '.result | "\(.tier)\(if (.unhealthy | length) > 0 then " (unhealthy: \(.unhealthy | join(", ")))" else "" end)"')"
+echo "Replica readiness:"
+curl -s ${OPA_TOKEN:+-H "Authorization: Bearer $OPA_TOKEN"} "$OPA_URL/v1/data/authz/readiness/report" | jq .result
+
+if [ "$SELF_TEST" == "true" ]; then
+  ./policyctl self-test
+  exit $?
+fi
+
+if [ "$ROUTE_LOGS" == "true" ]; then
+  docker logs -f opa-policy-engine 2>&1 | tools/log_router.py
+else
+  docker logs -f opa-policy-engine
+fi
@@ -44,4 +44,22 @@ curl -s -X POST "$OPA_URL/v1/data/authz/allow" \
   -H 'Content-Type: application/json' \
   -d '{"input": {"user": "alice", "action": "write", "resource": "data"}}' | jq .
 
+# Test 7: Gateway request from an outdated agent SDK
+echo -e "\nTest 7: Gateway request from an outdated agent SDK"
+curl -s -X POST "$OPA_URL/v1/data/authz/allow" \
+  -H 'Content-Type: application/json' \
+  -d '{"input": {"attributes": {"request": {"http": {"method": "GET", "path": "/", "headers": {"user-agent": "a2a-python/0.1.0"}}}}}}' | jq .
+
+# Test 8: Gateway request from a crawler
+echo -e "\nTest 8: Gateway request from a crawler"
+curl -s -X POST "$OPA_URL/v1/data/authz/allow" \
+  -H 'Content-Type: application/json' \
+  -d '{"input": {"attributes": {"request": {"http": {"method": "GET", "path": "/", "headers": {"user-agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"}}}}}}' | jq .
+
+# Test 9: Structured upgrade-required deny for an outdated agent SDK
+echo -e "\nTest 9: Upgrade-required response for an outdated agent SDK"
+curl -s -X POST "$OPA_URL/v1/data/authz/result" \
+  -H 'Content-Type: application/json' \
+  -d '{"input": {"attributes": {"request": {"http": {"method": "GET", "path": "/", "headers": {"user-agent": "a2a-python/0.1.0"}}}}}}' | jq .
+
 echo -e "\nTesting completed!"
@@ -0,0 +1,66 @@
+package authz.a2a_test
+
+import data.authz.a2a
+
+# A2A caller restrictions (policies/a2a.rego). The caller's client id is mocked;
+# the envelope is parsed from the request body.
+
+config := {"a2a": {"agents": [{
+    "name": "booking-agent",
+    "hosts": ["booking.localhost"],
+    "callers": {
+        "travel-planner": {"skills": ["quote"], "states": ["new", "continue", "read"]},
+        "auditor": {},
+    },
+}]}}
+
+request(method, body) := {"attributes": {"request": {"http": {
+    "method": method,
+    "path": "/",
+    "host": "booking.localhost",
+    "headers": {},
+}}}, "parsed_body": body}
+
+send(skill) := {"jsonrpc": "2.0", "id": 1, "method": "message/send", "params": {"message": {
+    "role": "user",
+    "parts": [{"kind": "text", "text": "two nights in Lisbon"}],
+    "metadata": {"skill_id": skill},
+}}}
+
+cancel := {"jsonrpc": "2.0", "id": 2, "method": "tasks/cancel", "params": {"id": "task-1"}}
+
+denials(caller, method, body) := reasons if {
+    reasons := a2a.deny with input as request(method, body)
+        with data.authz.settings.config as config
+        with data.authz.token.client_id as caller
+}
+
+codes(reasons) := {r.code | some r in reasons}
+
+test_allowed_skill_and_state if {
+    count(denials("travel-planner", "POST", send("quote"))) == 0
+}
+
+test_unknown_caller_denied if {
+    "caller_not_allowed" in codes(denials("stranger", "POST", send("quote")))
+}
+
+test_unknown_caller_denied_without_envelope if {
+    "caller_not_allowed" in codes(denials("stranger", "GET", null))
+}
+
+test_skill_not_allowed if {
+    "skill_not_allowed" in codes(denials("travel-planner", "POST", send("book")))
+}
+
+test_task_state_not_allowed if {
+    "task_state_not_allowed" in codes(denials("travel-planner", "POST", cancel))
+}
+
+test_limited_caller_needs_envelope if {
+    "envelope_required" in codes(denials("travel-planner", "GET", null))
+}
+
+test_unlimited_caller_needs_no_envelope if {
+    count(denials("auditor", "GET", null)) == 0
+}
@@ -0,0 +1,64 @@
+package authz.access_rules_test
+
+import data.authz
+
+# The user/action/resource rules of policies/authz.rego: the built-in rules and
+# the config.access_rules that `policyctl init --from-builtin` writes out for
+# them, which must decide every request the same way.
+
+generated := [
+    {"name": "admin", "users": ["admin"]},
+    {"name": "read", "actions": ["read"]},
+    {"name": "alice-write-data", "users": ["alice"], "actions": ["write"], "resources": ["data"]},
+    {"name": "admin-delete", "users": ["admin"], "actions": ["delete"]},
+    {"name": "bob-read-reports", "users": ["bob"], "actions": ["read"], "resources": ["reports"]},
+    {"name": "admin-from-network", "users": ["admin"], "actions": ["admin"], "source_networks": ["192.168.1.0/24"]},
+    {"name": "owner-write", "actions": ["write"], "owner_only": true},
+    {"name": "user-api-quota", "users": ["user"], "actions": ["api_call"], "max_request_count": 100},
+]
+
+requests := [
+    {"user": "admin", "action": "delete", "resource": "data"},
+    {"user": "alice", "action": "read", "resource": "data"},
+    {"user": "alice", "action": "write", "resource": "data"},
+    {"user": "alice", "action": "write", "resource": "reports"},
+    {"user": "bob", "action": "read", "resource": "reports"},
+    {"user": "bob", "action": "delete", "resource": "reports"},
+    {"user": "", "action": "read", "resource": "data"},
+    {"user": "admin", "action": "admin", "resource": "x", "source_ip": "192.168.1.20"},
+    {"user": "ops", "action": "admin", "resource": "x", "source_ip": "192.168.1.20"},
+    {"user": "carol", "action": "write", "resource": "doc-1", "resource_owner": "carol"},
+    {"user": "carol", "action": "write", "resource": "doc-2", "resource_owner": "dave"},
+    {"user": "user", "action": "api_call", "resource": "orders", "request_count": 10},
+    {"user": "user", "action": "api_call", "resource": "orders", "request_count": 100},
+    {"user": "guest", "action": "api_call", "resource": "orders", "request_count": 10},
+]
+
+decision(req, config) := allowed if {
+    allowed := authz.allow with input as req
+        with data.authz.settings.config as config
+}
+
+configured_allows(req) if decision(req, {"access_rules": generated})
+
+test_generated_rules_match_builtin if {
+    every req in requests {
+        decision(req, {}) == decision(req, {"access_rules": generated})
+    }
+}
+
+test_examples_gated_by_builtin if {
+    only_reads := {"access_rules": [{"name": "read", "actions": ["read"]}]}
+    not authz.allow with input as {"user": "admin", "action": "admin", "source_ip": "192.168.1.20"}
+        with data.authz.settings.config as only_reads
+    not authz.allow with input as {"user": "carol", "action": "write", "resource_owner": "carol"}
+        with data.authz.settings.config as only_reads
+    not authz.allow with input as {"user": "user", "action": "api_call", "request_count": 1}
+        with data.authz.settings.config as only_reads
+}
+
+test_conditions_narrow_configured_rules if {
+    configured_allows({"user": "admin", "action": "admin", "resource": "x", "source_ip": "192.168.1.20"})
+    not configured_allows({"user": "ops", "action": "admin", "resource": "x", "source_ip": "10.0.0.1"})
+    not configured_allows({"user": "user", "action": "api_call", "resource": "orders", "request_count": 100})
+}
@@ -0,0 +1,77 @@
+package system.authz_test
+
+import data.system.authz
+
+# Authorization of OPA's REST API (policies/admin_authz.rego). The caller's token
+# claims are mocked; role mapping, capabilities and secret paths are real.
+
+config := {"admin": {
+    "audience": "opa-admin",
+    "roles": {
+        "viewer": ["read"],
+        "policy-editor": ["read", "evaluate", "edit_policies"],
+        "operator": ["read", "evaluate", "edit_policies", "manage_state", "replicate"],
+    },
+}}
+
+caller(role) := {"sub": "alice", "realm_access": {"roles": [role]}}
+
+allowed(method, path, role) := result if {
+    result := authz.allow with input as {"method": method, "path": split(path, "/"), "identity": "token"}
+        with data.authz.settings.config as config
+        with data.system.authz.claims as caller(role)
+}
+
+test_health_is_public if {
+    authz.allow with input as {"method": "GET", "path": ["health"]}
+        with data.authz.settings.config as config
+}
+
+test_anonymous_caller_denied if {
+    not authz.allow with input as {"method": "GET", "path": ["v1", "data", "config"]}
+        with data.authz.settings.config as config
+}
+
+test_reader_reads_documents_and_reports if {
+    allowed("GET", "v1/data/config", "viewer")
+    allowed("POST", "v1/data/authz/review/report", "viewer")
+    allowed("GET", "v1/data/authz/selftest/results", "viewer")
+}
+
+test_reader_cannot_evaluate_adhoc if {
+    not allowed("POST", "v1/query", "viewer")
+    not allowed("POST", "v1/compile", "viewer")
+}
+
+test_evaluate_capability_allows_adhoc if {
+    allowed("POST", "v1/query", "policy-editor")
+}
+
+test_secret_packages_denied_to_every_role if {
+    every path in [
+        "v1/data/authz/token",
+        "v1/data/authz/exchange/form",
+        "v1/data/authz/internal_token/minted",
+        "v1/data/system/log/mask",
+        "v1/data/authz",
+        "v1/data",
+        "v1/data/",
+    ] {
+        not allowed("GET", path, "operator")
+    }
+}
+
+test_public_keys_published if {
+    allowed("GET", "v1/data/authz/internal_token/jwks", "viewer")
+}
+
+test_editing_needs_edit_policies if {
+    not allowed("PUT", "v1/data/config", "viewer")
+    allowed("PUT", "v1/data/config", "policy-editor")
+    allowed("PUT", "v1/policies/rules", "policy-editor")
+}
+
+test_state_changes_need_manage_state if {
+    not allowed("PUT", "v1/data/overrides/agent-1", "policy-editor")
+    allowed("PUT", "v1/data/overrides/agent-1", "operator")
+}
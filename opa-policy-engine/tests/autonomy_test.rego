@@ -0,0 +1,79 @@
+package authz.autonomy_test
+
+import data.authz.autonomy
+
+# Autonomy gating (policies/autonomy.rego): autonomous agents need a human
+# co-sign, signed and bound to the agent and the action.
+
+key := {"kty": "oct", "kid": "cosign", "alg": "HS256", "k": "Y29zaWduLWtleS1mb3ItdGVzdHMtb25seQ"}
+
+config := {"autonomy": {
+    "cosign_header": "x-human-cosign",
+    "cosign_jwks": {"keys": [key]},
+    "rules": [
+        {"name": "destructive-methods", "methods": ["DELETE"], "required_level": "supervised"},
+        {"name": "destructive-tools", "tools": ["delete_record"], "required_level": "supervised"},
+    ],
+}}
+
+now := floor(time.now_ns() / 1000000000)
+
+cosign(claims) := io.jwt.encode_sign({"alg": "HS256", "kid": "cosign"}, object.union({
+    "aud": "autonomy-cosign",
+    "sub": "supervisor",
+    "agent": "agent-1",
+    "method": "DELETE",
+    "path": "/orders/42",
+    "exp": now + 300,
+}, claims), key)
+
+deleting(headers) := {"attributes": {"request": {"http": {
+    "method": "DELETE",
+    "path": "/orders/42",
+    "host": "orders.localhost",
+    "headers": headers,
+}}}}
+
+denied(level, headers, tools) if {
+    some r in autonomy.deny with input as deleting(headers)
+        with data.authz.settings.config as config
+        with data.authz.agent.validated as {"autonomy_level": level}
+        with data.authz.principal.id as "agent-1"
+        with data.authz.mcp.tools as tools
+    r.code == "cosign_required"
+}
+
+test_supervised_agent_needs_no_cosign if {
+    not denied("supervised", {}, set())
+}
+
+test_autonomous_agent_denied_without_cosign if {
+    denied("autonomous", {}, set())
+}
+
+test_signed_cosign_allows if {
+    not denied("autonomous", {"x-human-cosign": cosign({})}, set())
+}
+
+test_arbitrary_header_value_rejected if {
+    denied("autonomous", {"x-human-cosign": "yes"}, set())
+}
+
+test_cosign_without_exp_rejected if {
+    denied("autonomous", {"x-human-cosign": cosign({"exp": null})}, set())
+}
+
+test_cosign_bound_to_agent_and_action if {
+    denied("autonomous", {"x-human-cosign": cosign({"agent": "agent-2"})}, set())
+    denied("autonomous", {"x-human-cosign": cosign({"path": "/orders/43"})}, set())
+    denied("autonomous", {"x-human-cosign": cosign({"method": "POST"})}, set())
+}
+
+test_agent_cannot_cosign_itself if {
+    denied("autonomous", {"x-human-cosign": cosign({"sub": "agent-1"})}, set())
+}
+
+test_cosign_must_name_called_tools if {
+    denied("autonomous", {"x-human-cosign": cosign({})}, {"delete_record"})
+    not denied("autonomous", {"x-human-cosign": cosign({"tools": ["delete_record"]})}, {"delete_record"})
+}
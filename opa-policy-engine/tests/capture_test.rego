@@ -0,0 +1,31 @@
+package authz.capture_test
+
+# Full capture of sampled denials (policies/decision.rego, config.capture).
+
+request := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+denial := {"rule": "rules.business-hours", "code": "outside_hours", "message": "outside business hours"}
+
+captured(capture) if {
+    data.authz.capture with input as request
+        with data.authz.settings.config as {"capture": capture}
+        with data.authz.rules.deny as {denial}
+}
+
+test_every_denial_captured_without_rule_filter if {
+    captured({"sample_percent": 100})
+}
+
+test_rule_filter_limits_capture if {
+    captured({"sample_percent": 100, "rules": ["rules.*"]})
+    not captured({"sample_percent": 100, "rules": ["mcp.*"]})
+}
+
+test_nothing_captured_by_default if {
+    not captured({})
+}
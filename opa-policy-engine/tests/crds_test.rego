@@ -0,0 +1,44 @@
+package authz.crds_test
+
+import data.authz.crds
+import data.authz.rules
+
+# AgentAuthPolicy resources (policies/crds.rego): replicated rules join config.rules
+# under namespaced names, only from accepted namespaces and only when enabled.
+
+resources := {
+    "payments": {"lockdown": {"spec": {"rules": [{"name": "no-deletes", "match": {"methods": ["DELETE"]}}]}}},
+    "sandbox": {"trial": {"spec": {"rules": [{"name": "anything", "match": {}}]}}},
+}
+
+enabled := {"agent_auth_policies": {"enabled": true, "namespaces": ["pay*"]}}
+
+test_disabled_ignores_resources if {
+    count(crds.rules) == 0 with data.authz.settings.config as {}
+        with data.kubernetes.agentauthpolicies as resources
+}
+
+test_rule_names_are_namespaced if {
+    names := {r.name | some r in crds.rules} with data.authz.settings.config as enabled
+        with data.kubernetes.agentauthpolicies as resources
+    names == {"payments.lockdown.no-deletes"}
+}
+
+test_every_namespace_accepted_without_globs if {
+    count(crds.rules) == 2 with data.authz.settings.config as {"agent_auth_policies": {"enabled": true}}
+        with data.kubernetes.agentauthpolicies as resources
+}
+
+test_report_lists_rejected_namespaces if {
+    report := crds.report with data.authz.settings.config as enabled
+        with data.kubernetes.agentauthpolicies as resources
+    report.accepted == ["payments/lockdown"]
+    report.rejected == ["sandbox/trial"]
+    report.rules == 1
+}
+
+test_operator_rules_join_configured_rules if {
+    configured := rules.configured with data.authz.settings.config as object.union(enabled, {"rules": [{"name": "local"}]})
+        with data.kubernetes.agentauthpolicies as resources
+    [r.name | some r in configured] == ["local", "payments.lockdown.no-deletes"]
+}
@@ -0,0 +1,34 @@
+package authz.decision_cache_test
+
+# Cacheable allows (policies/decision.rego, config.decision_cache): only allows
+# that hold for the whole TTL advertise one.
+
+config := {
+    "decision_cache": {"ttl_seconds": 30},
+    "profiles": {"default": {"fail_open": true}},
+}
+
+request := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+outage := {"rule": "webhook.orders", "class": "dependency", "code": "authorizer_unavailable", "message": "unavailable"}
+
+test_plain_allow_is_cached if {
+    result := data.authz.result with input as request
+        with data.authz.settings.config as config
+    result.allowed
+    result.dynamic_metadata.cache_ttl_seconds == 30
+}
+
+test_fail_open_allow_is_not_cached if {
+    result := data.authz.result with input as request
+        with data.authz.settings.config as config
+        with data.authz.webhook.deny as {outage}
+    result.allowed
+    result.dynamic_metadata.failed_open == [{"rule": "webhook.orders", "code": "authorizer_unavailable"}]
+    not result.dynamic_metadata.cache_ttl_seconds
+}
@@ -0,0 +1,102 @@
+package authz.dual_control_test
+
+import data.authz.dual_control
+
+# Dual control (policies/dual_control.rego): signed approvals bound to the
+# requester and the operation, in a header or pushed to data.approvals.
+
+key := {"kty": "oct", "kid": "approvals", "alg": "HS256", "k": "YXBwcm92YWwta2V5LWZvci10ZXN0cy1vbmx5"}
+
+config := {"dual_control": {
+    "approval_header": "x-approval",
+    "approval_id_header": "x-approval-id",
+    "approval_audience": "dual-control",
+    "approval_jwks": {"keys": [key]},
+    "routes": [{"name": "cancel-order", "methods": ["POST"], "paths": ["/orders/*/cancel"]}],
+}}
+
+now := floor(time.now_ns() / 1000000000)
+
+approval(claims) := io.jwt.encode_sign({"alg": "HS256", "kid": "approvals"}, object.union({
+    "aud": "dual-control",
+    "sub": "alice",
+    "requester": "agent-1",
+    "method": "POST",
+    "path": "/orders/42/cancel",
+    "exp": now + 300,
+}, claims), key)
+
+cancelling(headers) := {"attributes": {"request": {"http": {
+    "method": "POST",
+    "path": "/orders/42/cancel",
+    "host": "orders.localhost",
+    "headers": headers,
+}}}}
+
+approved_with(headers, approvals) if {
+    dual_control.approved with input as cancelling(headers)
+        with data.authz.settings.config as config
+        with data.authz.principal.id as "agent-1"
+        with data.approvals as approvals
+}
+
+denied_with(headers) if {
+    some r in dual_control.deny with input as cancelling(headers)
+        with data.authz.settings.config as config
+        with data.authz.principal.id as "agent-1"
+    r.code == "approval_required"
+}
+
+test_unapproved_request_denied if {
+    denied_with({})
+}
+
+test_header_approval_approves if {
+    approved_with({"x-approval": approval({})}, {})
+}
+
+test_approval_without_exp_rejected if {
+    not approved_with({"x-approval": approval({"exp": null})}, {})
+}
+
+test_approval_bound_to_requester if {
+    not approved_with({"x-approval": approval({"requester": "agent-2"})}, {})
+}
+
+test_approval_bound_to_operation if {
+    not approved_with({"x-approval": approval({"path": "/orders/43/cancel"})}, {})
+    not approved_with({"x-approval": approval({"method": "DELETE"})}, {})
+}
+
+test_self_approval_rejected if {
+    not approved_with({"x-approval": approval({"sub": "agent-1"})}, {})
+}
+
+test_forged_approval_rejected if {
+    forged := io.jwt.encode_sign({"alg": "HS256"}, {"sub": "alice"}, {"kty": "oct", "k": "b3RoZXI"})
+    not approved_with({"x-approval": forged}, {})
+}
+
+test_pushed_approval_approves if {
+    approved_with({"x-approval-id": "req-123"}, {"req-123": {"token": approval({})}})
+}
+
+test_pushed_approval_needs_signed_token if {
+    not approved_with({"x-approval-id": "req-123"}, {"req-123": {
+        "approver": "alice",
+        "method": "POST",
+        "path": "/orders/42/cancel",
+        "expires_at": "2099-01-01T00:00:00Z",
+    }})
+}
+
+test_pushed_self_approval_rejected if {
+    not approved_with({"x-approval-id": "req-123"}, {"req-123": {"token": approval({"sub": "agent-1"})}})
+}
+
+test_allow_expires_with_approval if {
+    t := approval({})
+    dual_control.expires_at_ns == (now + 300) * 1000000000 with input as cancelling({"x-approval": t})
+        with data.authz.settings.config as config
+        with data.authz.principal.id as "agent-1"
+}
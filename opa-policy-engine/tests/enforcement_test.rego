@@ -0,0 +1,44 @@
+package authz.enforcement_test
+
+import data.authz
+
+# Dry runs (policies/decision.rego): denials that are not enforced are recorded
+# under monitored and the request is allowed.
+
+gateway := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+denial := {"rule": "rules.business-hours", "code": "outside_hours", "message": "outside business hours"}
+
+decided(config, toggles) := result if {
+    result := authz.result with input as gateway
+        with data.authz.settings.config as config
+        with data.enforcement as toggles
+        with data.authz.rules.deny as {denial}
+}
+
+test_enforced_denial_denies if {
+    not decided({}, {}).allowed
+}
+
+test_global_dry_run_only_monitors if {
+    result := decided({"enforcement": {"enforce": false}}, {})
+    result.allowed
+    result.dynamic_metadata.monitored == [{"rule": "rules.business-hours", "code": "outside_hours"}]
+    not result.dynamic_metadata.cache_ttl_seconds
+}
+
+test_rule_toggle_only_monitors_that_rule if {
+    decided({}, {"rules.business-hours": {"enforce": false}}).allowed
+    not decided({}, {"rules.other": {"enforce": false}}).allowed
+}
+
+test_toggle_overrides_rule_setting if {
+    config := {"rules": [{"name": "business-hours", "enforce": false}]}
+    decided(config, {}).allowed
+    not decided(config, {"rules.business-hours": {"enforce": true}}).allowed
+}
@@ -0,0 +1,93 @@
+package authz.evaluation_test
+
+import data.authz.evaluation
+
+# Evaluation traces (policies/evaluation.rego): which decisions are traced and
+# the ordered steps recorded with them.
+
+routes := [
+    {"name": "orders", "hosts": ["orders.localhost"], "paths": ["/**"]},
+    {"name": "reports", "hosts": ["reports.localhost"], "paths": ["/**"], "trace": false},
+]
+
+operator_rules := [
+    {"name": "no-deletes", "methods": ["DELETE"]},
+    {"name": "tenant", "methods": ["GET"], "required_headers": ["x-tenant"]},
+]
+
+tracing(extra) := object.union({
+    "routes": routes,
+    "rules": operator_rules,
+    "evaluation_trace": {"enabled": true, "routes": ["orders"]},
+}, extra)
+
+gateway(method, host, headers) := {"attributes": {"request": {"http": {
+    "method": method,
+    "path": "/orders/42",
+    "host": host,
+    "headers": headers,
+}}}}
+
+deleting := gateway("DELETE", "orders.localhost", {})
+
+test_denials_on_traced_routes_recorded if {
+    evaluation.recorded with input as deleting
+        with data.authz.settings.config as tracing({})
+    trace := data.authz.result.dynamic_metadata.trace with input as deleting
+        with data.authz.settings.config as tracing({})
+    count(trace) > 0
+}
+
+test_untraced_routes_not_recorded if {
+    not evaluation.recorded with input as gateway("DELETE", "reports.localhost", {})
+        with data.authz.settings.config as tracing({"evaluation_trace": {"routes": ["*"]}})
+    not evaluation.recorded with input as deleting
+        with data.authz.settings.config as tracing({"evaluation_trace": {"enabled": false}})
+}
+
+test_allows_recorded_only_with_include_allows if {
+    reading := gateway("GET", "orders.localhost", {"x-tenant": "acme"})
+    not evaluation.recorded with input as reading
+        with data.authz.settings.config as tracing({})
+    evaluation.recorded with input as reading
+        with data.authz.settings.config as tracing({"evaluation_trace": {"include_allows": true}})
+}
+
+test_steps_in_order if {
+    steps := evaluation.steps with input as deleting
+        with data.authz.settings.config as tracing({})
+    [s.rule | some s in steps] == ["routes.match", "rules.no-deletes", "rules.tenant", "decision"]
+    steps[0].routes == ["orders"]
+    steps[1].result == "deny"
+    steps[1].outcome == "enforced"
+    steps[2].result == "not_applicable"
+    steps[3] == {"rule": "decision", "result": "deny", "deciding_rule": "rules.no-deletes"}
+}
+
+test_rule_results if {
+    config := tracing({})
+    reading := gateway("GET", "orders.localhost", {"x-tenant": "acme"})
+    evaluation.rule_result(operator_rules[0]) == "deny" with input as deleting
+        with data.authz.settings.config as config
+    evaluation.rule_result(operator_rules[1]) == "not_applicable" with input as deleting
+        with data.authz.settings.config as config
+    evaluation.rule_result(operator_rules[1]) == "pass" with input as reading
+        with data.authz.settings.config as config
+}
+
+test_monitored_denial_outcome if {
+    dry := tracing({"rules": [{"name": "no-deletes", "methods": ["DELETE"], "enforce": false}]})
+    steps := evaluation.steps with input as deleting
+        with data.authz.settings.config as dry
+    some s in steps
+    s.rule == "rules.no-deletes"
+    s.outcome == "monitored"
+}
+
+test_other_denials_follow_operator_rules if {
+    other := {"rule": "mcp.tools", "code": "tool_not_allowed", "message": "denied"}
+    steps := evaluation.steps with input as deleting
+        with data.authz.settings.config as tracing({})
+        with data.authz.mcp.deny as {other}
+    [s.rule | some s in steps] == ["routes.match", "rules.no-deletes", "rules.tenant", "mcp.tools", "decision"]
+}
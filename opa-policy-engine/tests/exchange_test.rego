@@ -0,0 +1,92 @@
+package authz.exchange_test
+
+import data.authz.exchange
+
+# Token exchange (policies/exchange.rego). Keycloak's answers are mocked; a
+# failed exchange denies, whatever the answer holds.
+
+config := {
+    "routes": [{
+        "name": "orders",
+        "paths": ["/orders", "/orders/**"],
+        "token_exchange": {"audience": "orders-api", "scope": "orders:read"},
+    }],
+    "token_exchange": {
+        "client_id": "policy-engine",
+        "client_secret_env": "TEST_EXCHANGE_SECRET",
+        "token_url": "https://idp.example/realms/agents/protocol/openid-connect/token",
+    },
+}
+
+runtime := {"env": {"TEST_EXCHANGE_SECRET": "exchange-secret"}}
+
+order(headers) := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders/42",
+    "host": "orders.localhost",
+    "headers": headers,
+}}}}
+
+authorized := order({"authorization": "Bearer caller-token"})
+
+answered(response) := reasons if {
+    reasons := exchange.deny with input as authorized
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as response
+}
+
+test_exchanged_token_replaces_the_callers if {
+    response := {"status_code": 200, "body": {"access_token": "narrowed-token", "token_type": "Bearer"}}
+    count(answered(response)) == 0
+    exchange.exchanged == "narrowed-token" with input as authorized
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as response
+}
+
+test_keycloak_error_denies if {
+    some r in answered({"status_code": 400, "body": {"error": "invalid_target"}})
+    r.code == "token_exchange_failed"
+    r.details.error == "invalid_target"
+}
+
+test_non_json_error_denies if {
+    some r in answered({"status_code": 502, "body": null, "raw_body": "<html>Bad Gateway</html>"})
+    r.code == "token_exchange_failed"
+    r.details.error == ""
+}
+
+test_answer_without_token_denies if {
+    some r in answered({"status_code": 200, "body": {"access_token": 42}})
+    r.code == "token_exchange_failed"
+}
+
+test_missing_secret_denies if {
+    reasons := exchange.deny with input as authorized
+        with data.authz.settings.config as config
+        with opa.runtime as {"env": {}}
+        with http.send as {"status_code": 200, "body": {"access_token": "narrowed-token"}}
+    some r in reasons
+    r.code == "token_exchange_failed"
+}
+
+test_requests_without_token_not_exchanged if {
+    count(answered({"status_code": 200, "body": {"access_token": "narrowed-token"}})) == 0
+    reasons := exchange.deny with input as order({})
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as {"status_code": 500}
+    count(reasons) == 0
+}
+
+fail_open := object.union(config, {"profiles": {"default": {"fail_open": true}}})
+
+test_failed_exchange_never_fails_open if {
+    result := data.authz.result with input as authorized
+        with data.authz.settings.config as fail_open
+        with opa.runtime as runtime
+        with http.send as {"status_code": 500, "body": null}
+    result.allowed == false
+    not result.headers.authorization
+}
@@ -0,0 +1,62 @@
+package authz.fips_test
+
+import data.authz.fips
+
+# Restricted-crypto mode (policies/fips.rego): only approved signature algorithms
+# once it is on, and never unsigned tokens.
+
+fips_mode := {"crypto": {"mode": "fips"}}
+
+test_off_by_default if {
+    not fips.enabled with data.authz.settings.config as {}
+        with opa.runtime as {"env": {}}
+    fips.report.mode == "standard" with data.authz.settings.config as {}
+        with opa.runtime as {"env": {}}
+}
+
+test_enabled_by_config_or_environment if {
+    fips.enabled with data.authz.settings.config as fips_mode
+        with opa.runtime as {"env": {}}
+    fips.enabled with data.authz.settings.config as {}
+        with opa.runtime as {"env": {"AUTHZ_CRYPTO_MODE": "fips"}}
+}
+
+test_standard_mode_allows_signature_algorithms_only if {
+    every alg in ["RS256", "ES384", "HS256", "EdDSA"] {
+        fips.allowed(alg) with data.authz.settings.config as {}
+            with opa.runtime as {"env": {}}
+    }
+    not fips.allowed("none") with data.authz.settings.config as {}
+        with opa.runtime as {"env": {}}
+}
+
+test_fips_mode_allows_approved_algorithms_only if {
+    fips.allowed("PS256") with data.authz.settings.config as fips_mode
+        with opa.runtime as {"env": {}}
+    every alg in ["HS256", "EdDSA", "none"] {
+        not fips.allowed(alg) with data.authz.settings.config as fips_mode
+            with opa.runtime as {"env": {}}
+    }
+}
+
+test_hmac_token_denied_in_fips_mode if {
+    reasons := fips.deny with data.authz.settings.config as fips_mode
+        with opa.runtime as {"env": {}}
+        with data.authz.token.header as {"alg": "HS256"}
+    some r in reasons
+    r.code == "algorithm_not_allowed"
+    r.message == "the access token is signed with HS256, which is not FIPS-approved"
+}
+
+test_standard_mode_leaves_token_algorithms_to_token_checks if {
+    count(fips.deny) == 0 with data.authz.settings.config as {}
+        with opa.runtime as {"env": {}}
+        with data.authz.token.header as {"alg": "none"}
+}
+
+test_report_warns_without_go_fips if {
+    report := fips.report with data.authz.settings.config as fips_mode
+        with opa.runtime as {"env": {}}
+    report.go_fips140 == false
+    count(report.warnings) == 1
+}
@@ -0,0 +1,65 @@
+package authz.grants_test
+
+import data.authz
+import data.authz.readiness
+
+# Temporary grants (policies/grants.rego): waived denials, allows that expire with
+# the grant, and the readiness report on replica-local grants.
+
+now := time.parse_rfc3339_ns("2026-01-01T11:00:00Z")
+
+grants := {"g1": {"subject": "agent-1", "rules": ["rules.*"], "expires_at": "2026-01-01T11:10:00Z"}}
+
+gateway := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+denial := {"rule": "rules.business-hours", "code": "outside_hours", "message": "outside business hours"}
+
+decided(subject, config) := result if {
+    result := authz.result with input as gateway
+        with data.authz.settings.config as config
+        with data.grants as grants
+        with data.authz.principal.id as subject
+        with data.authz.rules.deny as {denial}
+        with time.now_ns as now
+}
+
+test_grant_waives_denial_until_expiry if {
+    result := decided("agent-1", {})
+    result.allowed
+    result.dynamic_metadata.grants == ["g1"]
+    result.dynamic_metadata.max_cache_ttl_seconds == 600
+    not result.dynamic_metadata.cache_ttl_seconds
+}
+
+test_grant_covers_only_its_subject if {
+    not decided("agent-2", {}).allowed
+}
+
+test_expired_grant_waives_nothing if {
+    result := authz.result with input as gateway
+        with data.authz.settings.config as {}
+        with data.grants as grants
+        with data.authz.principal.id as "agent-1"
+        with data.authz.rules.deny as {denial}
+        with time.now_ns as time.parse_rfc3339_ns("2026-01-01T11:10:01Z")
+    not result.allowed
+}
+
+test_readiness_reports_pushed_grants if {
+    report := readiness.report with data.grants as grants
+        with data.authz.settings.config as {}
+    "grants" in report.unsafe
+    not "grants" in report.disabled
+}
+
+test_stateless_mode_disables_grants if {
+    report := readiness.report with data.grants as grants
+        with data.authz.settings.config as {"stateless": true}
+    "grants" in report.disabled
+    not decided("agent-1", {"stateless": true}).allowed
+}
@@ -0,0 +1,66 @@
+package authz.internal_token_test
+
+import data.authz
+import data.authz.internal_token
+import data.system
+
+# Internal identity tokens (policies/internal_token.rego): signed on allow for
+# gateway requests, and never handed to REST callers that may only read.
+
+config := {
+    "internal_token": {"enabled": true, "issuer": "opa-policy-engine", "audience": "backends"},
+    "admin": {"audience": "opa-admin", "roles": {
+        "viewer": ["read"],
+        "gateway-adapter": ["read", "decide"],
+    }},
+}
+
+key := {"kty": "oct", "kid": "test", "alg": "HS256", "k": "c2lnbmluZy1rZXktZm9yLXRlc3RzLW9ubHk"}
+
+runtime := {"env": {"AUTHZ_INTERNAL_TOKEN_KEY": json.marshal(key)}}
+
+gateway := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+test_allowed_gateway_request_carries_token if {
+    headers := authz.engine_headers with input as gateway
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+    [_, claims, _] := io.jwt.decode(headers["x-internal-identity"])
+    claims.iss == "opa-policy-engine"
+    claims.aud == "backends"
+}
+
+test_missing_key_denies if {
+    some r in internal_token.deny with input as gateway
+        with data.authz.settings.config as config
+        with opa.runtime as {"env": {}}
+    r.code == "internal_token_unavailable"
+}
+
+rest_allowed(method, path, role) if {
+    system.authz.allow with input as {"method": method, "path": split(path, "/"), "identity": "token"}
+        with data.authz.settings.config as config
+        with data.system.authz.claims as {"sub": "alice", "realm_access": {"roles": [role]}}
+}
+
+test_reader_cannot_obtain_internal_identity if {
+    every path in [
+        "v1/data/authz/result",
+        "v1/data/authz/upstream_headers",
+        "v1/data/authz/engine_headers/x-internal-identity",
+        "v1/data/authz/internal_token/minted",
+    ] {
+        not rest_allowed("POST", path, "viewer")
+        not rest_allowed("GET", path, "viewer")
+    }
+}
+
+test_gateway_adapter_decides if {
+    rest_allowed("POST", "v1/data/authz/result", "gateway-adapter")
+    not rest_allowed("POST", "v1/data/authz/internal_token/minted", "gateway-adapter")
+}
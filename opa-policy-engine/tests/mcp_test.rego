@@ -0,0 +1,98 @@
+package authz.mcp_test
+
+import data.authz.mcp
+
+# MCP tool manifests (policies/mcp.rego): unknown and disallowed tools, argument
+# constraints, batches and unreadable bodies.
+
+manifest := {"records": {
+    "paths": ["/mcp/records", "/mcp/records/**"],
+    "tools": {
+        "search": {"allowed": true, "constraints": {"query": {"required": true, "not_pattern": "(?i)drop\\s+table"}}},
+        "delete_record": {"allowed": false},
+    },
+}}
+
+post(body) := {"attributes": {"request": {"http": {
+    "method": "POST",
+    "path": "/mcp/records",
+    "host": "mcp.localhost",
+    "headers": {"content-type": "application/json"},
+}}}, "parsed_body": body}
+
+call(name, arguments) := {"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": name, "arguments": arguments}}
+
+denials(body) := reasons if {
+    reasons := mcp.deny with input as post(body) with data.mcp as manifest
+}
+
+codes(reasons) := {r.code | some r in reasons}
+
+test_allowed_call_passes if {
+    count(denials(call("search", {"query": "orders"}))) == 0
+}
+
+test_unknown_tool_denied if {
+    some r in denials(call("export_all", {}))
+    r.code == "tool_not_allowed"
+    r.message == `tool "export_all" is not in the records manifest`
+}
+
+test_disallowed_tool_denied if {
+    some r in denials(call("delete_record", {"id": 7}))
+    r.rule == "mcp.records.delete_record"
+}
+
+test_batch_cannot_hide_a_call if {
+    batch := [
+        {"jsonrpc": "2.0", "id": 1, "method": "tools/list"},
+        call("search", {"query": "orders"}),
+        call("delete_record", {"id": 7}),
+    ]
+    some r in denials(batch)
+    r.rule == "mcp.records.delete_record"
+}
+
+test_nameless_call_denied if {
+    every body in [
+        {"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {}},
+        {"jsonrpc": "2.0", "id": 1, "method": "tools/call"},
+        call(["search"], {"query": "orders"}),
+    ] {
+        some r in denials(body)
+        r.message == "tools/call names no tool"
+    }
+}
+
+test_unreadable_body_denied if {
+    reasons := mcp.deny with input as {"attributes": {"request": {"http": {
+        "method": "POST",
+        "path": "/mcp/records",
+        "host": "mcp.localhost",
+        "headers": {"content-type": "text/plain"},
+    }}}}
+        with data.mcp as manifest
+    "unreadable_body" in codes(reasons)
+}
+
+test_missing_required_argument_denied if {
+    some r in denials(call("search", {}))
+    r.code == "argument_not_allowed"
+    r.details.violations == [`argument "query" is required`]
+}
+
+test_nested_forbidden_argument_denied if {
+    some r in denials(call("search", {"query": {"text": "x; DROP TABLE orders"}}))
+    r.rule == "mcp.records.search.arguments"
+}
+
+test_other_paths_unaffected if {
+    reasons := mcp.deny with input as {"attributes": {"request": {"http": {
+        "method": "POST",
+        "path": "/orders",
+        "host": "orders.localhost",
+        "headers": {},
+    }}}}
+        with data.mcp as manifest
+    count(reasons) == 0
+}
@@ -0,0 +1,88 @@
+package authz.overrides_test
+
+import data.authz
+import data.authz.overrides
+
+# Per-subject overrides (policies/overrides.rego): blocks, exemptions, expiry and
+# what an exemption may waive (see decision.rego).
+
+now := time.parse_rfc3339_ns("2026-01-01T11:00:00Z")
+
+gateway := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+reason(rule, class) := {"rule": rule, "class": class, "code": "denied", "message": "denied"}
+
+denial := {"rule": "rules.business-hours", "code": "outside_hours", "message": "outside business hours"}
+
+decided(stored) := result if {
+    result := authz.result with input as gateway
+        with data.overrides as stored
+        with data.authz.token.subject as "agent-1"
+        with data.authz.rules.deny as {denial}
+        with time.now_ns as now
+}
+
+test_block_denies if {
+    result := decided({"o1": {"subject": "agent-1", "effect": "deny"}})
+    not result.allowed
+    overrides.block.id == "o1" with input as gateway
+        with data.overrides as {"o1": {"subject": "agent-1", "effect": "deny"}}
+        with data.authz.token.subject as "agent-1"
+}
+
+test_exemption_waives_policy_denials if {
+    decided({"o1": {"subject": "agent-1", "effect": "allow"}}).allowed
+    not decided({}).allowed
+}
+
+test_exemptions_waive_policy_denials_only if {
+    authz.exemptable(reason("rules.business-hours", "policy")) with input as gateway
+    not authz.exemptable(reason("token.verification", "token")) with input as gateway
+    not authz.exemptable(reason("callers.authentication", "policy")) with input as gateway
+    not authz.exemptable(reason("degradation.webhook", "dependency")) with input as gateway
+}
+
+test_block_wins_over_exemption if {
+    not decided({
+        "vip": {"subject": "agent-1", "effect": "allow"},
+        "rogue": {"subject": "agent-1", "effect": "deny"},
+    }).allowed
+}
+
+test_other_subjects_unaffected if {
+    not decided({"o1": {"subject": "agent-2", "effect": "allow"}}).allowed
+}
+
+test_expired_override_ignored if {
+    not decided({"o1": {"subject": "agent-1", "effect": "allow", "expires_at": "2026-01-01T10:00:00Z"}}).allowed
+    decided({"o1": {"subject": "agent-1", "effect": "allow", "expires_at": "2026-01-01T12:00:00Z"}}).allowed
+}
+
+test_unparseable_expiry_is_invalid_config if {
+    stored := {"o1": {"subject": "agent-1", "effect": "allow", "expires_at": "tomorrow"}}
+    reasons := overrides.deny with input as gateway
+        with data.overrides as stored
+        with data.authz.token.subject as "agent-1"
+        with time.now_ns as now
+    some r in reasons
+    r.code == "invalid_config"
+    r.class == "config"
+    not decided(stored).allowed
+}
+
+test_unparseable_expiry_does_not_lift_a_block if {
+    stored := {"o1": {"subject": "agent-1", "effect": "deny", "expires_at": 1767225600}}
+    not decided(stored).allowed
+}
+
+test_stateless_mode_ignores_overrides if {
+    not overrides.applied with input as gateway
+        with data.overrides as {"o1": {"subject": "agent-1", "effect": "allow"}}
+        with data.authz.token.subject as "agent-1"
+        with data.authz.readiness.stateless as true
+}
@@ -0,0 +1,100 @@
+package authz.profiles_test
+
+import data.authz
+import data.authz.profiles
+import data.system.log
+
+# Gateway profiles (policies/profiles.rego): selection by context extension, the
+# rules each profile applies, failing open and decision logging.
+
+config := {"profiles": {
+    "default": {"rules": ["rules.*"]},
+    "egress": {"rules": ["mcp.*"], "fail_open": true, "log": "denies"},
+    "quiet": {"log": "none"},
+}}
+
+gateway(extensions) := {"attributes": {
+    "request": {"http": {"method": "GET", "path": "/orders", "host": "orders.localhost", "headers": {}}},
+    "contextExtensions": extensions,
+}}
+
+reason(rule, class) := {"rule": rule, "class": class, "code": "denied", "message": "denied"}
+
+policy_denial := {"rule": "mcp.tools", "code": "tool_not_allowed", "message": "denied"}
+
+test_profile_selected_by_context_extension if {
+    profiles.name == "egress" with input as gateway({"profile": "egress"})
+        with data.authz.settings.config as config
+    profiles.name == "default" with input as gateway({"profile": "unknown"})
+        with data.authz.settings.config as config
+    profiles.fail_open with input as gateway({"profile": "egress"})
+        with data.authz.settings.config as config
+    not profiles.fail_open with input as gateway({})
+        with data.authz.settings.config as config
+}
+
+test_profile_without_rules_applies_every_rule if {
+    profiles.in_scope("mcp.tools") with input as gateway({"profile": "quiet"})
+        with data.authz.settings.config as config
+}
+
+test_profile_rules_limit_policy_denials if {
+    authz.in_scope(reason("rules.business-hours", "policy")) with input as gateway({})
+        with data.authz.settings.config as config
+    not authz.in_scope(policy_denial) with input as gateway({})
+        with data.authz.settings.config as config
+}
+
+egress_scope(r) if {
+    authz.in_scope(r) with input as gateway({"profile": "egress"})
+        with data.authz.settings.config as config
+}
+
+test_token_config_callers_degradation_always_in_scope if {
+    every r in [
+        reason("token.verification", "token"),
+        reason("blueprint.orders", "config"),
+        reason("callers.authentication", "policy"),
+        reason("degradation.webhook", "dependency"),
+    ] {
+        egress_scope(r)
+    }
+}
+
+test_out_of_scope_denial_does_not_deny if {
+    denials := authz.applicable_deny with input as gateway({})
+        with data.authz.settings.config as config
+        with data.authz.mcp.deny as {policy_denial}
+    not policy_denial in denials
+}
+
+test_fail_open_profile_passes_dependency_failures if {
+    failing := reason("mcp.webhook", "dependency")
+    failed := authz.failed_open with input as gateway({"profile": "egress"})
+        with data.authz.settings.config as config
+        with data.authz.mcp.deny as {failing}
+    failing in failed
+}
+
+test_fail_closed_profile_keeps_dependency_failures if {
+    failing := reason("rules.webhook", "dependency")
+    failed := authz.failed_open with input as gateway({})
+        with data.authz.settings.config as config
+        with data.authz.rules.deny as {failing}
+    not failing in failed
+}
+
+logged(extensions, allowed) if {
+    not log.drop with input as {"input": gateway(extensions), "result": {"allowed": allowed}}
+        with data.authz.settings.config as config
+}
+
+test_denies_profile_logs_only_denials if {
+    logged({"profile": "egress"}, false)
+    not logged({"profile": "egress"}, true)
+}
+
+test_none_profile_logs_nothing if {
+    not logged({"profile": "quiet"}, false)
+    logged({}, true)
+}
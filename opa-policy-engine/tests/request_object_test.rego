@@ -0,0 +1,58 @@
+package authz.request_object_test
+
+import data.authz.degradation
+import data.authz.request_object
+
+# Request objects (policies/request_object.rego): fetching the calling client's
+# keys under the degradation tiers (see degradation.rego).
+
+presenting := {"attributes": {"request": {"http": {
+    "method": "POST",
+    "path": "/orders",
+    "host": "orders.localhost",
+    "headers": {"x-request-object": "eyJhbGciOiJSUzI1NiJ9.e30.c2ln"},
+}}}}
+
+config(tier) := {
+    "request_objects": {
+        "audience": "orders",
+        "clients": {"travel-planner": {"jwks_url": "https://idp.example/clients/travel-planner/certs"}},
+    },
+    "degradation": {"tier": tier},
+}
+
+keys := {"status_code": 200, "body": {"keys": []}}
+
+fetched(tier) if {
+    request_object.client_keys with input as presenting
+        with data.authz.settings.config as config(tier)
+        with data.authz.token.client_id as "travel-planner"
+        with opa.runtime as {"env": {}}
+        with http.send as keys
+}
+
+test_client_keys_fetched_while_cached if {
+    fetched("full")
+    fetched("cached_only")
+}
+
+test_client_keys_not_fetched_on_local_rules if {
+    not fetched("local_rules_only")
+}
+
+test_skipped_fetch_denied_as_degraded if {
+    reasons := degradation.deny with input as presenting
+        with data.authz.settings.config as config("local_rules_only")
+        with data.authz.token.client_id as "travel-planner"
+        with opa.runtime as {"env": {}}
+    some r in reasons
+    r.rule == "degradation.request_object_keys"
+    r.status == 503
+}
+
+test_inline_client_keys_need_no_call if {
+    inline := {"request_objects": {"clients": {"travel-planner": {"jwks": {"keys": []}}}}}
+    not "request_object_keys" in degradation.calls with input as presenting
+        with data.authz.settings.config as inline
+        with data.authz.token.client_id as "travel-planner"
+}
@@ -0,0 +1,98 @@
+package authz.rules_test
+
+import data.authz.degradation
+import data.authz.rules
+
+# Operator rules (policies/rules.rego): matchers, required headers and CEL
+# conditions, whose evaluator (tools/cel_evaluator.py) is mocked.
+
+gateway(method, path, headers) := {"attributes": {"request": {"http": {
+    "method": method,
+    "path": path,
+    "host": "orders.localhost",
+    "headers": headers,
+}}}}
+
+deleting := gateway("DELETE", "/orders/42", {})
+
+cel_rule := {"name": "admin-deletes", "cel": "request.method == 'DELETE' && !('admin' in roles)"}
+
+with_cel(extra) := object.union({"cel": {"url": "http://127.0.0.1:9595/evaluate"}, "rules": [cel_rule]}, extra)
+
+ids(reasons) := {sprintf("%s/%s", [r.rule, r.code]) | some r in reasons}
+
+cel_denials(answer, extra) := result if {
+    result := ids(rules.deny) with input as deleting
+        with data.authz.settings.config as with_cel(extra)
+        with opa.runtime as {"env": {}}
+        with http.send as answer
+}
+
+test_unconditional_rule_denies_selected_requests if {
+    config := {"rules": [{"name": "no-deletes", "methods": ["DELETE"], "paths": ["/orders/**"]}]}
+    ids(rules.deny) == {"rules.no-deletes/rule_denied"} with input as deleting
+        with data.authz.settings.config as config
+    count(rules.deny) == 0 with input as gateway("GET", "/orders/42", {})
+        with data.authz.settings.config as config
+}
+
+test_required_header_present_passes if {
+    config := {"rules": [{"name": "tenant", "required_headers": ["x-tenant"]}]}
+    count(rules.deny) == 1 with input as deleting
+        with data.authz.settings.config as config
+    count(rules.deny) == 0 with input as gateway("DELETE", "/orders/42", {"x-tenant": "acme"})
+        with data.authz.settings.config as config
+}
+
+test_cel_match_denies if {
+    cel_denials({"status_code": 200, "body": {"result": true}}, {}) == {"rules.admin-deletes/rule_denied"}
+}
+
+test_cel_no_match_allows if {
+    count(cel_denials({"status_code": 200, "body": {"result": false}}, {})) == 0
+}
+
+test_cel_error_on_request_denies if {
+    cel_denials({"status_code": 200, "body": {"error": "no such key: roles"}}, {}) == {"rules.admin-deletes/rule_denied"}
+}
+
+test_cel_compile_error_is_config_error if {
+    cel_denials({"status_code": 400, "body": {"error": "syntax error"}}, {}) == {"rules.admin-deletes/invalid_config"}
+}
+
+test_cel_evaluator_down_is_dependency_failure if {
+    cel_denials({"status_code": 0, "error": {"message": "connection refused"}}, {}) == {"rules.admin-deletes/cel_unavailable"}
+}
+
+# Answers only requests that OPA caches for config.cel.cache_seconds.
+cached_answer(req) := {"status_code": 200, "body": {"result": true}} if {
+    req.force_cache == true
+    req.force_cache_duration_seconds == 5
+}
+
+test_cel_evaluations_are_cached if {
+    reasons := rules.deny with input as deleting
+        with data.authz.settings.config as with_cel({"cel": {"cache_seconds": 5}})
+        with http.send as cached_answer
+    ids(reasons) == {"rules.admin-deletes/rule_denied"}
+    degradation.cacheable.cel == true
+}
+
+test_cel_runs_when_cached_only if {
+    denials := cel_denials({"status_code": 200, "body": {"result": true}}, {"degradation": {"tier": "cached_only"}})
+    denials == {"rules.admin-deletes/rule_denied"}
+}
+
+test_cel_skipped_when_local_rules_only if {
+    config := with_cel({"degradation": {"tier": "local_rules_only"}})
+    "cel" in degradation.calls with input as deleting
+        with data.authz.settings.config as config
+    reasons := degradation.deny with input as deleting
+        with data.authz.settings.config as config
+        with opa.runtime as {"env": {}}
+    "degradation.cel/degraded" in ids(reasons)
+    not rules.cel_evaluation(cel_rule.cel) with input as deleting
+        with data.authz.settings.config as config
+        with opa.runtime as {"env": {}}
+        with http.send as {"status_code": 200, "body": {"result": false}}
+}
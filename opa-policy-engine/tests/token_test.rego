@@ -0,0 +1,192 @@
+package authz.token_test
+
+import data.authz.token
+
+# Token verification (policies/token.rego). HS256 tokens are signed here with a
+# secret the mocked runtime environment hands to the engine, so no JWKS is needed.
+
+issuer := "https://idp.example/realms/agents"
+
+verifying := {"token": {
+    "verify": true,
+    "issuer": issuer,
+    "audience": "agents",
+    "jwks_url": "https://idp.example/certs",
+    "anonymous_paths": ["/.well-known/**"],
+}}
+
+hmac_verifying := {"token": {
+    "verify": true,
+    "providers": [{
+        "name": "keycloak",
+        "type": "keycloak",
+        "issuer": issuer,
+        "audience": "agents",
+        "algorithms": ["HS256"],
+        "hmac_secret_env": "TEST_HMAC_SECRET",
+    }],
+}}
+
+runtime := {"env": {"TEST_HMAC_SECRET": "test-secret"}}
+
+gateway(path, headers) := {"attributes": {"request": {"http": {
+    "method": "GET",
+    "path": path,
+    "host": "orders.localhost",
+    "headers": headers,
+}}}}
+
+presenting(t) := gateway("/orders", {"authorization": sprintf("Bearer %s", [t])})
+
+now := floor(time.now_ns() / 1000000000)
+
+signed(claims, secret) := io.jwt.encode_sign(
+    {"alg": "HS256", "typ": "JWT"},
+    object.union({"iss": issuer, "aud": "agents", "sub": "agent-1"}, claims),
+    {"kty": "oct", "k": base64url.encode_no_pad(secret)},
+)
+
+codes(reasons) := {r.code | some r in reasons}
+
+test_missing_token_denied if {
+    reasons := token.deny with input as gateway("/orders", {})
+        with data.authz.settings.config as verifying
+    "token_missing" in codes(reasons)
+}
+
+test_anonymous_path_needs_no_token if {
+    reasons := token.deny with input as gateway("/.well-known/agent.json", {})
+        with data.authz.settings.config as verifying
+    count(reasons) == 0
+}
+
+test_valid_token_verified if {
+    t := signed({"exp": now + 300, "azp": "travel-planner"}, "test-secret")
+    reasons := token.deny with input as presenting(t)
+        with data.authz.settings.config as hmac_verifying
+        with opa.runtime as runtime
+    count(reasons) == 0
+    token.claims.sub == "agent-1" with input as presenting(t)
+        with data.authz.settings.config as hmac_verifying
+        with opa.runtime as runtime
+}
+
+test_forged_token_invalid if {
+    reasons := token.deny with input as presenting(signed({"exp": now + 300}, "another-secret"))
+        with data.authz.settings.config as hmac_verifying
+        with opa.runtime as runtime
+    some r in reasons
+    r.code == "invalid_token"
+    r.message == "the access token could not be verified"
+}
+
+no_keys := {"status_code": 200, "body": {"keys": []}}
+
+unsigned(claims) := sprintf("%s.%s.", [
+    base64url.encode_no_pad(json.marshal({"alg": "none", "typ": "JWT"})),
+    base64url.encode_no_pad(json.marshal(claims)),
+])
+
+test_garbage_bearer_is_malformed if {
+    reasons := token.deny with input as presenting("not-a-jwt")
+        with data.authz.settings.config as verifying
+        with http.send as no_keys
+    some r in reasons
+    r.code == "invalid_token"
+    r.message == "the access token is malformed"
+}
+
+test_unsigned_token_rejected_without_verify if {
+    reasons := token.deny with input as presenting(unsigned({"iss": issuer, "sub": "agent-1"}))
+        with data.authz.settings.config as {"token": {"verify": false, "issuer": issuer}}
+    "algorithm_not_allowed" in codes(reasons)
+}
+
+test_hmac_rejected_unless_listed if {
+    reasons := token.deny with input as presenting(signed({"exp": now + 300}, "test-secret"))
+        with data.authz.settings.config as verifying
+        with opa.runtime as runtime
+        with http.send as no_keys
+    some r in reasons
+    r.code == "algorithm_not_allowed"
+    r.message == "tokens signed with HS256 are not accepted from this issuer"
+}
+
+test_expired_token_soft_denied if {
+    reasons := token.deny with input as presenting(signed({"exp": now - 60}, "test-secret"))
+        with data.authz.settings.config as hmac_verifying
+        with opa.runtime as runtime
+    codes(reasons) == {"token_expired"}
+    some r in reasons
+    r.soft == true
+}
+
+test_forged_expired_token_not_soft if {
+    reasons := token.deny with input as presenting(signed({"exp": now - 60}, "another-secret"))
+        with data.authz.settings.config as hmac_verifying
+        with opa.runtime as runtime
+    codes(reasons) == {"invalid_token"}
+}
+
+jwe := "eyJhbGciOiJSU0EtT0FFUCIsImVuYyI6IkEyNTZHQ00ifQ.a2V5.aXY.Y2lwaGVydGV4dA.dGFn"
+
+test_encrypted_token_rejected_without_decryptor if {
+    reasons := token.deny with input as presenting(jwe)
+        with data.authz.settings.config as verifying
+        with http.send as no_keys
+    "encrypted_token_unsupported" in codes(reasons)
+}
+
+test_encrypted_token_decrypted if {
+    inner := signed({"exp": now + 300}, "test-secret")
+    config := object.union(hmac_verifying, {"token": {"decryption": {"url": "http://127.0.0.1:9494/decrypt"}}})
+    reasons := token.deny with input as presenting(jwe)
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as {"status_code": 200, "body": {"token": inner}}
+    count(reasons) == 0
+}
+
+test_undecryptable_token_invalid if {
+    config := object.union(hmac_verifying, {"token": {"decryption": {"url": "http://127.0.0.1:9494/decrypt"}}})
+    reasons := token.deny with input as presenting(jwe)
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as {"status_code": 400, "body": {}}
+    some r in reasons
+    r.code == "invalid_token"
+    r.message == "the encrypted access token could not be decrypted"
+}
+
+test_decryptor_down_is_dependency_failure if {
+    config := object.union(hmac_verifying, {"token": {"decryption": {"url": "http://127.0.0.1:9494/decrypt"}}})
+    reasons := token.deny with input as presenting(jwe)
+        with data.authz.settings.config as config
+        with opa.runtime as runtime
+        with http.send as {"status_code": 0, "error": {"message": "connection refused"}}
+    some r in reasons
+    r.code == "decryption_unavailable"
+    r.class == "dependency"
+}
+
+introspecting := object.union(verifying, {"token": {"introspection": {
+    "client_id": "policy-engine",
+    "client_secret_env": "TEST_INTROSPECTION_SECRET",
+}}})
+
+test_inactive_opaque_token_denied if {
+    reasons := token.deny with input as presenting("opaque-token")
+        with data.authz.settings.config as introspecting
+        with opa.runtime as {"env": {"TEST_INTROSPECTION_SECRET": "s"}}
+        with http.send as {"status_code": 200, "body": {"active": false}}
+    some r in reasons
+    r.message == "the access token is not active"
+}
+
+test_introspection_without_secret_fails_closed if {
+    reasons := token.deny with input as presenting("opaque-token")
+        with data.authz.settings.config as introspecting
+        with opa.runtime as {"env": {}}
+        with http.send as no_keys
+    "introspection_unavailable" in codes(reasons)
+}
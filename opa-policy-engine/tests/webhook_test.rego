@@ -0,0 +1,59 @@
+package authz.webhook_test
+
+import data.authz.webhook
+
+# Per-route external authorizers (policies/webhook.rego). The authorizer's
+# answers are mocked; anything but {"allow": true} denies.
+
+config := {"routes": [{
+    "name": "orders",
+    "paths": ["/orders", "/orders/**"],
+    "webhook": {"url": "https://authz.orders.example/check"},
+}]}
+
+order := {"attributes": {"request": {"http": {
+    "method": "POST",
+    "path": "/orders/42",
+    "host": "orders.localhost",
+    "headers": {},
+}}}}
+
+answered(response) := reasons if {
+    reasons := webhook.deny with input as order
+        with data.authz.settings.config as config
+        with http.send as response
+}
+
+test_allowed_by_authorizer if {
+    count(answered({"status_code": 200, "body": {"allow": true}})) == 0
+}
+
+test_denial_carries_authorizer_code if {
+    some r in answered({"status_code": 200, "body": {"allow": false, "code": "over_budget", "message": "budget exceeded"}})
+    r.code == "over_budget"
+    r.message == "budget exceeded"
+}
+
+test_non_object_answer_denies if {
+    some r in answered({"status_code": 200, "body": ["allow"]})
+    r.code == "webhook_denied"
+}
+
+test_malformed_code_and_message_ignored if {
+    some r in answered({"status_code": 200, "body": {"allow": "yes", "code": 7, "message": false}})
+    r.code == "webhook_denied"
+    r.message == "denied by the route's authorizer"
+}
+
+test_unreachable_authorizer_fails_closed if {
+    some r in answered({"status_code": 502, "body": null})
+    r.code == "authorizer_unavailable"
+    r.class == "dependency"
+}
+
+test_routes_without_webhook_unaffected if {
+    reasons := webhook.deny with input as order
+        with data.authz.settings.config as {"routes": [{"name": "orders", "paths": ["/orders/**"]}]}
+        with http.send as {"status_code": 200, "body": {"allow": false}}
+    count(reasons) == 0
+}
@@ -0,0 +1,253 @@
+#!/usr/bin/env python3
+"""
+Admin HTTP API for operating the engine without reading container logs.
+
+Reads OPA's log stream on stdin (like log_router.py), keeps the most recent
+decisions in memory and serves:
+
+  GET  /rules                    configured routes and operator rules, with any
+                                 enforcement toggles
+  GET  /decisions?n=50           the last n decisions as compact audit records
+                                 (newest first); ?denied=true for denials only
+  PUT  /rules/<rule id>/enforce  {"enforce": false, "reason": "..."} switches a
+                                 rule to dry run (true enforces it again)
+  DELETE /rules/<rule id>/enforce
+                                 drops the toggle, back to the rule's own setting
+  POST /reload                   re-reads the policies and data files and pushes
+                                 them to OPA
+
+Every request needs a bearer token. By default the token is forwarded with every
+call to the REST API and OPA authorizes it, so with run-opa.sh --admin-rbac the
+Keycloak roles in policies/admin_authz.rego apply (read for the GET endpoints,
+edit_policies for the rest); the API refuses to start if OPA answers
+unauthenticated calls. Without --admin-rbac, --token-env names an environment
+variable holding the one token accepted. /decisions is checked with a read of the
+rules. The API listens on localhost unless --bind says otherwise.
+
+/reload replaces data.config with policies/data.yaml plus the --rules files, so
+keys deleted from the files are deleted in OPA too. Reloading data files needs
+PyYAML.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/admin_api.py --port 8282 --rules rules.yaml
+"""
+
+import argparse
+import hmac
+import json
+import os
+import sys
+import threading
+import urllib.error
+import urllib.parse
+import urllib.request
+from collections import deque
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+from log_router import audit_record, parse
+
+OPA_URL = os.environ.get("OPA_URL", "http://localhost:8181")
+POLICY_DIR = os.path.join(os.path.dirname(__file__), "..", "policies")
+RULES_FILES = []
+API_TOKEN = None
+
+recent = deque(maxlen=1000)
+lock = threading.Lock()
+
+
+def opa(method, path, authorization, body=None, raw=False):
+    """Calls the OPA REST API as the caller; returns (status, parsed body)."""
+    data = None
+    headers = {}
+    if body is not None:
+        data = body.encode() if raw else json.dumps(body).encode()
+        headers["Content-Type"] = "text/plain" if raw else "application/json"
+    if authorization:
+        headers["Authorization"] = authorization
+    request = urllib.request.Request(f"{OPA_URL}{path}", data=data, headers=headers, method=method)
+    try:
+        with urllib.request.urlopen(request, timeout=10) as response:
+            text = response.read()
+            return response.status, json.loads(text) if text else {}
+    except urllib.error.HTTPError as e:
+        return e.code, {"error": e.reason}
+
+
+def reload_files(authorization):
+    """Pushes every policy module and data file under POLICY_DIR to OPA."""
+    try:
+        import yaml
+    except ImportError:
+        return 500, {"error": "PyYAML is required to reload data files: pip install pyyaml"}
+    # Modules keep the ids OPA loaded them under, or they would be added twice.
+    _, policies = opa("GET", "/v1/policies", authorization)
+    ids = [policy["id"] for policy in policies.get("result", [])]
+    loaded = []
+    for root, _, files in os.walk(POLICY_DIR):
+        for name in sorted(files):
+            path = os.path.join(root, name)
+            relative = os.path.relpath(path, POLICY_DIR)
+            if name.endswith(".rego"):
+                module_id = next((i for i in ids if i.endswith(f"/{relative}")), relative)
+                with open(path) as f:
+                    status, body = opa("PUT", f"/v1/policies/{module_id}", authorization, f.read(), raw=True)
+            elif name == "data.yaml":
+                with open(path) as f:
+                    document = yaml.safe_load(f) or {}
+                prefix = os.path.dirname(relative)
+                if prefix:
+                    status, body = opa("PUT", f"/v1/data/{prefix}", authorization, document)
+                else:
+                    # The whole document is replaced, so deleted keys do not survive;
+                    # the --rules files add theirs, as OPA merges them at startup.
+                    config = dict(document["config"])
+                    for rules_file in RULES_FILES:
+                        with open(rules_file) as f:
+                            config.update((yaml.safe_load(f) or {}).get("config", {}))
+                    status, body = opa("PUT", "/v1/data/config", authorization, config)
+            else:
+                continue
+            if status >= 300:
+                return status, {"error": f"{relative}: {body.get('error', status)}", "loaded": loaded}
+            loaded.append(relative)
+    return 200, {"loaded": loaded}
+
+
+def opa_authenticates():
+    """Whether OPA rejects REST calls without a token (run-opa.sh --admin-rbac)."""
+    status, _ = opa("GET", "/v1/data/authz/coverage/inventory", None)
+    return status == 401
+
+
+class AdminHandler(BaseHTTPRequestHandler):
+    def authenticated(self):
+        """Requires a bearer token: the --token-env one, or any for OPA to authorize."""
+        authorization = self.headers.get("Authorization") or ""
+        if not authorization.lower().startswith("bearer ") or not authorization[7:].strip():
+            self.reply(401, {"error": "a bearer token is required"})
+            return False
+        if API_TOKEN is not None and not hmac.compare_digest(authorization[7:].strip().encode(), API_TOKEN.encode()):
+            self.reply(401, {"error": "invalid token"})
+            return False
+        return True
+
+    def reply(self, status, body):
+        payload = json.dumps(body, indent=2).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def rule_id(self):
+        parts = urllib.parse.urlparse(self.path).path.strip("/").split("/")
+        if len(parts) == 3 and parts[0] == "rules" and parts[2] == "enforce":
+            return urllib.parse.unquote(parts[1])
+        return None
+
+    def do_GET(self):
+        if not self.authenticated():
+            return
+        url = urllib.parse.urlparse(self.path)
+        query = urllib.parse.parse_qs(url.query)
+        authorization = self.headers.get("Authorization")
+        status, inventory = opa("GET", "/v1/data/authz/coverage/inventory", authorization)
+        if status != 200:
+            self.reply(status, inventory)
+            return
+        if url.path == "/rules":
+            _, toggles = opa("GET", "/v1/data/enforcement", authorization)
+            _, rules = opa("GET", "/v1/data/authz/rules/configured", authorization)
+            self.reply(200, {
+                "routes": inventory["result"]["routes"],
+                "rules": rules.get("result", []),
+                "enforcement": toggles.get("result", {}),
+            })
+        elif url.path == "/decisions":
+            try:
+                n = int(query.get("n", ["50"])[0])
+            except ValueError:
+                self.reply(400, {"error": "n must be an integer"})
+                return
+            with lock:
+                records = list(reversed(recent))
+            if query.get("denied", ["false"])[0] == "true":
+                records = [r for r in records if r["decision"] != "allow"]
+            self.reply(200, records[:n])
+        else:
+            self.reply(404, {"error": "not found"})
+
+    def do_PUT(self):
+        if not self.authenticated():
+            return
+        rule = self.rule_id()
+        if rule is None:
+            self.reply(404, {"error": "not found"})
+            return
+        try:
+            toggle = json.loads(self.rfile.read(int(self.headers.get("content-length") or 0)))
+            enforce = bool(toggle["enforce"])
+        except (ValueError, KeyError, TypeError):
+            self.reply(400, {"error": 'expected {"enforce": true|false}'})
+            return
+        body = {"enforce": enforce, "reason": toggle.get("reason", "")}
+        status, result = opa("PUT", f"/v1/data/enforcement/{urllib.parse.quote(rule, safe='')}", self.headers.get("Authorization"), body)
+        self.reply(200 if status == 204 else status, {"rule": rule, **body} if status == 204 else result)
+
+    def do_DELETE(self):
+        if not self.authenticated():
+            return
+        rule = self.rule_id()
+        if rule is None:
+            self.reply(404, {"error": "not found"})
+            return
+        status, result = opa("DELETE", f"/v1/data/enforcement/{urllib.parse.quote(rule, safe='')}", self.headers.get("Authorization"))
+        self.reply(200 if status == 204 else status, {"rule": rule} if status == 204 else result)
+
+    def do_POST(self):
+        if not self.authenticated():
+            return
+        if urllib.parse.urlparse(self.path).path != "/reload":
+            self.reply(404, {"error": "not found"})
+            return
+        self.reply(*reload_files(self.headers.get("Authorization")))
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    global OPA_URL, POLICY_DIR, RULES_FILES, API_TOKEN, recent
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=8282, help="port to serve the admin API on")
+    parser.add_argument("--bind", default="127.0.0.1", help="address to listen on")
+    parser.add_argument("--token-env", help="environment variable holding the bearer token accepted, when OPA does not authenticate")
+    parser.add_argument("--rules", action="append", default=[], help="operator rules file merged into data.config on /reload")
+    parser.add_argument("--opa", default=OPA_URL, help="OPA REST API base URL")
+    parser.add_argument("--policies", default=POLICY_DIR, help="directory /reload pushes to OPA")
+    parser.add_argument("--keep", type=int, default=1000, help="recent decisions to keep")
+    args = parser.parse_args()
+    OPA_URL = args.opa.rstrip("/")
+    POLICY_DIR = args.policies
+    RULES_FILES = args.rules
+    recent = deque(maxlen=args.keep)
+    if args.token_env:
+        API_TOKEN = os.environ.get(args.token_env)
+        if not API_TOKEN:
+            sys.exit(f"admin_api: {args.token_env} is not set")
+    elif not opa_authenticates():
+        sys.exit("admin_api: OPA answers unauthenticated calls; run it with --admin-rbac or pass --token-env")
+
+    server = ThreadingHTTPServer((args.bind, args.port), AdminHandler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    print(f"admin_api: serving on {args.bind}:{args.port}", file=sys.stderr)
+
+    for line in sys.stdin:
+        event = parse(line)
+        if event is not None and event.get("msg") == "Decision Log":
+            with lock:
+                recent.append(audit_record(event))
+
+
+if __name__ == "__main__":
+    main()
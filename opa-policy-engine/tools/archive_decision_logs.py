@@ -0,0 +1,117 @@
+#!/usr/bin/env python3
+"""
+Archive decision logs to S3 or GCS.
+
+Batches decision log lines, gzips each batch, optionally encrypts it client-side
+with `openssl enc` (AES-256-CBC, PBKDF2, key from a file), and uploads it with
+`aws s3 cp` or `gsutil cp` under a time-partitioned key:
+
+    <destination>/year=2026/month=01/day=31/hour=12/decisions-20260131T120000Z-<host>.jsonl.gz[.enc]
+
+Used standalone on a log stream, or as the "archive" destination of
+tools/log_router.py.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/archive_decision_logs.py --destination s3://audit/opa
+"""
+
+import argparse
+import gzip
+import json
+import logging
+import os
+import socket
+import subprocess
+import sys
+import tempfile
+import threading
+import time
+from datetime import datetime, timezone
+
+
+class Archiver:
+    """Collects lines and uploads them in compressed, time-partitioned batches."""
+
+    def __init__(self, destination, max_records=10000, max_seconds=300, encryption_key_file=None):
+        if not destination.startswith(("s3://", "gs://")):
+            raise ValueError(f"unsupported archive destination {destination!r}")
+        self.destination = destination.rstrip("/")
+        self.max_records = max_records
+        self.max_seconds = max_seconds
+        self.encryption_key_file = encryption_key_file
+        self.lines = []
+        self.started = time.monotonic()
+        self.lock = threading.Lock()
+
+    def add(self, line):
+        with self.lock:
+            self.lines.append(line)
+            if len(self.lines) >= self.max_records or time.monotonic() - self.started >= self.max_seconds:
+                self._flush()
+
+    def flush(self):
+        with self.lock:
+            self._flush()
+
+    def _flush(self):
+        lines, self.lines, self.started = self.lines, [], time.monotonic()
+        if not lines:
+            return
+        now = datetime.now(timezone.utc)
+        name = f"decisions-{now:%Y%m%dT%H%M%SZ}-{socket.gethostname()}.jsonl.gz"
+        with tempfile.TemporaryDirectory() as workdir:
+            path = os.path.join(workdir, name)
+            with gzip.open(path, "wt") as f:
+                f.write("\n".join(lines) + "\n")
+            if self.encryption_key_file:
+                subprocess.run(
+                    ["openssl", "enc", "-aes-256-cbc", "-pbkdf2", "-salt", "-in", path, "-out", path + ".enc",
+                     "-pass", f"file:{self.encryption_key_file}"],
+                    check=True,
+                )
+                path, name = path + ".enc", name + ".enc"
+            key = f"{self.destination}/year={now:%Y}/month={now:%m}/day={now:%d}/hour={now:%H}/{name}"
+            tool = ["aws", "s3", "cp"] if key.startswith("s3://") else ["gsutil", "cp"]
+            result = subprocess.run(tool + [path, key], capture_output=True, text=True)
+            if result.returncode != 0:
+                print(f"archive upload of {len(lines)} records to {key} failed: {result.stderr.strip()}", file=sys.stderr)
+
+
+class ArchiveHandler(logging.Handler):
+    """logging handler that archives each record's message."""
+
+    def __init__(self, archiver):
+        super().__init__()
+        self.archiver = archiver
+
+    def emit(self, record):
+        self.archiver.add(record.getMessage())
+
+    def close(self):
+        self.archiver.flush()
+        super().close()
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--destination", required=True, help="s3://bucket/prefix or gs://bucket/prefix")
+    parser.add_argument("--max-records", type=int, default=10000, help="records per batch")
+    parser.add_argument("--max-seconds", type=int, default=300, help="longest time a batch stays open")
+    parser.add_argument("--encryption-key-file", help="encrypt batches with the passphrase in this file")
+    args = parser.parse_args()
+
+    archiver = Archiver(args.destination, args.max_records, args.max_seconds, args.encryption_key_file)
+    try:
+        for line in sys.stdin:
+            try:
+                event = json.loads(line)
+            except ValueError:
+                continue
+            if isinstance(event, dict) and event.get("msg") == "Decision Log":
+                archiver.add(line.rstrip("\n"))
+    finally:
+        archiver.flush()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,97 @@
+#!/usr/bin/env python3
+"""
+Evaluates the CEL conditions of operator rules for the engine (policies/rules.rego).
+
+OPA has no CEL evaluator, so for a rule with a `cel` expression the policy POSTs
+{"expression", "activation"} to /evaluate, where the activation holds the
+request's attributes (request, claims, roles, scopes, context, source). The
+answer is {"result": true|false}, or {"error": "..."} when the expression fails
+on this request (a missing claim, a type mismatch), which the policy treats as a
+match so the rule denies. An expression that does not compile gets 400. Compiled
+programs are kept per expression. GET /health answers 200.
+
+Needs the cel-python package. Run it next to the engine, bound to localhost.
+
+Usage:
+    tools/cel_evaluator.py --port 9595
+"""
+
+import argparse
+import functools
+import json
+import sys
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+
+class CompileError(Exception):
+    pass
+
+
+@functools.lru_cache(maxsize=1024)
+def program(expression):
+    import celpy  # optional dependency: pip install cel-python
+
+    env = celpy.Environment()
+    try:
+        return env.program(env.compile(expression))
+    except celpy.CELParseError as e:
+        raise CompileError(str(e)) from e
+
+
+def evaluate(expression, activation):
+    import celpy
+    from celpy import celtypes
+
+    prgm = program(expression)
+    try:
+        result = prgm.evaluate({name: celpy.json_to_cel(value) for name, value in activation.items()})
+    except Exception as e:  # celpy.CELEvalError, and type errors raised on the way
+        return {"error": str(e)}
+    if isinstance(result, celpy.CELEvalError):
+        return {"error": str(result)}
+    if not isinstance(result, celtypes.BoolType):
+        return {"error": f"the expression evaluated to {type(result).__name__}, not bool"}
+    return {"result": bool(result)}
+
+
+class EvaluatorHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path != "/health":
+            self.send_error(404)
+            return
+        self.send_response(200)
+        self.send_header("Content-Length", "0")
+        self.end_headers()
+
+    def do_POST(self):
+        if self.path != "/evaluate":
+            self.send_error(404)
+            return
+        try:
+            request = json.loads(self.rfile.read(int(self.headers.get("content-length") or 0)))
+            result = evaluate(str(request["expression"]), dict(request.get("activation") or {}))
+        except (ValueError, KeyError, TypeError, CompileError) as e:
+            self.send_error(400, str(e))
+            return
+        payload = json.dumps(result).encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=9595, help="port to serve /evaluate on")
+    parser.add_argument("--bind", default="127.0.0.1", help="address to listen on")
+    args = parser.parse_args()
+    print(f"cel_evaluator: serving on {args.bind}:{args.port}", file=sys.stderr)
+    ThreadingHTTPServer((args.bind, args.port), EvaluatorHandler).serve_forever()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,169 @@
+#!/usr/bin/env python3
+"""
+Serve a REST+JSON facade of the engine's check and simulate APIs.
+
+The Envoy plugin answers gRPC ext_authz checks; scripts, simple webhooks and
+curl-based debugging want plain JSON instead. This facade takes a request in the
+engine's v1 request model (see policies/request.rego) or as an Envoy
+CheckRequest, evaluates it on the engine through the OPA REST API and answers
+with a flat JSON decision:
+
+    POST /v1/check
+    {"request": {"method": "POST", "path": "/general/mcp", "host": "...", "headers": {...}},
+     "source": {"address": "10.0.0.7"}, "context_extensions": {...}, "body": {...}}
+
+    {"allowed": false, "status": 403, "code": "missing_role", "rule": "rules.orders-writers",
+     "message": "...", "headers": {...}, "decision": <data.authz.result>}
+
+POST /v1/simulate takes the same request and evaluates it without side effects:
+no rate-limit tokens are taken and no DPoP replay records are written. A "config"
+member replaces the engine's effective configuration for the simulation, to try
+a change before rolling it out. The answer adds the deny reasons of every
+package, which of them were enforced, monitored, granted or failed open, and the
+evaluation steps (see policies/evaluation.rego). GET /health answers 200.
+
+/v1/check needs the decide capability (role gateway-adapter) and /v1/simulate
+the evaluate capability (role policy-editor) when the REST API requires tokens
+(run-opa.sh --admin-rbac): the Keycloak access token in $OPA_TOKEN is sent with
+every call to OPA. The facade itself does not authenticate its callers, so it is
+bound to localhost unless --bind says otherwise.
+
+Usage:
+    tools/check_api.py --port 9696 --opa http://localhost:8181
+    curl -s localhost:9696/v1/check -d '{"request": {"method": "GET", "path": "/general/mcp", "headers": {}}}'
+"""
+
+import argparse
+import json
+import os
+import sys
+import urllib.error
+import urllib.request
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+OPA_URL = "http://localhost:8181"
+
+# The mocks of policyctl repl: simulations take no rate-limit tokens and do not
+# record DPoP proofs as used.
+MOCKS = (
+    "with data.authz.ratelimit.configured as [] "
+    'with data.authz.dpop.replay as {"status_code": 200, "body": {"fresh": true}}'
+)
+
+SIMULATION = """result := data.authz.result {mocks}
+packages := {{name: sort([r.rule | some r in data.authz[name].deny]) | some name; data.authz[name].deny}} {mocks}
+enforced := sort([r.rule | some r in data.authz.enforced_deny]) {mocks}
+monitored := sort([r.rule | some r in data.authz.monitored_deny]) {mocks}
+granted := sort([r.rule | some r in data.authz.granted_deny]) {mocks}
+failed_open := sort([r.rule | some r in data.authz.failed_open]) {mocks}
+steps := data.authz.evaluation.steps {mocks}"""
+
+
+class BadRequest(Exception):
+    pass
+
+
+def opa(path, payload):
+    request = urllib.request.Request(
+        f"{OPA_URL}{path}",
+        data=json.dumps(payload).encode(),
+        headers={"Content-Type": "application/json"},
+    )
+    if os.environ.get("OPA_TOKEN"):
+        request.add_header("Authorization", f"Bearer {os.environ['OPA_TOKEN']}")
+    with urllib.request.urlopen(request, timeout=5) as response:
+        return json.load(response)
+
+
+def engine_input(request):
+    """The input the engine evaluates: a CheckRequest as is, else the v1 request model."""
+    if not isinstance(request, dict):
+        raise BadRequest("the request must be a JSON object")
+    if "attributes" in request:
+        return request
+    if not isinstance(request.get("request"), dict):
+        raise BadRequest('the request needs a "request" object (method, path, host, headers) or Envoy "attributes"')
+    members = {key: request[key] for key in ("request", "source", "context_extensions", "body") if key in request}
+    return {"version": "v1", **members}
+
+
+def summary(result):
+    metadata = result.get("dynamic_metadata", {})
+    try:
+        message = json.loads(result.get("body") or "{}").get("message")
+    except (ValueError, AttributeError):
+        message = result.get("body")
+    answer = {"allowed": bool(result.get("allowed")), "status": 200 if result.get("allowed") else result.get("http_status", 403)}
+    if not result.get("allowed"):
+        answer.update({"code": metadata.get("code"), "rule": metadata.get("rule"), "message": message})
+    answer.update({"headers": result.get("headers", {}), "decision": result})
+    return answer
+
+
+def check(request):
+    return summary(opa("/v1/data/authz/result", {"input": engine_input(request)}).get("result", {"allowed": False}))
+
+
+def simulate(request):
+    mocks = MOCKS
+    if "config" in request:
+        if not isinstance(request["config"], dict):
+            raise BadRequest('"config" must be a JSON object')
+        mocks += f" with data.authz.settings.config as {json.dumps(request['config'])}"
+    answer = opa("/v1/query", {"query": SIMULATION.format(mocks=mocks), "input": engine_input(request)})
+    bindings = (answer.get("result") or [{}])[0]
+    simulated = summary(bindings.get("result", {"allowed": False}))
+    simulated.update({key: bindings.get(key) for key in ("packages", "enforced", "monitored", "granted", "failed_open", "steps")})
+    return simulated
+
+
+class FacadeHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path != "/health":
+            self.send_error(404)
+            return
+        self.send_response(200)
+        self.send_header("Content-Length", "0")
+        self.end_headers()
+
+    def do_POST(self):
+        handlers = {"/v1/check": check, "/v1/simulate": simulate}
+        if self.path not in handlers:
+            self.send_error(404)
+            return
+        try:
+            request = json.loads(self.rfile.read(int(self.headers.get("content-length") or 0)) or b"null")
+            self.answer(200, handlers[self.path](request))
+        except (ValueError, BadRequest) as e:
+            self.answer(400, {"error": str(e)})
+        except urllib.error.HTTPError as e:
+            self.answer(502, {"error": f"OPA answered {e.code}"})
+        except OSError as e:
+            self.answer(502, {"error": f"OPA is not reachable at {OPA_URL}: {e}"})
+
+    def answer(self, status, body):
+        payload = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    global OPA_URL
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=9696, help="port to serve the facade on")
+    parser.add_argument("--bind", default="127.0.0.1", help="address to listen on")
+    parser.add_argument("--opa", default=os.environ.get("OPA_URL", OPA_URL), help="OPA REST API base URL")
+    args = parser.parse_args()
+    OPA_URL = args.opa.rstrip("/")
+    print(f"check_api: serving on {args.bind}:{args.port}", file=sys.stderr)
+    ThreadingHTTPServer((args.bind, args.port), FacadeHandler).serve_forever()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,106 @@
+#!/usr/bin/env python3
+"""
+Report which routes and operator rules live traffic exercises.
+
+Reads OPA decision log lines from files or stdin and counts, per route and per
+operator rule, the requests that matched it (the coverage authz.decision records
+in each decision's dynamic metadata). Compared with the inventory of configured
+routes and rules (data.authz.coverage.inventory, fetched from OPA), it lists:
+
+- rules never hit: candidates for pruning;
+- routes never hit;
+- routes with only default coverage: every request to them was allowed without
+  any operator rule applying or any denial, so nothing but the route's default
+  decision protects them;
+- the number of requests that matched no route (tools/discovery_report.py
+  lists them).
+
+Usage:
+    docker logs opa-policy-engine 2>&1 | tools/coverage_report.py --opa http://localhost:8181
+"""
+
+import argparse
+import fileinput
+import json
+import os
+import urllib.request
+from collections import Counter
+
+
+def fetch_inventory(opa_url):
+    request = urllib.request.Request(f"{opa_url}/v1/data/authz/coverage/inventory")
+    if os.environ.get("OPA_TOKEN"):
+        request.add_header("Authorization", f"Bearer {os.environ['OPA_TOKEN']}")
+    with urllib.request.urlopen(request, timeout=5) as response:
+        return json.load(response)["result"]
+
+
+def decisions(lines):
+    for line in lines:
+        try:
+            event = json.loads(line)
+        except ValueError:
+            continue
+        if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+            continue
+        result = event.get("result")
+        if isinstance(result, dict) and "coverage" in result.get("dynamic_metadata", {}):
+            yield result, event.get("timestamp", "")
+
+
+def coverage(lines):
+    route_hits, rule_hits, guarded = Counter(), Counter(), set()
+    last_seen = {}
+    unmatched = 0
+    for result, timestamp in decisions(lines):
+        metadata = result["dynamic_metadata"]
+        matched = metadata["coverage"]
+        if not matched["routes"]:
+            unmatched += 1
+        for name in matched["routes"] + matched["rules"]:
+            last_seen[name] = max(last_seen.get(name, ""), timestamp)
+        route_hits.update(matched["routes"])
+        rule_hits.update(matched["rules"])
+        if matched["rules"] or not result.get("allowed") or metadata.get("monitored"):
+            guarded.update(matched["routes"])
+    return route_hits, rule_hits, guarded, last_seen, unmatched
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--opa", default=os.environ.get("OPA_URL", "http://localhost:8181"), help="OPA REST API base URL")
+    parser.add_argument("--inventory", help="read the inventory from this JSON file instead of OPA")
+    parser.add_argument("--json", action="store_true", help="print the report as JSON")
+    args = parser.parse_args()
+
+    if args.inventory:
+        with open(args.inventory) as f:
+            inventory = json.load(f)
+    else:
+        inventory = fetch_inventory(args.opa.rstrip("/"))
+    route_hits, rule_hits, guarded, last_seen, unmatched = coverage(fileinput.input(args.files))
+
+    report = {
+        "routes": [{"name": name, "count": route_hits[name], "last_seen": last_seen.get(name)} for name in inventory["routes"]],
+        "rules": [{"name": name, "count": rule_hits[name], "last_seen": last_seen.get(name)} for name in inventory["rules"]],
+        "never_hit_rules": [name for name in inventory["rules"] if not rule_hits[name]],
+        "never_hit_routes": [name for name in inventory["routes"] if not route_hits[name]],
+        "default_only_routes": [name for name in inventory["routes"] if route_hits[name] and name not in guarded],
+        "unmatched_requests": unmatched,
+    }
+    if args.json:
+        print(json.dumps(report, indent=2))
+        return
+    print(f"{'COUNT':>7}  {'LAST SEEN':<30} ROUTE / RULE")
+    for entry in report["routes"] + report["rules"]:
+        print(f"{entry['count']:>7}  {entry['last_seen'] or '-':<30} {entry['name']}")
+    print()
+    print("Rules never hit:        " + (", ".join(report["never_hit_rules"]) or "none"))
+    print("Routes never hit:       " + (", ".join(report["never_hit_routes"]) or "none"))
+    print("Default-only routes:    " + (", ".join(report["default_only_routes"]) or "none"))
+    print(f"Unmatched requests:     {unmatched} (see tools/discovery_report.py)")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,171 @@
+#!/usr/bin/env python3
+"""
+Export decision counts to Prometheus from the OPA decision log.
+
+Reads decision log lines from files or stdin and serves
+authz_decisions_total{decision, status, class, code, issuer, tenant, route},
+using the error class and the bounded labels authz.labels records in each
+decision's dynamic metadata. Alongside it:
+
+- authz_denials_by_rule_total{rule}: the rule that decided each denial;
+- authz_dependency_errors_total{rule}: failed calls to the JWKS, webhooks and
+  token exchange, including those a fail-open profile let through;
+- authz_monitored_denials_total{rule}: denials recorded but not enforced (dry
+  runs and gradual rollouts);
+- authz_decision_duration_seconds: histogram of the server handler time;
+- authz_degradation_tier{tier}: 1 for the degradation tier of the latest decision
+  (see policies/degradation.rego), 0 for the others;
+- authz_degraded_decisions_total{tier}: decisions taken below the full tier.
+
+Soft denies (e.g. expired tokens the client should refresh) are counted with
+decision="soft_deny", and decisions that failed to evaluate with decision="error",
+class="evaluation" and status 500.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/decision_metrics.py --port 9465
+"""
+
+import argparse
+import fileinput
+import json
+import threading
+from collections import Counter
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+LABELS = ("decision", "status", "class", "code", "issuer", "tenant", "route")
+TIERS = ("full", "cached_only", "local_rules_only", "deny_all")
+BUCKETS = (0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0)
+
+decisions = Counter()
+denials_by_rule = Counter()
+dependency_errors = Counter()
+monitored_denials = Counter()
+duration_buckets = Counter()
+duration = {"sum": 0.0, "count": 0}
+degraded_decisions = Counter()
+tier = {"current": "full"}
+lock = threading.Lock()
+
+
+class MetricsHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        lines = [
+            "# HELP authz_decisions_total Gateway authorization decisions.",
+            "# TYPE authz_decisions_total counter",
+        ]
+        with lock:
+            for key, count in sorted(decisions.items()):
+                labels = ",".join(f'{name}="{value}"' for name, value in zip(LABELS, key))
+                lines.append(f"authz_decisions_total{{{labels}}} {count}")
+            lines += [
+                "# HELP authz_denials_by_rule_total Gateway denials by the rule that decided them.",
+                "# TYPE authz_denials_by_rule_total counter",
+            ]
+            lines += [f'authz_denials_by_rule_total{{rule="{rule}"}} {n}' for rule, n in sorted(denials_by_rule.items())]
+            lines += [
+                "# HELP authz_dependency_errors_total Failed calls to services a decision depended on.",
+                "# TYPE authz_dependency_errors_total counter",
+            ]
+            lines += [f'authz_dependency_errors_total{{rule="{rule}"}} {n}' for rule, n in sorted(dependency_errors.items())]
+            lines += [
+                "# HELP authz_monitored_denials_total Denials recorded without being enforced.",
+                "# TYPE authz_monitored_denials_total counter",
+            ]
+            lines += [f'authz_monitored_denials_total{{rule="{rule}"}} {n}' for rule, n in sorted(monitored_denials.items())]
+            lines += [
+                "# HELP authz_decision_duration_seconds Time OPA spent handling a decision.",
+                "# TYPE authz_decision_duration_seconds histogram",
+            ]
+            lines += [f'authz_decision_duration_seconds_bucket{{le="{le}"}} {duration_buckets[le]}' for le in BUCKETS]
+            lines.append(f'authz_decision_duration_seconds_bucket{{le="+Inf"}} {duration["count"]}')
+            lines.append(f'authz_decision_duration_seconds_sum {duration["sum"]}')
+            lines.append(f'authz_decision_duration_seconds_count {duration["count"]}')
+            lines += [
+                "# HELP authz_degradation_tier Degradation tier of the latest decision.",
+                "# TYPE authz_degradation_tier gauge",
+            ]
+            lines += [f'authz_degradation_tier{{tier="{t}"}} {int(t == tier["current"])}' for t in TIERS]
+            lines += [
+                "# HELP authz_degraded_decisions_total Decisions taken below the full tier.",
+                "# TYPE authz_degraded_decisions_total counter",
+            ]
+            lines += [f'authz_degraded_decisions_total{{tier="{t}"}} {n}' for t, n in sorted(degraded_decisions.items())]
+        body = ("\n".join(lines) + "\n").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain; version=0.0.4")
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def decision_key(result):
+    metadata = result.get("dynamic_metadata", {})
+    labels = metadata.get("labels", {})
+    code = metadata.get("code", "")
+    if not result.get("allowed") and not code:
+        try:
+            code = json.loads(result.get("body", "{}")).get("error", "")
+        except ValueError:
+            pass
+    decision = "allow" if result.get("allowed") else "soft_deny" if metadata.get("soft_deny") else "deny"
+    status = str(result.get("http_status", 200 if result.get("allowed") else 403))
+    return (decision, status, metadata.get("error_class", ""), code, labels.get("issuer", "none"), labels.get("tenant", "none"), labels.get("route", "unmatched"))
+
+
+def record(event, result):
+    """Updates the per-rule, dependency and latency series; called with lock held."""
+    metadata = result.get("dynamic_metadata", {})
+    if not result.get("allowed") and metadata.get("rule"):
+        denials_by_rule[metadata["rule"]] += 1
+        if metadata.get("error_class") == "dependency":
+            dependency_errors[metadata["rule"]] += 1
+    for reason in metadata.get("failed_open", []):
+        dependency_errors[reason["rule"]] += 1
+    for reason in metadata.get("monitored", []):
+        monitored_denials[reason["rule"]] += 1
+    if metadata.get("tier"):
+        tier["current"] = metadata["tier"]
+        if metadata["tier"] != "full":
+            degraded_decisions[metadata["tier"]] += 1
+    seconds = event.get("metrics", {}).get("timer_server_handler_ns", 0) / 1e9
+    if seconds:
+        duration["sum"] += seconds
+        duration["count"] += 1
+        for le in BUCKETS:
+            if seconds <= le:
+                duration_buckets[le] += 1
+
+
+ERROR_KEY = ("error", "500", "evaluation", "evaluation_error", "none", "none", "unmatched")
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--port", type=int, default=9465, help="port to serve /metrics on")
+    args = parser.parse_args()
+
+    server = HTTPServer(("", args.port), MetricsHandler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+
+    for line in fileinput.input(args.files):
+        try:
+            event = json.loads(line)
+        except ValueError:
+            continue
+        if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+            continue
+        result = event.get("result")
+        if event.get("error"):
+            with lock:
+                decisions[ERROR_KEY] += 1
+        elif isinstance(result, dict) and "dynamic_metadata" in result:
+            with lock:
+                decisions[decision_key(result)] += 1
+                record(event, result)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,137 @@
+#!/usr/bin/env python3
+"""
+Alert on sudden shifts in the allow/deny mix per route.
+
+Reads decision log lines from files or stdin and keeps, per route (the label
+authz.labels records), the deny ratio over a short window and over a longer
+baseline window. When the short-window ratio exceeds the baseline by --factor
+(with at least --min-decisions in the short window), it reports an alert: a JSON
+line on stderr and, with --webhook, a POST of the same JSON. Alerts for a route
+are repeated at most once per short window. This catches a bad policy push or an
+identity provider outage within minutes.
+
+With --metrics-port it serves authz_deny_ratio{route, window} and
+authz_decision_mix_alerts_total{route}.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/decision_mix_monitor.py --webhook https://hooks.example.com/authz --metrics-port 9467
+"""
+
+import argparse
+import fileinput
+import json
+import sys
+import threading
+import time
+import urllib.request
+from collections import Counter, defaultdict, deque
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+windows = defaultdict(deque)  # route -> deque of (timestamp, denied)
+ratios = {}
+alerts = Counter()
+last_alert = {}
+lock = threading.Lock()
+
+
+class MetricsHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        lines = [
+            "# HELP authz_deny_ratio Share of denied decisions per route and window.",
+            "# TYPE authz_deny_ratio gauge",
+        ]
+        with lock:
+            lines += [
+                f'authz_deny_ratio{{route="{route}",window="{window}"}} {ratio:.4f}'
+                for (route, window), ratio in sorted(ratios.items())
+            ]
+            lines += [
+                "# HELP authz_decision_mix_alerts_total Alerts on sudden shifts of the deny ratio.",
+                "# TYPE authz_decision_mix_alerts_total counter",
+            ]
+            lines += [f'authz_decision_mix_alerts_total{{route="{route}"}} {n}' for route, n in sorted(alerts.items())]
+        body = ("\n".join(lines) + "\n").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain; version=0.0.4")
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def deny_ratio(entries):
+    return sum(denied for _, denied in entries) / len(entries) if entries else 0.0
+
+
+def notify(webhook, alert):
+    print(json.dumps(alert), file=sys.stderr, flush=True)
+    if not webhook:
+        return
+    request = urllib.request.Request(webhook, data=json.dumps(alert).encode(), headers={"Content-Type": "application/json"})
+    try:
+        urllib.request.urlopen(request, timeout=5).close()
+    except Exception as e:
+        print(f"decision_mix_monitor: webhook failed: {e}", file=sys.stderr)
+
+
+def observe(args, route, denied, now):
+    with lock:
+        entries = windows[route]
+        entries.append((now, denied))
+        while entries and entries[0][0] < now - args.baseline:
+            entries.popleft()
+        recent = [e for e in entries if e[0] >= now - args.window]
+        short, baseline = deny_ratio(recent), deny_ratio(entries)
+        ratios[(route, "short")] = short
+        ratios[(route, "baseline")] = baseline
+        # A zero baseline still alerts once denials reach --floor.
+        shifted = short >= max(baseline * args.factor, args.floor)
+        if len(recent) < args.min_decisions or not shifted or now - last_alert.get(route, 0) < args.window:
+            return
+        last_alert[route] = now
+        alerts[route] += 1
+        alert = {
+            "level": "warn",
+            "msg": "decision mix shifted",
+            "route": route,
+            "deny_ratio": round(short, 4),
+            "baseline_deny_ratio": round(baseline, 4),
+            "decisions": len(recent),
+            "window_seconds": args.window,
+        }
+    notify(args.webhook, alert)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--window", type=int, default=300, help="short window in seconds")
+    parser.add_argument("--baseline", type=int, default=3600, help="baseline window in seconds")
+    parser.add_argument("--factor", type=float, default=10.0, help="alert when the short deny ratio is this many times the baseline")
+    parser.add_argument("--floor", type=float, default=0.05, help="smallest deny ratio worth alerting on")
+    parser.add_argument("--min-decisions", type=int, default=20, help="decisions needed in the short window")
+    parser.add_argument("--webhook", help="URL to POST alerts to")
+    parser.add_argument("--metrics-port", type=int, help="serve Prometheus metrics on this port")
+    args = parser.parse_args()
+
+    if args.metrics_port:
+        server = HTTPServer(("", args.metrics_port), MetricsHandler)
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+
+    for line in fileinput.input(args.files):
+        try:
+            event = json.loads(line)
+        except ValueError:
+            continue
+        if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+            continue
+        result = event.get("result")
+        if not isinstance(result, dict) or "dynamic_metadata" not in result:
+            continue
+        route = result["dynamic_metadata"].get("labels", {}).get("route", "unmatched")
+        observe(args, route, 0 if result.get("allowed") else 1, time.time())
+
+
+if __name__ == "__main__":
+    main()
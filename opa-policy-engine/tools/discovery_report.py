@@ -0,0 +1,64 @@
+#!/usr/bin/env python3
+"""
+Summarize requests that matched no policy route.
+
+Reads OPA decision log lines (JSON, one per line, as written by the console
+decision logger) from files or stdin and lists the unmatched method/host/path
+combinations recorded by authz.routes, most frequent first.
+
+Usage:
+    docker logs opa-policy-engine 2>&1 | tools/discovery_report.py
+"""
+
+import argparse
+import fileinput
+import json
+from collections import Counter
+
+
+def unmatched_requests(lines):
+    for line in lines:
+        try:
+            event = json.loads(line)
+        except ValueError:
+            continue
+        if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+            continue
+        result = event.get("result")
+        if not isinstance(result, dict):
+            continue
+        discovery = result.get("dynamic_metadata", {}).get("discovery")
+        if discovery:
+            yield discovery, event.get("timestamp", "")
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--json", action="store_true", help="print the report as JSON")
+    args = parser.parse_args()
+
+    counts = Counter()
+    last_seen = {}
+    for discovery, timestamp in unmatched_requests(fileinput.input(args.files)):
+        key = (discovery["method"], discovery["host"], discovery["path"])
+        counts[key] += 1
+        last_seen[key] = max(last_seen.get(key, ""), timestamp)
+
+    report = [
+        {"method": method, "host": host, "path": path, "count": count, "last_seen": last_seen[(method, host, path)]}
+        for (method, host, path), count in counts.most_common()
+    ]
+    if args.json:
+        print(json.dumps(report, indent=2))
+        return
+    if not report:
+        print("No unmatched requests found.")
+        return
+    print(f"{'COUNT':>7}  {'METHOD':<7} {'HOST':<32} PATH")
+    for entry in report:
+        print(f"{entry['count']:>7}  {entry['method']:<7} {entry['host']:<32} {entry['path']}")
+
+
+if __name__ == "__main__":
+    main()
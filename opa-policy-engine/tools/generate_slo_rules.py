@@ -0,0 +1,137 @@
+#!/usr/bin/env python3
+"""
+Generate Prometheus SLO recording and burn-rate alerting rules.
+
+Objectives come from config/slo.json; one availability SLO is generated for the
+engine as a whole (route="all") and one per route in policies/data.yaml, plus a latency SLO
+and an error-budget SLO per listed dependency. Alerts follow the multiwindow,
+multi-burn-rate pattern: page on 14.4x (1h/5m) and 6x (6h/30m) burns, ticket on
+3x (1d/2h) and 1x (3d/6h) burns.
+
+The SLIs use the metrics of tools/decision_metrics.py (availability: decisions
+that did not end in a 5xx) and tools/latency_budget.py (latency: decisions within
+the total budget).
+
+Usage:
+    tools/generate_slo_rules.py > slo-rules.yaml
+    tools/generate_slo_rules.py --prometheus-rule > slo-prometheusrule.yaml
+"""
+
+import argparse
+import json
+import os
+import sys
+
+HERE = os.path.dirname(__file__)
+DEFAULT_SLO = os.path.join(HERE, "..", "config", "slo.json")
+DEFAULT_DATA = os.path.join(HERE, "..", "policies", "data.yaml")
+
+WINDOWS = ["5m", "30m", "1h", "2h", "6h", "1d", "3d"]
+
+# (severity, burn rate, long window, short window)
+BURN_ALERTS = [
+    ("page", 14.4, "1h", "5m"),
+    ("page", 6, "6h", "30m"),
+    ("ticket", 3, "1d", "2h"),
+    ("ticket", 1, "3d", "6h"),
+]
+
+
+def load_routes(path):
+    try:
+        import yaml
+    except ImportError:
+        sys.exit("PyYAML is required to read the route config: pip install pyyaml")
+    with open(path) as f:
+        return [route["name"] for route in yaml.safe_load(f)["config"].get("routes", [])]
+
+
+def availability_sli(route=None):
+    selector = f'route="{route}"' if route else ""
+    errors = ",".join(filter(None, [selector, 'status=~"5.."']))
+    return {
+        "errors": f"sum(rate(authz_decisions_total{{{errors}}}[$window]))",
+        "total": f"sum(rate(authz_decisions_total{{{selector}}}[$window]))" if selector else "sum(rate(authz_decisions_total[$window]))",
+    }
+
+
+def slos(config, routes):
+    objectives = config["objectives"]
+    # The engine-wide series is labelled route="all" so alerts can tell it apart.
+    yield "availability", {"route": "all"}, objectives["availability"], availability_sli()
+    for route in routes:
+        yield "availability", {"route": route}, objectives["availability"], availability_sli(route)
+    yield "latency", {}, objectives["latency"], {
+        "errors": 'sum(rate(authz_latency_budget_exceeded_total{phase="total"}[$window]))',
+        "total": "sum(rate(authz_decisions_checked_total[$window]))",
+    }
+    for dependency in config.get("dependencies", []):
+        yield "dependency", {"dependency": dependency["name"]}, dependency["objective"], dependency
+
+
+def recording_rules(kind, labels, sli):
+    for window in WINDOWS:
+        errors = sli["errors"].replace("$window", window)
+        total = sli["total"].replace("$window", window)
+        rule = {"record": f"authz:slo_{kind}_errors:ratio_rate{window}", "expr": f"{errors} / {total}"}
+        if labels:
+            rule["labels"] = dict(labels)
+        yield rule
+
+
+def alerting_rules(kind, labels, objective):
+    budget = round(1 - objective / 100, 6)
+    selector = ",".join(f'{name}="{value}"' for name, value in labels.items())
+    selector = f"{{{selector}}}" if selector else ""
+    scope = " ".join(labels.values()) or "policy engine"
+    for severity, rate, long_window, short_window in BURN_ALERTS:
+        threshold = round(rate * budget, 6)
+        yield {
+            "alert": f"AuthzSLO{kind.capitalize()}BurnRate",
+            "expr": (
+                f"authz:slo_{kind}_errors:ratio_rate{long_window}{selector} > {threshold}"
+                f" and authz:slo_{kind}_errors:ratio_rate{short_window}{selector} > {threshold}"
+            ),
+            "labels": {**labels, "severity": severity, "burn_rate": str(rate)},
+            "annotations": {
+                "summary": f"{scope}: {kind} error budget burning at {rate}x",
+                "description": f"The {kind} SLO of {objective}% is burning its error budget {rate}x faster than sustainable over {long_window}.",
+            },
+        }
+
+
+def generate(config, routes):
+    groups = []
+    for kind, labels, objective, sli in slos(config, routes):
+        name = "-".join(["authz-slo", kind] + list(labels.values()))
+        groups.append({
+            "name": name,
+            "rules": list(recording_rules(kind, labels, sli)) + list(alerting_rules(kind, labels, objective)),
+        })
+    return {"groups": groups}
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--slo", default=DEFAULT_SLO, help="SLO objectives")
+    parser.add_argument("--data", default=DEFAULT_DATA, help="policy config with the routes")
+    parser.add_argument("--prometheus-rule", action="store_true", help="wrap the rules in a PrometheusRule resource")
+    args = parser.parse_args()
+
+    with open(args.slo) as f:
+        config = json.load(f)
+    rules = generate(config, load_routes(args.data))
+    if args.prometheus_rule:
+        rules = {
+            "apiVersion": "monitoring.coreos.com/v1",
+            "kind": "PrometheusRule",
+            "metadata": {"name": "opa-policy-engine-slo"},
+            "spec": rules,
+        }
+
+    import yaml
+    yaml.safe_dump(rules, sys.stdout, sort_keys=False, width=200)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,466 @@
+#!/usr/bin/env python3
+"""
+Serve Envoy's HTTP ext_authz contract on top of the OPA REST API.
+
+The Envoy plugin only speaks gRPC. This adapter accepts the original request as
+an HTTP authorization server receives it (method, path and headers, plus the body
+when the proxy forwards it), builds the same CheckRequest input the plugin would,
+evaluates data.authz.result and answers 200 to allow (with the decision's
+upstream headers, and the headers to strip from the upstream request in
+x-envoy-auth-headers-to-remove) or the decision's status, headers and JSON body to deny. It
+works with Istio's envoyExtAuthzHttp provider and with non-Envoy gateways that
+support a forward-auth endpoint.
+
+Allows carrying dynamic_metadata.cache_ttl_seconds are cached for that long,
+keyed by the whole input the policy sees (method, host, path, every header, the
+source address and the parsed body) except the per-request --cache-ignore-headers
+such as trace ids, so bursts of identical agent calls skip OPA and Keycloak. Denials are never cached. With --cache-redis the cache is shared
+by every adapter replica (needs the redis package); Redis's own eviction policy
+then bounds it instead of --cache-entries.
+
+With --max-inflight, at most that many requests are evaluated by OPA at once and
+the rest wait, highest priority first, instead of queueing equally behind a busy
+engine. Priorities come from the load_shedding classes in the engine's config
+(see policies/shedding.rego), refreshed every --classes-refresh seconds. A request
+is shed, answered 503 at once, when its class already has max_queue requests
+waiting or it waited max_wait_ms. With --metrics-port it serves
+authz_admitted_total{class}, authz_shed_total{class, priority},
+authz_queue_depth{class} and authz_inflight.
+
+The request's source address is the socket peer. Behind proxies listed in
+--trusted-proxies it is the rightmost X-Forwarded-For hop not added by one of
+them; the leftmost hops are whatever the client sent and are never believed.
+
+With --server-timing, allows carry a Server-Timing header attributing the time
+spent in authorization: the round trip to OPA ("authz") and OPA's own timers for
+the phases of config/latency-budgets.json ("authz-<phase>"), or "authz-cache" for
+cached decisions. The header is returned with the decision's upstream and response
+headers, so the proxy can pass it on to the client.
+
+When the REST API requires tokens (run-opa.sh --admin-rbac), the Keycloak access
+token in $OPA_TOKEN is sent with every call to OPA. Its roles need the decide
+capability (role gateway-adapter) to read data.authz.result.
+
+Usage:
+    tools/http_authz_adapter.py --port 9292 --opa http://localhost:8181 \
+        --cache-entries 10000 \
+        --max-inflight 64 --metrics-port 9468 --server-timing
+"""
+
+import argparse
+import hashlib
+import heapq
+import ipaddress
+import itertools
+import json
+import os
+import re
+import sys
+import threading
+import time
+import urllib.request
+from collections import Counter, OrderedDict
+from http.server import BaseHTTPRequestHandler, HTTPServer, ThreadingHTTPServer
+
+OPA_URL = "http://localhost:8181"
+DEFAULT_BUDGETS = os.path.join(os.path.dirname(__file__), "..", "config", "latency-budgets.json")
+TIMING_PHASES = {}
+# Headers unique to each request that no rule reads; everything else keys the cache.
+TRUSTED_PROXIES = []
+DEFAULT_IGNORED_HEADERS = "x-request-id,traceparent,tracestate,b3,x-b3-*,x-cloud-trace-context,x-envoy-*"
+
+
+class DecisionCache:
+    """Least-recently-used allows with per-entry expiry."""
+
+    def __init__(self, max_entries, ignored_headers):
+        self.max_entries = max_entries
+        self.ignored = [glob_regex(pattern) for pattern in ignored_headers]
+        self.entries = OrderedDict()
+        self.lock = threading.Lock()
+
+    def key(self, check):
+        """Hash of the check input, so a cached allow is only reused for a request the rules see as identical."""
+        http = check["attributes"]["request"]["http"]
+        headers = {name: value for name, value in http["headers"].items()
+                   if not any(pattern.match(name) for pattern in self.ignored)}
+        keyed = dict(check, attributes=dict(check["attributes"], request={"http": dict(http, id="", headers=headers)}))
+        return hashlib.sha256(json.dumps(keyed, sort_keys=True).encode()).hexdigest()
+
+    def get(self, key):
+        with self.lock:
+            entry = self.entries.get(key)
+            if entry is None:
+                return None
+            expires, result = entry
+            if expires <= time.monotonic():
+                del self.entries[key]
+                return None
+            self.entries.move_to_end(key)
+            return result
+
+    def put(self, key, result):
+        ttl = result.get("dynamic_metadata", {}).get("cache_ttl_seconds", 0)
+        if self.max_entries <= 0 or not result.get("allowed") or ttl <= 0:
+            return
+        with self.lock:
+            self.entries[key] = (time.monotonic() + ttl, result)
+            self.entries.move_to_end(key)
+            while len(self.entries) > self.max_entries:
+                self.entries.popitem(last=False)
+
+
+class RedisDecisionCache(DecisionCache):
+    """Allows kept in Redis, expiring with their TTL."""
+
+    def __init__(self, url, ignored_headers):
+        import redis  # optional dependency: pip install redis
+
+        super().__init__(1, ignored_headers)
+        self.redis = redis.Redis.from_url(url)
+
+    def get(self, key):
+        cached = self.redis.get(f"authz:decision:{key}")
+        return json.loads(cached) if cached else None
+
+    def put(self, key, result):
+        ttl = result.get("dynamic_metadata", {}).get("cache_ttl_seconds", 0)
+        if result.get("allowed") and ttl > 0:
+            self.redis.set(f"authz:decision:{key}", json.dumps(result), ex=int(ttl))
+
+
+CACHE = DecisionCache(0, [])
+
+
+def glob_regex(pattern):
+    """OPA's glob.match with "/" as delimiter: * and ? stay within a segment, ** does not."""
+    out, depth, i = "", 0, 0
+    while i < len(pattern):
+        c = pattern[i]
+        if pattern.startswith("**", i):
+            out += ".*"
+            i += 2
+            continue
+        if c == "*":
+            out += "[^/]*"
+        elif c == "?":
+            out += "[^/]"
+        elif c == "{":
+            out += "(?:"
+            depth += 1
+        elif c == "}" and depth:
+            out += ")"
+            depth -= 1
+        elif c == "," and depth:
+            out += "|"
+        else:
+            out += re.escape(c)
+        i += 1
+    return re.compile(out + r"\Z")
+
+
+class Classes:
+    """The engine's load_shedding classes, matched the way policies/shedding.rego does."""
+
+    def __init__(self):
+        self.classes = []
+        self.default = {"name": "default", "priority": 0}
+
+    def refresh(self):
+        request = urllib.request.Request(f"{OPA_URL}/v1/data/authz/settings/config/load_shedding")
+        if os.environ.get("OPA_TOKEN"):
+            request.add_header("Authorization", f"Bearer {os.environ['OPA_TOKEN']}")
+        with urllib.request.urlopen(request, timeout=2) as response:
+            settings = json.load(response).get("result", {})
+        classes = [dict(c, path_patterns=[glob_regex(p) for p in c.get("paths", [])]) for c in settings.get("classes", [])]
+        self.classes = classes
+        self.default = {"name": "default", "priority": 0, **settings.get("default", {})}
+
+    def refresh_forever(self, interval):
+        while True:
+            try:
+                self.refresh()
+            except Exception as e:
+                print(f"http_authz_adapter: could not load the load_shedding classes: {e}", file=sys.stderr)
+            time.sleep(interval)
+
+    def classify(self, handler):
+        path = handler.path.split("?")[0]
+        headers = {name.lower(): value for name, value in handler.headers.items()}
+        for c in self.classes:
+            if c["path_patterns"] and not any(p.match(path) for p in c["path_patterns"]):
+                continue
+            if c.get("methods") and handler.command not in c["methods"]:
+                continue
+            if any(headers.get(name.lower()) not in values for name, values in c.get("headers", {}).items()):
+                continue
+            return c
+        return self.default
+
+
+CLASSES = Classes()
+
+
+class Admission:
+    """At most max_inflight evaluations at once; waiting requests are admitted highest
+    priority first and shed when their class's queue is full or they waited too long."""
+
+    def __init__(self, max_inflight):
+        self.max_inflight = max_inflight
+        self.inflight = 0
+        self.waiting = []  # heap of [-priority, sequence, event, class name]
+        self.sequence = itertools.count()
+        self.queued = Counter()
+        self.admitted = Counter()
+        self.shed = Counter()  # (class name, priority) -> requests
+        self.lock = threading.Lock()
+
+    def acquire(self, cls):
+        if self.max_inflight <= 0:
+            return True
+        name, priority = cls["name"], cls.get("priority", 0)
+        with self.lock:
+            if self.inflight < self.max_inflight and not self.waiting:
+                self.inflight += 1
+                self.admitted[name] += 1
+                return True
+            if self.queued[name] >= cls.get("max_queue", 0):
+                self.shed[(name, priority)] += 1
+                return False
+            entry = [-priority, next(self.sequence), threading.Event(), name]
+            heapq.heappush(self.waiting, entry)
+            self.queued[name] += 1
+        entry[2].wait(cls.get("max_wait_ms", 1000) / 1000)
+        with self.lock:
+            if entry[2].is_set():
+                self.admitted[name] += 1
+                return True
+            self.waiting.remove(entry)
+            heapq.heapify(self.waiting)
+            self.queued[name] -= 1
+            self.shed[(name, priority)] += 1
+            return False
+
+    def release(self):
+        if self.max_inflight <= 0:
+            return
+        with self.lock:
+            if self.waiting:
+                # The slot passes to the highest-priority waiter.
+                entry = heapq.heappop(self.waiting)
+                self.queued[entry[3]] -= 1
+                entry[2].set()
+            else:
+                self.inflight -= 1
+
+
+ADMISSION = Admission(0)
+
+SHED_BODY = json.dumps({
+    "error": "load_shed",
+    "class": "dependency",
+    "message": "the authorization service is overloaded; retry shortly",
+}).encode()
+
+
+class MetricsHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        with ADMISSION.lock:
+            lines = [
+                "# HELP authz_admitted_total Requests admitted for evaluation, by priority class.",
+                "# TYPE authz_admitted_total counter",
+            ]
+            lines += [f'authz_admitted_total{{class="{name}"}} {n}' for name, n in sorted(ADMISSION.admitted.items())]
+            lines += [
+                "# HELP authz_shed_total Requests shed under overload, by priority class.",
+                "# TYPE authz_shed_total counter",
+            ]
+            lines += [
+                f'authz_shed_total{{class="{name}",priority="{priority}"}} {n}'
+                for (name, priority), n in sorted(ADMISSION.shed.items())
+            ]
+            lines += [
+                "# HELP authz_queue_depth Requests waiting for evaluation, by priority class.",
+                "# TYPE authz_queue_depth gauge",
+            ]
+            lines += [f'authz_queue_depth{{class="{name}"}} {n}' for name, n in sorted(ADMISSION.queued.items())]
+            lines += [
+                "# HELP authz_inflight Requests being evaluated.",
+                "# TYPE authz_inflight gauge",
+                f"authz_inflight {ADMISSION.inflight}",
+            ]
+        body = ("\n".join(lines) + "\n").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain; version=0.0.4")
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def trusted(address):
+    try:
+        ip = ipaddress.ip_address(address)
+    except ValueError:
+        return False
+    return any(ip in network for network in TRUSTED_PROXIES)
+
+
+def source_address(handler, headers):
+    """The socket peer or, behind --trusted-proxies, the rightmost X-Forwarded-For hop they did not add."""
+    address = handler.client_address[0]
+    hops = [hop.strip() for hop in headers.get("x-forwarded-for", "").split(",") if hop.strip()]
+    while trusted(address) and hops:
+        address = hops.pop()
+    return address
+
+
+def check_input(handler, body):
+    headers = {name.lower(): value for name, value in handler.headers.items()}
+    http = {
+        "id": headers.get("x-request-id", ""),
+        "method": handler.command,
+        "path": handler.path,
+        "host": headers.get("host", ""),
+        "headers": headers,
+    }
+    source = source_address(handler, headers)
+    check = {"attributes": {
+        "request": {"http": http},
+        "source": {"address": {"socketAddress": {"address": source}}},
+    }}
+    if body and headers.get("content-type", "").startswith("application/json"):
+        try:
+            check["parsed_body"] = json.loads(body)
+        except ValueError:
+            pass
+    return check
+
+
+def decide(check):
+    """Evaluates the decision; returns it with OPA's timers when --server-timing asks for them."""
+    request = urllib.request.Request(
+        f"{OPA_URL}/v1/data/authz/result{'?metrics=true' if TIMING_PHASES else ''}",
+        data=json.dumps({"input": check}).encode(),
+        headers={"Content-Type": "application/json"},
+    )
+    if os.environ.get("OPA_TOKEN"):
+        request.add_header("Authorization", f"Bearer {os.environ['OPA_TOKEN']}")
+    with urllib.request.urlopen(request, timeout=2) as response:
+        answer = json.load(response)
+    return answer.get("result", {"allowed": False}), answer.get("metrics", {})
+
+
+def server_timing(started, metrics, cached):
+    """Server-Timing value: the round trip, then the phases OPA timed (evaluation includes external calls)."""
+    entries = [f'authz;dur={(time.monotonic() - started) * 1000:.2f};desc="authorization"']
+    if cached:
+        entries.append('authz-cache;desc="cached decision"')
+    for phase, spec in TIMING_PHASES.items():
+        if spec["metric"] in metrics:
+            entries.append(f"authz-{phase.replace('_', '-')};dur={metrics[spec['metric']] / 1e6:.2f}")
+    return ", ".join(entries)
+
+
+class AuthzHandler(BaseHTTPRequestHandler):
+    def handle_one(self):
+        started = time.monotonic()
+        length = int(self.headers.get("content-length") or 0)
+        body = self.rfile.read(length) if length else b""
+        check = check_input(self, body)
+        key = CACHE.key(check)
+        metrics = {}
+        try:
+            result = CACHE.get(key)
+            cached = result is not None
+            if result is None:
+                cls = CLASSES.classify(self)
+                if not ADMISSION.acquire(cls):
+                    self.send_response(503)
+                    self.send_header("Content-Type", "application/json")
+                    self.send_header("Retry-After", "1")
+                    self.send_header("X-Authz-Shed", cls["name"])
+                    self.send_header("Content-Length", str(len(SHED_BODY)))
+                    self.end_headers()
+                    self.wfile.write(SHED_BODY)
+                    return
+                try:
+                    result, metrics = decide(check)
+                finally:
+                    ADMISSION.release()
+                CACHE.put(key, result)
+        except Exception as e:
+            print(f"http_authz_adapter: OPA evaluation failed: {e}", file=sys.stderr)
+            self.send_response(503)
+            self.end_headers()
+            return
+        if result.get("allowed"):
+            self.send_response(200)
+            for name, value in result.get("headers", {}).items():
+                self.send_header(name, value)
+            for name, value in result.get("response_headers_to_add", {}).items():
+                self.send_header(name, value)
+            # Envoy's HTTP ext_authz client strips these from the upstream request, so
+            # identity headers the caller forged never reach the backend.
+            removed = result.get("request_headers_to_remove", [])
+            if removed:
+                self.send_header("x-envoy-auth-headers-to-remove", ", ".join(removed))
+            if TIMING_PHASES:
+                self.send_header("Server-Timing", server_timing(started, metrics, cached))
+            self.end_headers()
+            return
+        payload = result.get("body", "").encode()
+        self.send_response(result.get("http_status", 403))
+        for name, value in result.get("headers", {}).items():
+            self.send_header(name, value)
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    do_GET = do_POST = do_PUT = do_PATCH = do_DELETE = do_HEAD = do_OPTIONS = handle_one
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    global OPA_URL, CACHE, ADMISSION, TIMING_PHASES, TRUSTED_PROXIES
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=9292, help="port to serve the HTTP authorization API on")
+    parser.add_argument("--opa", default=OPA_URL, help="OPA REST API base URL")
+    parser.add_argument("--cache-entries", type=int, default=0,
+                        help="cached allows to keep (0 disables the decision cache)")
+    parser.add_argument("--cache-ignore-headers", default=DEFAULT_IGNORED_HEADERS,
+                        help="comma-separated globs over per-request headers left out of the cache key")
+    parser.add_argument("--cache-redis", help="share the decision cache through this Redis (redis://host:port/db)")
+    parser.add_argument("--max-inflight", type=int, default=0,
+                        help="concurrent OPA evaluations before requests wait by priority (0 disables shedding)")
+    parser.add_argument("--classes-refresh", type=int, default=30, help="seconds between reloads of the priority classes")
+    parser.add_argument("--metrics-port", type=int, help="serve Prometheus metrics on this port")
+    parser.add_argument("--trusted-proxies", default="",
+                        help="comma-separated CIDRs of proxies whose X-Forwarded-For hops are believed")
+    parser.add_argument("--server-timing", action="store_true", help="add a Server-Timing header to allows")
+    parser.add_argument("--budgets", default=DEFAULT_BUDGETS, help="latency budget file naming the timed phases")
+    args = parser.parse_args()
+    OPA_URL = args.opa.rstrip("/")
+    TRUSTED_PROXIES = [ipaddress.ip_network(c.strip()) for c in args.trusted_proxies.split(",") if c.strip()]
+    ignored_headers = [h.strip().lower() for h in args.cache_ignore_headers.split(",") if h.strip()]
+    if args.cache_redis:
+        CACHE = RedisDecisionCache(args.cache_redis, ignored_headers)
+    else:
+        CACHE = DecisionCache(args.cache_entries, ignored_headers)
+    ADMISSION = Admission(args.max_inflight)
+    if args.server_timing:
+        with open(args.budgets) as f:
+            TIMING_PHASES = json.load(f)["phases"]
+    if args.max_inflight > 0:
+        threading.Thread(target=CLASSES.refresh_forever, args=(args.classes_refresh,), daemon=True).start()
+    if args.metrics_port:
+        server = HTTPServer(("", args.metrics_port), MetricsHandler)
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+    ThreadingHTTPServer(("", args.port), AuthzHandler).serve_forever()
+
+
+if __name__ == "__main__":
+    main()
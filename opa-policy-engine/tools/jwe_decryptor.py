@@ -0,0 +1,93 @@
+#!/usr/bin/env python3
+"""
+Decrypts encrypted (JWE) access tokens for the engine (policies/token.rego).
+
+OPA has no builtin to decrypt JWE, so with config.token.decryption the policy
+POSTs {"token": <compact JWE>} to /decrypt and gets {"token": <signed JWT>} back,
+which it then verifies like any other access token. The private keys are a JWK
+set read from the environment variable named by --keys-env (the realm's
+encryption keys, exported from Keycloak); a token is tried against the key its
+`kid` names, or every key when it names none. Tokens that cannot be decrypted, or
+whose content is not a compact JWS, get 400. GET /health answers 200.
+
+Needs the jwcrypto package. Only the decrypted token leaves this process, so
+bind it to localhost or a network only the engine reaches.
+
+Usage:
+    AUTHZ_JWE_KEYS='{"keys": [...]}' tools/jwe_decryptor.py --port 9494
+"""
+
+import argparse
+import json
+import os
+import sys
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+KEYS = None
+
+
+def load_keys(text):
+    from jwcrypto import jwk  # optional dependency: pip install jwcrypto
+
+    keys = jwk.JWKSet()
+    keys.import_keyset(text)
+    return keys
+
+
+def decrypt(token):
+    from jwcrypto import jwe
+
+    envelope = jwe.JWE()
+    envelope.deserialize(token, key=KEYS)
+    inner = envelope.payload.decode()
+    if inner.count(".") != 2:
+        raise ValueError("the decrypted content is not a compact JWS")
+    return inner
+
+
+class DecryptorHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path != "/health":
+            self.send_error(404)
+            return
+        self.send_response(200)
+        self.send_header("Content-Length", "0")
+        self.end_headers()
+
+    def do_POST(self):
+        if self.path != "/decrypt":
+            self.send_error(404)
+            return
+        try:
+            request = json.loads(self.rfile.read(int(self.headers.get("content-length") or 0)))
+            result = {"token": decrypt(str(request["token"]))}
+        except Exception as e:  # malformed requests and every jwcrypto failure
+            self.send_error(400, str(e))
+            return
+        payload = json.dumps(result).encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    global KEYS
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=9494, help="port to serve /decrypt on")
+    parser.add_argument("--bind", default="127.0.0.1", help="address to listen on")
+    parser.add_argument("--keys-env", default="AUTHZ_JWE_KEYS", help="variable holding the private JWK set")
+    args = parser.parse_args()
+    if not os.environ.get(args.keys_env):
+        sys.exit(f"jwe_decryptor: {args.keys_env} is not set")
+    KEYS = load_keys(os.environ[args.keys_env])
+    print(f"jwe_decryptor: serving on {args.bind}:{args.port}", file=sys.stderr)
+    ThreadingHTTPServer((args.bind, args.port), DecryptorHandler).serve_forever()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,88 @@
+#!/usr/bin/env python3
+"""
+Check decision latency against per-phase budgets.
+
+Reads OPA decision log lines from files or stdin, compares the timers recorded in
+each decision's "metrics" with the budgets in config/latency-budgets.json, and
+prints a warning for every phase over budget. With --metrics-port, the counts are
+also served in the Prometheus text format.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/latency_budget.py --metrics-port 9464
+"""
+
+import argparse
+import fileinput
+import json
+import os
+import sys
+import threading
+from collections import Counter
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+DEFAULT_BUDGETS = os.path.join(os.path.dirname(__file__), "..", "config", "latency-budgets.json")
+
+decisions = Counter()
+over_budget = Counter()
+
+
+class MetricsHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        lines = [
+            "# HELP authz_decisions_checked_total Decisions checked against latency budgets.",
+            "# TYPE authz_decisions_checked_total counter",
+            f"authz_decisions_checked_total {decisions['total']}",
+            "# HELP authz_latency_budget_exceeded_total Decisions where a phase exceeded its latency budget.",
+            "# TYPE authz_latency_budget_exceeded_total counter",
+        ]
+        lines += [f'authz_latency_budget_exceeded_total{{phase="{phase}"}} {count}' for phase, count in sorted(over_budget.items())]
+        body = ("\n".join(lines) + "\n").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain; version=0.0.4")
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--budgets", default=DEFAULT_BUDGETS, help="latency budget file")
+    parser.add_argument("--metrics-port", type=int, help="serve Prometheus metrics on this port")
+    args = parser.parse_args()
+
+    with open(args.budgets) as f:
+        phases = json.load(f)["phases"]
+
+    if args.metrics_port:
+        server = HTTPServer(("", args.metrics_port), MetricsHandler)
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+
+    for line in fileinput.input(args.files):
+        try:
+            event = json.loads(line)
+        except ValueError:
+            continue
+        if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+            continue
+        decisions["total"] += 1
+        metrics = event.get("metrics", {})
+        for phase, budget in phases.items():
+            elapsed_ms = metrics.get(budget["metric"], 0) / 1e6
+            if elapsed_ms > budget["budget_ms"]:
+                over_budget[phase] += 1
+                print(json.dumps({
+                    "level": "warn",
+                    "msg": "latency budget exceeded",
+                    "decision_id": event.get("decision_id"),
+                    "path": event.get("input", {}).get("attributes", {}).get("request", {}).get("http", {}).get("path"),
+                    "phase": phase,
+                    "elapsed_ms": round(elapsed_ms, 3),
+                    "budget_ms": budget["budget_ms"],
+                }), file=sys.stderr, flush=True)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,307 @@
+#!/usr/bin/env python3
+"""
+Route OPA's log output to several destinations.
+
+OPA writes application logs and decision (audit) logs to the same stream as JSON
+lines. This router reads that stream from stdin and sends each line to the
+destinations in config/log-destinations.json that subscribe to its stream
+("app" or "audit") at or above their level. Every decision also yields a compact
+audit record on the "decisions" stream: one JSON object with the time, decision
+id, request id, method, host, path, principal, deciding rule, decision, code,
+reason and latency, for compliance pipelines that do not want OPA's full event.
+Exact duplicates (same request hash and outcome, as with agent retries) within
+--dedup-seconds collapse into the first record, emitted once the window closes
+with a `count`.
+Denials the engine sampled for capture (config.capture, see decision.rego) also
+yield a forensic record on the "captures" stream: the request's headers and body
+with credentials and secret-looking fields redacted, plus the decision. Send it to a
+"capture_dir" destination, a directory keeping the newest max_files captures, or
+to an archive.
+Destinations are stdout, rotating files, syslog (which reaches journald through
+/dev/log on systemd hosts), S3/GCS archives (see archive_decision_logs.py),
+webhooks and Kafka topics (with the kafka-python package).
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/log_router.py
+"""
+
+import argparse
+import json
+import logging
+import logging.handlers
+import os
+import re
+import sys
+import threading
+import time
+import urllib.request
+
+from archive_decision_logs import ArchiveHandler, Archiver
+
+DEFAULT_CONFIG = os.path.join(os.path.dirname(__file__), "..", "config", "log-destinations.json")
+
+LEVELS = {"debug": logging.DEBUG, "info": logging.INFO, "warn": logging.WARNING, "warning": logging.WARNING, "error": logging.ERROR}
+
+
+class WebhookHandler(logging.Handler):
+    """POSTs each record, which must be a JSON line, to a URL."""
+
+    def __init__(self, url, timeout):
+        super().__init__()
+        self.url = url
+        self.timeout = timeout
+
+    def emit(self, record):
+        request = urllib.request.Request(self.url, data=self.format(record).encode(), headers={"Content-Type": "application/json"})
+        try:
+            urllib.request.urlopen(request, timeout=self.timeout).close()
+        except Exception:
+            self.handleError(record)
+
+
+class CaptureDirHandler(logging.Handler):
+    """Writes each capture to its own file, keeping only the newest max_files."""
+
+    def __init__(self, path, max_files):
+        super().__init__()
+        self.path = path
+        self.max_files = max_files
+        os.makedirs(path, exist_ok=True)
+
+    def emit(self, record):
+        try:
+            capture = json.loads(record.getMessage())
+            name = f"{time.strftime('%Y%m%dT%H%M%S')}-{capture.get('decision_id') or 'unknown'}.json"
+            with open(os.path.join(self.path, name), "w") as f:
+                f.write(self.format(record))
+            files = sorted(os.listdir(self.path))
+            for old in files[:max(0, len(files) - self.max_files)]:
+                os.remove(os.path.join(self.path, old))
+        except Exception:
+            self.handleError(record)
+
+
+class KafkaHandler(logging.Handler):
+    """Produces each record to a Kafka topic."""
+
+    def __init__(self, servers, topic):
+        super().__init__()
+        from kafka import KafkaProducer  # optional dependency: pip install kafka-python
+
+        self.producer = KafkaProducer(bootstrap_servers=servers)
+        self.topic = topic
+
+    def emit(self, record):
+        self.producer.send(self.topic, self.format(record).encode())
+
+    def close(self):
+        self.producer.flush()
+        super().close()
+
+
+def build_handler(destination):
+    kind = destination["type"]
+    if kind == "stdout":
+        return logging.StreamHandler(sys.stdout)
+    if kind == "file":
+        os.makedirs(os.path.dirname(destination["path"]) or ".", exist_ok=True)
+        return logging.handlers.RotatingFileHandler(
+            destination["path"],
+            maxBytes=destination.get("max_bytes", 10 * 1024 * 1024),
+            backupCount=destination.get("backups", 5),
+        )
+    if kind == "syslog":
+        address = destination.get("address", "/dev/log")
+        if ":" in address:
+            host, port = address.rsplit(":", 1)
+            address = (host, int(port))
+        facility = logging.handlers.SysLogHandler.facility_names[destination.get("facility", "user")]
+        handler = logging.handlers.SysLogHandler(address=address, facility=facility)
+        handler.ident = "opa-policy-engine: "
+        return handler
+    if kind == "archive":
+        return ArchiveHandler(Archiver(
+            destination["destination"],
+            destination.get("max_records", 10000),
+            destination.get("max_seconds", 300),
+            destination.get("encryption_key_file"),
+        ))
+    if kind == "webhook":
+        return WebhookHandler(destination["url"], destination.get("timeout_seconds", 5))
+    if kind == "kafka":
+        return KafkaHandler(destination["bootstrap_servers"], destination["topic"])
+    if kind == "capture_dir":
+        return CaptureDirHandler(destination["path"], destination.get("max_files", 1000))
+    raise ValueError(f"unknown log destination type {kind!r}")
+
+
+def build_loggers(config):
+    """One logger per stream, with a handler per subscribed destination."""
+    loggers = {stream: logging.getLogger(f"opa.{stream}") for stream in ("app", "audit", "decisions", "captures")}
+    for logger in loggers.values():
+        logger.setLevel(logging.DEBUG)
+        logger.propagate = False
+    for destination in config["destinations"]:
+        if not destination.get("enabled", True):
+            continue
+        handler = build_handler(destination)
+        handler.setLevel(LEVELS[destination.get("level", "info")])
+        handler.setFormatter(logging.Formatter("%(message)s"))
+        for stream in destination["streams"]:
+            loggers[stream].addHandler(handler)
+    return loggers
+
+
+def parse(line):
+    """Return the event of an OPA log line, or None for other output."""
+    try:
+        event = json.loads(line)
+    except ValueError:
+        return None
+    return event if isinstance(event, dict) else None
+
+
+def classify(event):
+    """Return the stream and level of an OPA log event."""
+    if event is None:
+        return "app", logging.INFO
+    stream = "audit" if event.get("msg") == "Decision Log" else "app"
+    return stream, LEVELS.get(event.get("level", "info"), logging.INFO)
+
+
+def audit_record(event):
+    """Compact audit record of a decision log event."""
+    http = event.get("input", {}).get("attributes", {}).get("request", {}).get("http", {})
+    result = event.get("result") if isinstance(event.get("result"), dict) else {}
+    metadata = result.get("dynamic_metadata", {})
+    allowed = result.get("allowed", False)
+    error = {}
+    if not allowed:
+        try:
+            error = json.loads(result.get("body", "{}"))
+        except ValueError:
+            pass
+    return {
+        "time": event.get("time"),
+        "decision_id": event.get("decision_id"),
+        "request_id": metadata.get("correlation", {}).get("request_id"),
+        "method": http.get("method"),
+        "host": http.get("host"),
+        "path": http.get("path"),
+        "principal": metadata.get("principal"),
+        "rule": metadata.get("rule"),
+        "decision": "error" if event.get("error") else "allow" if allowed else "deny",
+        "code": metadata.get("code", error.get("error")),
+        "reason": error.get("message"),
+        "latency_ms": round(event.get("metrics", {}).get("timer_server_handler_ns", 0) / 1e6, 3),
+        "request_hash": metadata.get("request_hash"),
+        "count": 1,
+    }
+
+
+# Headers that carry credentials, and body fields whose names suggest secrets.
+REDACTED_HEADERS = {"authorization", "proxy-authorization", "cookie", "set-cookie", "dpop", "x-api-key"}
+SECRET_FIELD = re.compile(r"password|secret|token|api[_-]?key|credential", re.IGNORECASE)
+JWT = re.compile(r"eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*")
+
+
+def redact(value):
+    if isinstance(value, dict):
+        return {k: "[redacted]" if SECRET_FIELD.search(k) else redact(v) for k, v in value.items()}
+    if isinstance(value, list):
+        return [redact(v) for v in value]
+    if isinstance(value, str):
+        return JWT.sub("[redacted]", value)
+    return value
+
+
+def capture_record(event):
+    """Forensic record of a sampled denial, or None if the decision was not sampled."""
+    result = event.get("result") if isinstance(event.get("result"), dict) else {}
+    if not result.get("dynamic_metadata", {}).get("capture"):
+        return None
+    attributes = event.get("input", {}).get("attributes", {})
+    http = attributes.get("request", {}).get("http", {})
+    headers = {k: "[redacted]" if k.lower() in REDACTED_HEADERS else v for k, v in http.get("headers", {}).items()}
+    return {
+        "time": event.get("time"),
+        "decision_id": event.get("decision_id"),
+        "source": attributes.get("source", {}),
+        "request": {
+            "method": http.get("method"),
+            "host": http.get("host"),
+            "path": http.get("path"),
+            "headers": redact(headers),
+            "body": redact(event.get("input", {}).get("parsed_body", http.get("body"))),
+        },
+        "result": redact(result),
+    }
+
+
+class Deduplicator:
+    """Holds each audit record for `window` seconds, counting exact duplicates."""
+
+    def __init__(self, window, emit):
+        self.window = window
+        self.emit = emit
+        self.pending = {}
+        self.lock = threading.Lock()
+
+    def add(self, level, record):
+        if self.window <= 0 or not record.get("request_hash"):
+            self.emit(level, record)
+            return
+        key = (record["request_hash"], record["decision"], record["code"])
+        with self.lock:
+            if key in self.pending:
+                self.pending[key][2]["count"] += 1
+            else:
+                self.pending[key] = (time.monotonic() + self.window, level, record)
+
+    def flush(self, everything=False):
+        now = time.monotonic()
+        with self.lock:
+            due = [key for key, (until, _, _) in self.pending.items() if everything or until <= now]
+            records = [self.pending.pop(key)[1:] for key in due]
+        for level, record in records:
+            self.emit(level, record)
+
+    def flush_forever(self):
+        while True:
+            time.sleep(1)
+            self.flush()
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--config", default=DEFAULT_CONFIG, help="log destination config")
+    parser.add_argument("--dedup-seconds", type=float, default=10,
+                        help="collapse identical decisions within this window (0 disables)")
+    args = parser.parse_args()
+
+    with open(args.config) as f:
+        loggers = build_loggers(json.load(f))
+    decisions = Deduplicator(args.dedup_seconds, lambda level, record: loggers["decisions"].log(level, json.dumps(record)))
+    threading.Thread(target=decisions.flush_forever, daemon=True).start()
+
+    try:
+        for line in sys.stdin:
+            line = line.rstrip("\n")
+            if not line:
+                continue
+            event = parse(line)
+            stream, level = classify(event)
+            loggers[stream].log(level, line)
+            if stream == "audit":
+                decisions.add(level, audit_record(event))
+                capture = capture_record(event)
+                if capture:
+                    loggers["captures"].log(level, json.dumps(capture))
+    finally:
+        decisions.flush(everything=True)
+        # Flushes the last partial archive batches.
+        logging.shutdown()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,123 @@
+#!/usr/bin/env python3
+"""
+Generate per-tool policy stubs from an MCP server's tools/list manifest.
+
+The manifest is fetched from a streamable HTTP MCP endpoint (for example through
+agentgateway) or read from a file holding a tools/list result. Tools are written
+to policies/mcp/data.json under the server name, where OPA loads them as
+data.mcp.<name>. New tools are added with "allowed": false, so they are denied
+until explicitly allowed; existing entries keep their settings, including
+argument constraints (see policies/mcp.rego).
+
+Usage:
+    tools/mcp_manifest_to_policy.py --name general --prefix /general/mcp --url http://localhost:3000/general/mcp
+    tools/mcp_manifest_to_policy.py --name general --prefix /general/mcp --manifest tools.json
+"""
+
+import argparse
+import json
+import os
+import sys
+import urllib.request
+
+DEFAULT_OUTPUT = os.path.join(os.path.dirname(__file__), "..", "policies", "mcp", "data.json")
+
+
+def rpc(url, method, params, headers, request_id=None):
+    """Send a JSON-RPC message and return the response message and headers."""
+    message = {"jsonrpc": "2.0", "method": method, "params": params}
+    if request_id is not None:
+        message["id"] = request_id
+    request = urllib.request.Request(
+        url,
+        data=json.dumps(message).encode(),
+        headers={"Content-Type": "application/json", "Accept": "application/json, text/event-stream", **headers},
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=30) as response:
+        body = response.read().decode()
+        if request_id is None:
+            return None, response.headers
+        if response.headers.get("Content-Type", "").startswith("text/event-stream"):
+            # Streamable HTTP may answer with SSE; the response is the event with our id.
+            for line in body.splitlines():
+                if line.startswith("data:"):
+                    event = json.loads(line[5:])
+                    if event.get("id") == request_id:
+                        return event, response.headers
+            sys.exit(f"No response to {method} in event stream")
+        return json.loads(body), response.headers
+
+
+def fetch_tools(url, token):
+    headers = {"Authorization": f"Bearer {token}"} if token else {}
+    initialized, response_headers = rpc(url, "initialize", {
+        "protocolVersion": "2025-03-26",
+        "capabilities": {},
+        "clientInfo": {"name": "mcp-manifest-to-policy", "version": "1.0.0"},
+    }, headers, request_id=1)
+    if "error" in initialized:
+        sys.exit(f"initialize failed: {initialized['error']}")
+    session = response_headers.get("Mcp-Session-Id")
+    if session:
+        headers["Mcp-Session-Id"] = session
+    rpc(url, "notifications/initialized", {}, headers)
+
+    tools, cursor = [], None
+    while True:
+        listed, _ = rpc(url, "tools/list", {"cursor": cursor} if cursor else {}, headers, request_id=2)
+        if "error" in listed:
+            sys.exit(f"tools/list failed: {listed['error']}")
+        tools.extend(listed["result"]["tools"])
+        cursor = listed["result"].get("nextCursor")
+        if not cursor:
+            return tools
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--name", required=True, help="server name, used as data.mcp.<name>")
+    parser.add_argument("--prefix", required=True, help="path the gateway serves the MCP server under")
+    source = parser.add_mutually_exclusive_group(required=True)
+    source.add_argument("--url", help="streamable HTTP MCP endpoint to fetch tools/list from")
+    source.add_argument("--manifest", help="file with a tools/list result ({\"tools\": [...]})")
+    parser.add_argument("--token", default=os.environ.get("MCP_TOKEN"), help="bearer token for --url (default: $MCP_TOKEN)")
+    parser.add_argument("--output", default=DEFAULT_OUTPUT, help="data file to update")
+    args = parser.parse_args()
+
+    if args.url:
+        tools = fetch_tools(args.url, args.token)
+    else:
+        with open(args.manifest) as f:
+            tools = json.load(f)["tools"]
+
+    document = {}
+    if os.path.exists(args.output):
+        with open(args.output) as f:
+            document = json.load(f)
+    existing = document.get(args.name, {}).get("tools", {})
+
+    entries = {}
+    for tool in tools:
+        entry = existing.get(tool["name"], {"allowed": False})
+        entry["description"] = tool.get("description", "")
+        entries[tool["name"]] = entry
+    added = sorted(set(entries) - set(existing))
+    removed = sorted(set(existing) - set(entries))
+
+    prefix = args.prefix.rstrip("/")
+    document[args.name] = {"paths": [prefix, prefix + "/**"], "tools": entries}
+    os.makedirs(os.path.dirname(args.output), exist_ok=True)
+    with open(args.output, "w") as f:
+        json.dump(document, f, indent=2, sort_keys=True)
+        f.write("\n")
+
+    print(f"Wrote {len(entries)} tools for {args.name} to {args.output}")
+    if added:
+        print(f"New tools (denied until allowed): {', '.join(added)}")
+    if removed:
+        print(f"Tools no longer offered: {', '.join(removed)}")
+
+
+if __name__ == "__main__":
+    main()
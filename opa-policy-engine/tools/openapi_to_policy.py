@@ -0,0 +1,104 @@
+#!/usr/bin/env python3
+"""
+Generate route policies from a backend's OpenAPI spec.
+
+The operations of the spec (method, templated path, required OAuth scopes and an
+optional request size limit) are written to policies/openapi/data.json under the
+backend name, where OPA loads them as data.openapi.<name> for authz.openapi.
+
+Usage:
+    tools/openapi_to_policy.py --name orders --prefix /orders spec.yaml
+"""
+
+import argparse
+import json
+import os
+import re
+import sys
+
+HTTP_METHODS = ("get", "put", "post", "delete", "options", "head", "patch", "trace")
+
+DEFAULT_OUTPUT = os.path.join(os.path.dirname(__file__), "..", "policies", "openapi", "data.json")
+
+
+def load_document(path):
+    """Load a JSON or YAML document."""
+    with open(path) as f:
+        text = f.read()
+    if path.endswith((".yaml", ".yml")):
+        try:
+            import yaml
+        except ImportError:
+            sys.exit("PyYAML is required for YAML specs: pip install pyyaml")
+        return yaml.safe_load(text)
+    return json.loads(text)
+
+
+def oauth_schemes(spec):
+    """Names of the security schemes that carry OAuth scopes."""
+    schemes = spec.get("components", {}).get("securitySchemes", {})
+    return {name for name, scheme in schemes.items() if scheme.get("type") in ("oauth2", "openIdConnect")}
+
+
+def scope_alternatives(requirements, schemes):
+    """Alternative scope sets, any one of which satisfies the requirements."""
+    alternatives = [
+        sorted({scope for name, scopes in requirement.items() if name in schemes for scope in scopes})
+        for requirement in requirements
+    ]
+    # An alternative without scopes (an API key or an empty requirement) needs none.
+    if any(not scopes for scopes in alternatives):
+        return []
+    return alternatives
+
+
+def to_glob(prefix, path):
+    """Turn an OpenAPI path template into a glob: /pets/{id} -> /pets/*."""
+    return prefix.rstrip("/") + re.sub(r"\{[^}/]+\}", "*", path)
+
+
+def generate(spec, prefix, max_body_bytes):
+    schemes = oauth_schemes(spec)
+    global_security = spec.get("security", [])
+    operations = []
+    for path, item in sorted(spec.get("paths", {}).items()):
+        for method in HTTP_METHODS:
+            operation = item.get(method)
+            if operation is None:
+                continue
+            entry = {
+                "operation_id": operation.get("operationId", f"{method} {path}"),
+                "method": method.upper(),
+                "path": to_glob(prefix, path),
+                "scopes": scope_alternatives(operation.get("security", global_security), schemes),
+            }
+            limit = operation.get("x-max-body-bytes", max_body_bytes)
+            if limit is not None:
+                entry["max_body_bytes"] = limit
+            operations.append(entry)
+    return {"paths": [prefix.rstrip("/") + "/**"], "operations": operations}
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("spec", help="OpenAPI spec (JSON or YAML)")
+    parser.add_argument("--name", required=True, help="backend name, used as data.openapi.<name>")
+    parser.add_argument("--prefix", default="", help="path prefix the gateway serves the backend under")
+    parser.add_argument("--max-body-bytes", type=int, help="default request size limit for operations")
+    parser.add_argument("--output", default=DEFAULT_OUTPUT, help="data file to update")
+    args = parser.parse_args()
+
+    document = {}
+    if os.path.exists(args.output):
+        document = load_document(args.output)
+    document[args.name] = generate(load_document(args.spec), args.prefix, args.max_body_bytes)
+
+    os.makedirs(os.path.dirname(args.output), exist_ok=True)
+    with open(args.output, "w") as f:
+        json.dump(document, f, indent=2, sort_keys=True)
+        f.write("\n")
+    print(f"Wrote {len(document[args.name]['operations'])} operations for {args.name} to {args.output}")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,417 @@
+#!/usr/bin/env python3
+"""
+Language server for the engine's policy configuration files.
+
+Serves the Language Server Protocol over stdio for data.yaml, environment
+overlays and rules files (run-opa.sh --rules): completion of keys and enum
+values and hover docs from the JSON schema the file names in its
+yaml-language-server header (default schemas/policy-config.schema.json), and
+diagnostics from the engine's own parser and validators:
+
+- the YAML is parsed by OPA's yaml.unmarshal and checked against the schema
+  with OPA's json.match_schema, the builtins the engine runs (`opa` on PATH, or
+  --opa);
+- every `cel` expression is compiled by tools/cel_evaluator.py's compiler, as
+  the evaluator does before answering the engine (needs the cel-python package).
+
+Diagnostics are placed on the offending key with the PyYAML package, on the
+first line without it. Rego policies are served by Regal's language server (see
+README.md, Editor support).
+
+VS Code, with a generic LSP client extension:
+    "command": "tools/policy_lsp.py", "languages": ["yaml"]
+
+Usage:
+    tools/policy_lsp.py [--opa /usr/local/bin/opa]
+"""
+
+import argparse
+import json
+import os
+import re
+import subprocess
+import sys
+import urllib.parse
+
+TOOLS = os.path.dirname(os.path.abspath(__file__))
+DEFAULT_SCHEMA = os.path.join(TOOLS, "..", "schemas", "policy-config.schema.json")
+SCHEMA_HEADER = re.compile(r"#\s*yaml-language-server:\s*\$schema=(\S+)")
+KEY = re.compile(r"^([A-Za-z0-9_.-]+|\"[^\"]*\"|'[^']*')\s*:(?:\s|$)")
+YAML_ERROR = re.compile(r"yaml: line (\d+): (.*)")
+
+OPA = "opa"
+
+ERROR, WARNING = 1, 2
+
+
+# --- schemas -----------------------------------------------------------------
+
+_schemas = {}
+
+
+def load_schema(path):
+    path = os.path.normpath(path)
+    if path not in _schemas:
+        with open(path) as f:
+            _schemas[path] = json.load(f)
+    return _schemas[path]
+
+
+def resolve(node, path):
+    """Follows $ref (this file's "#/..." or "other.json#/...") to the schema it names."""
+    while isinstance(node, dict) and "$ref" in node:
+        target, _, pointer = node["$ref"].partition("#")
+        if target:
+            path = os.path.join(os.path.dirname(path), target)
+        node = load_schema(path)
+        for part in [p for p in pointer.split("/") if p]:
+            node = node[part.replace("~1", "/").replace("~0", "~")]
+    return node, path
+
+
+def inline(node, path):
+    """The schema with every $ref replaced by its target, as json.match_schema needs it."""
+    node, path = resolve(node, path)
+    if isinstance(node, dict):
+        return {k: inline(v, path) for k, v in node.items() if k not in ("$schema", "$id", "$defs")}
+    if isinstance(node, list):
+        return [inline(v, path) for v in node]
+    return node
+
+
+def alternatives(node, path):
+    node, path = resolve(node, path)
+    yield node, path
+    for key in ("oneOf", "anyOf", "allOf"):
+        for alternative in node.get(key, []) if isinstance(node, dict) else []:
+            yield from alternatives(alternative, path)
+
+
+def properties(node, path):
+    found = {}
+    for alternative, alt_path in alternatives(node, path):
+        for name, schema in alternative.get("properties", {}).items():
+            found.setdefault(name, (schema, alt_path))
+    return found
+
+
+def child(node, path, key):
+    """Schema of a key ("-" for a list item) under a schema, or (None, path)."""
+    for alternative, alt_path in alternatives(node, path):
+        if key == "-" and "items" in alternative:
+            return resolve(alternative["items"], alt_path)
+        if key in alternative.get("properties", {}):
+            return resolve(alternative["properties"][key], alt_path)
+        if key != "-" and isinstance(alternative.get("additionalProperties"), dict):
+            return resolve(alternative["additionalProperties"], alt_path)
+    return None, path
+
+
+def schema_at(root, keys):
+    node, path = root
+    for key in keys:
+        node, path = child(node, path, key)
+        if node is None:
+            break
+    return node, path
+
+
+def document_schema(uri, text):
+    """The schema a document names in its yaml-language-server header, else the config schema."""
+    match = SCHEMA_HEADER.search("\n".join(text.splitlines()[:5]))
+    if match and uri.startswith("file://"):
+        path = os.path.join(os.path.dirname(urllib.parse.unquote(uri[len("file://"):])), match.group(1))
+    else:
+        path = DEFAULT_SCHEMA
+    try:
+        return load_schema(path), os.path.normpath(path)
+    except (OSError, ValueError):
+        return load_schema(DEFAULT_SCHEMA), os.path.normpath(DEFAULT_SCHEMA)
+
+
+def describe(name, node, path):
+    node, path = resolve(node, path)
+    kind = node.get("type") or ("enum" if "enum" in node else "")
+    lines = [f"**{name}**" + (f" `{kind}`" if kind else "")]
+    if node.get("description"):
+        lines.append(node["description"])
+    if node.get("enum"):
+        lines.append("one of: " + ", ".join(f"`{v}`" for v in node["enum"]))
+    return "\n\n".join(lines)
+
+
+# --- YAML positions ----------------------------------------------------------
+
+
+def uncommented(line):
+    return line.split(" #")[0].rstrip() if not line.lstrip().startswith("#") else ""
+
+
+def path_at(lines, line, prefix):
+    """Keys of the mappings enclosing a position, outermost first; "-" stands for a list item."""
+    marker = re.match(r"^(\s*)((?:-\s+)*)", prefix)
+    indent = len(marker.group(0))
+    path = ["-"] * marker.group(2).count("-")
+    inclusive = bool(path)
+    if path:
+        indent = len(marker.group(1))
+    for i in range(line - 1, -1, -1):
+        text = uncommented(lines[i])
+        if not text.strip():
+            continue
+        depth = len(text) - len(text.lstrip())
+        content = text.strip()
+        if depth > indent or (depth == indent and not inclusive):
+            continue
+        if content.startswith("- "):
+            if depth < indent or inclusive:
+                rest = content[2:].lstrip()
+                nested = len(text) - len(rest)
+                if nested < len(marker.group(0)) or path[:1] != ["-"] or depth < indent:
+                    path.insert(0, "-")
+                indent, inclusive = depth, True
+            continue
+        key = KEY.match(content)
+        if key:
+            path.insert(0, key.group(1).strip("\"'"))
+            indent, inclusive = depth, False
+        if depth == 0:
+            break
+    return path
+
+
+def locate(text, field):
+    """Zero-based line of a json.match_schema field ("config.rules.0.name"), with PyYAML."""
+    try:
+        import yaml  # optional dependency: pip install pyyaml
+    except ImportError:
+        return 0
+    try:
+        node = yaml.compose(text)
+    except yaml.YAMLError:
+        return 0
+    line = node.start_mark.line if node else 0
+    for part in [] if field in ("", "(root)") else field.split("."):
+        if isinstance(node, yaml.MappingNode):
+            matches = [(k, v) for k, v in node.value if k.value == part]
+            if not matches:
+                break
+            line, node = matches[0][0].start_mark.line, matches[0][1]
+        elif isinstance(node, yaml.SequenceNode) and part.isdigit() and int(part) < len(node.value):
+            node = node.value[int(part)]
+            line = node.start_mark.line
+        else:
+            break
+    return line
+
+
+def cel_expressions(text):
+    """(line, expression) of every `cel` key, with PyYAML."""
+    try:
+        import yaml
+        root = yaml.compose(text)
+    except Exception:
+        return
+    stack = [root] if root else []
+    while stack:
+        node = stack.pop()
+        if isinstance(node, yaml.MappingNode):
+            for key, value in node.value:
+                if key.value == "cel" and isinstance(value, yaml.ScalarNode):
+                    yield value.start_mark.line, value.value
+                stack.append(value)
+        elif isinstance(node, yaml.SequenceNode):
+            stack.extend(node.value)
+
+
+# --- diagnostics -------------------------------------------------------------
+
+
+def diagnostic(line, message, severity=ERROR):
+    return {
+        "range": {"start": {"line": line, "character": 0}, "end": {"line": line, "character": 1000}},
+        "severity": severity,
+        "source": "policy-engine",
+        "message": message,
+    }
+
+
+def opa_validate(text, schema):
+    """Diagnostics from OPA's yaml.unmarshal and json.match_schema."""
+    query = "doc := yaml.unmarshal(input.text); [valid, errors] := json.match_schema(doc, input.schema)"
+    try:
+        completed = subprocess.run(
+            [OPA, "eval", "--strict-builtin-errors", "--format", "json", "--stdin-input", query],
+            input=json.dumps({"text": text, "schema": schema}), capture_output=True, text=True, timeout=10,
+        )
+        answer = json.loads(completed.stdout or "{}")
+    except (OSError, subprocess.TimeoutExpired, ValueError) as e:
+        return [diagnostic(0, f"could not run {OPA} to validate this file: {e}", WARNING)]
+    if answer.get("errors"):
+        found = []
+        for error in answer["errors"]:
+            match = YAML_ERROR.search(error.get("message", ""))
+            if match:
+                found.append(diagnostic(int(match.group(1)) - 1, f"YAML: {match.group(2)}"))
+            else:
+                found.append(diagnostic(0, error.get("message", "OPA could not evaluate the file")))
+        return found
+    bindings = (answer.get("result") or [{}])[0].get("bindings", {})
+    return [
+        diagnostic(locate(text, error.get("field", "")), error.get("error", error.get("desc", "")))
+        for error in bindings.get("errors") or []
+    ]
+
+
+def cel_validate(text):
+    sys.path.insert(0, TOOLS)
+    try:
+        import cel_evaluator
+    finally:
+        sys.path.pop(0)
+    found = []
+    for line, expression in cel_expressions(text):
+        try:
+            cel_evaluator.program(expression)
+        except cel_evaluator.CompileError as e:
+            found.append(diagnostic(line, f"CEL: {e}"))
+        except ImportError:
+            return []
+    return found
+
+
+def diagnostics(uri, text):
+    root = document_schema(uri, text)
+    return opa_validate(text, inline(*root)) + cel_validate(text)
+
+
+# --- completion and hover ----------------------------------------------------
+
+
+def completion(uri, text, position):
+    lines = text.splitlines() or [""]
+    current = lines[position["line"]] if position["line"] < len(lines) else ""
+    prefix = current[: position["character"]]
+    root = document_schema(uri, text)
+    key = re.match(r"^\s*(?:-\s+)*([A-Za-z0-9_.-]+)\s*:\s*", prefix)
+    if key:
+        node, path = schema_at(root, path_at(lines, position["line"], prefix[: key.start(1)]) + [key.group(1)])
+        if node is None:
+            return []
+        node, path = resolve(node, path)
+        values = node.get("enum") or ([node["const"]] if "const" in node else [])
+        if node.get("type") == "boolean":
+            values = [True, False]
+        return [{"label": json.dumps(v) if not isinstance(v, str) else v, "kind": 12} for v in values]
+    node, path = schema_at(root, path_at(lines, position["line"], prefix))
+    if node is None:
+        return []
+    return [
+        {"label": name, "kind": 10, "insertText": f"{name}: ",
+         "documentation": {"kind": "markdown", "value": describe(name, schema, schema_path)}}
+        for name, (schema, schema_path) in sorted(properties(node, path).items())
+    ]
+
+
+def hover(uri, text, position):
+    lines = text.splitlines()
+    if position["line"] >= len(lines):
+        return None
+    current = lines[position["line"]]
+    match = re.match(r"^(\s*(?:-\s+)*)([A-Za-z0-9_.-]+)\s*:", current)
+    if not match or not match.start(2) <= position["character"] <= match.end(2):
+        return None
+    root = document_schema(uri, text)
+    node, path = schema_at(root, path_at(lines, position["line"], match.group(1)) + [match.group(2)])
+    if node is None:
+        return None
+    return {"contents": {"kind": "markdown", "value": describe(match.group(2), node, path)}}
+
+
+# --- protocol ----------------------------------------------------------------
+
+
+def read_message(stream):
+    length = None
+    while True:
+        header = stream.readline()
+        if not header:
+            return None
+        header = header.decode("ascii").strip()
+        if not header:
+            break
+        name, _, value = header.partition(":")
+        if name.lower() == "content-length":
+            length = int(value)
+    return json.loads(stream.read(length or 0))
+
+
+def write_message(stream, message):
+    body = json.dumps(message).encode()
+    stream.write(f"Content-Length: {len(body)}\r\n\r\n".encode() + body)
+    stream.flush()
+
+
+def serve(reader, writer):
+    documents = {}
+
+    def publish(uri):
+        found = diagnostics(uri, documents[uri]) if uri in documents else []
+        write_message(writer, {"jsonrpc": "2.0", "method": "textDocument/publishDiagnostics",
+                               "params": {"uri": uri, "diagnostics": found}})
+
+    while True:
+        message = read_message(reader)
+        if message is None:
+            return
+        method, params = message.get("method"), message.get("params") or {}
+        result, known = None, True
+        if method == "initialize":
+            result = {
+                "capabilities": {
+                    "textDocumentSync": {"openClose": True, "change": 1, "save": True},
+                    "completionProvider": {"triggerCharacters": [":", " "]},
+                    "hoverProvider": True,
+                },
+                "serverInfo": {"name": "policy-engine"},
+            }
+        elif method == "textDocument/didOpen":
+            documents[params["textDocument"]["uri"]] = params["textDocument"]["text"]
+            publish(params["textDocument"]["uri"])
+        elif method == "textDocument/didChange":
+            documents[params["textDocument"]["uri"]] = params["contentChanges"][-1]["text"]
+        elif method == "textDocument/didSave":
+            publish(params["textDocument"]["uri"])
+        elif method == "textDocument/didClose":
+            documents.pop(params["textDocument"]["uri"], None)
+            publish(params["textDocument"]["uri"])
+        elif method == "textDocument/completion":
+            uri = params["textDocument"]["uri"]
+            result = completion(uri, documents.get(uri, ""), params["position"])
+        elif method == "textDocument/hover":
+            uri = params["textDocument"]["uri"]
+            result = hover(uri, documents.get(uri, ""), params["position"])
+        elif method == "exit":
+            return
+        elif method not in ("initialized", "shutdown") and not method.startswith("$/"):
+            known = False
+        if "id" not in message:
+            continue
+        if known:
+            write_message(writer, {"jsonrpc": "2.0", "id": message["id"], "result": result})
+        else:
+            write_message(writer, {"jsonrpc": "2.0", "id": message["id"],
+                                   "error": {"code": -32601, "message": f"{method} is not supported"}})
+
+
+def main():
+    global OPA
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--opa", default=os.environ.get("OPA", "opa"), help="OPA binary used for validation")
+    args = parser.parse_args()
+    OPA = args.opa
+    serve(sys.stdin.buffer, sys.stdout.buffer)
+
+
+if __name__ == "__main__":
+    main()
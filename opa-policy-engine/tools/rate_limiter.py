@@ -0,0 +1,180 @@
+#!/usr/bin/env python3
+"""
+Token buckets for the engine's rate limits (policies/ratelimit.rego).
+
+OPA evaluates each decision without state, so the buckets live here: the policy
+POSTs {"key", "rate", "burst"} to /take for every limit that applies to a request
+and gets {"allowed", "remaining", "retry_after_seconds"} back. A bucket starts
+full with `burst` tokens, refills at `rate` tokens per second and is forgotten
+once it has refilled.
+
+It also remembers one-time values for replay checks (policies/dpop.rego): a POST of
+{"key", "ttl_seconds"} to /nonce answers {"fresh": true} the first time a key is
+seen within its ttl and {"fresh": false} afterwards. GET /health answers 200 while
+the limiter, and its Redis if any, can serve them; the engine probes it to pick its
+degradation tier.
+
+State is kept in memory, so run one limiter for all engine replicas, or give every
+limiter the same Redis with --redis (needs the redis package) so any number of them
+enforce the same limits and see the same nonces.
+
+Usage:
+    tools/rate_limiter.py --port 9393 [--redis redis://redis:6379/0]
+"""
+
+import argparse
+import json
+import sys
+import threading
+import time
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+EVICT_INTERVAL_SECONDS = 60
+
+
+class Buckets:
+    def __init__(self):
+        self.buckets = {}
+        self.nonces = {}
+        self.lock = threading.Lock()
+
+    def take(self, key, rate, burst):
+        now = time.monotonic()
+        with self.lock:
+            tokens, last, _, _ = self.buckets.get(key, (burst, now, rate, burst))
+            tokens = min(burst, tokens + (now - last) * rate)
+            if tokens >= 1:
+                self.buckets[key] = (tokens - 1, now, rate, burst)
+                return {"allowed": True, "remaining": int(tokens - 1), "retry_after_seconds": 0}
+            self.buckets[key] = (tokens, now, rate, burst)
+            return {"allowed": False, "remaining": 0, "retry_after_seconds": (1 - tokens) / rate}
+
+    def remember(self, key, ttl):
+        now = time.monotonic()
+        with self.lock:
+            if self.nonces.get(key, 0) > now:
+                return {"fresh": False}
+            self.nonces[key] = now + ttl
+            return {"fresh": True}
+
+    def evict_idle(self):
+        now = time.monotonic()
+        with self.lock:
+            for key, (tokens, last, rate, burst) in list(self.buckets.items()):
+                if tokens + (now - last) * rate >= burst:
+                    del self.buckets[key]
+            for key, expires in list(self.nonces.items()):
+                if expires <= now:
+                    del self.nonces[key]
+
+    def ping(self):
+        pass
+
+
+# The same refill and take as Buckets.take, run atomically in Redis. The bucket
+# expires once it would have refilled.
+TAKE_SCRIPT = """
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local now = redis.call('TIME')
+now = tonumber(now[1]) + tonumber(now[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+return {allowed, tostring(tokens)}
+"""
+
+
+class RedisBuckets:
+    def __init__(self, url):
+        import redis  # optional dependency: pip install redis
+
+        self.redis = redis.Redis.from_url(url)
+        self.take_script = self.redis.register_script(TAKE_SCRIPT)
+
+    def take(self, key, rate, burst):
+        allowed, tokens = self.take_script(keys=[f"authz:ratelimit:{key}"], args=[rate, burst])
+        tokens = float(tokens)
+        if allowed:
+            return {"allowed": True, "remaining": int(tokens), "retry_after_seconds": 0}
+        return {"allowed": False, "remaining": 0, "retry_after_seconds": (1 - tokens) / rate}
+
+    def remember(self, key, ttl):
+        return {"fresh": bool(self.redis.set(f"authz:nonce:{key}", 1, nx=True, ex=max(1, int(ttl))))}
+
+    def evict_idle(self):
+        pass
+
+    def ping(self):
+        self.redis.ping()
+
+
+BUCKETS = Buckets()
+
+
+class LimiterHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path != "/health":
+            self.send_error(404)
+            return
+        try:
+            BUCKETS.ping()
+        except Exception as e:  # redis.RedisError and its connection errors
+            self.send_error(503, str(e))
+            return
+        self.send_response(200)
+        self.send_header("Content-Length", "0")
+        self.end_headers()
+
+    def do_POST(self):
+        if self.path not in ("/take", "/nonce"):
+            self.send_error(404)
+            return
+        try:
+            request = json.loads(self.rfile.read(int(self.headers.get("content-length") or 0)))
+            if self.path == "/nonce":
+                result = BUCKETS.remember(str(request["key"]), float(request["ttl_seconds"]))
+            else:
+                result = BUCKETS.take(str(request["key"]), float(request["rate"]), float(request["burst"]))
+        except (ValueError, KeyError, TypeError, ZeroDivisionError) as e:
+            self.send_error(400, str(e))
+            return
+        payload = json.dumps(result).encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def log_message(self, *args):
+        pass
+
+
+def evict_forever():
+    while True:
+        time.sleep(EVICT_INTERVAL_SECONDS)
+        BUCKETS.evict_idle()
+
+
+def main():
+    global BUCKETS
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("--port", type=int, default=9393, help="port to serve /take and /nonce on")
+    parser.add_argument("--redis", help="keep the buckets in this Redis (redis://host:port/db) instead of memory")
+    args = parser.parse_args()
+    if args.redis:
+        BUCKETS = RedisBuckets(args.redis)
+    threading.Thread(target=evict_forever, daemon=True).start()
+    print(f"rate_limiter: serving on :{args.port}", file=sys.stderr)
+    ThreadingHTTPServer(("", args.port), LimiterHandler).serve_forever()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,129 @@
+#!/usr/bin/env python3
+"""
+Compare decisions with a shadow engine before upgrading.
+
+Reads OPA decision log lines from files or stdin, replays the input of every
+gateway decision against a second engine (the new version, with the same
+policies) through its REST API, and compares allowed, status and error code.
+Replays run on a worker pool off the request path, so the shadow engine adds no
+latency to live decisions. Divergences are printed as JSON lines and counted in
+authz_shadow_decisions_total{outcome} and
+authz_shadow_divergence_total{primary, shadow}.
+
+Decision inputs must be logged unmasked for the replay to be faithful: with
+config.privacy.deidentify, tokens and addresses differ from the originals.
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/shadow_diff.py --shadow http://localhost:8282 --metrics-port 9466
+"""
+
+import argparse
+import fileinput
+import json
+import sys
+import threading
+import urllib.request
+from collections import Counter
+from concurrent.futures import ThreadPoolExecutor
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+outcomes = Counter()
+divergences = Counter()
+lock = threading.Lock()
+
+
+class MetricsHandler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        lines = [
+            "# HELP authz_shadow_decisions_total Decisions replayed against the shadow engine.",
+            "# TYPE authz_shadow_decisions_total counter",
+        ]
+        with lock:
+            lines += [f'authz_shadow_decisions_total{{outcome="{o}"}} {n}' for o, n in sorted(outcomes.items())]
+            lines += [
+                "# HELP authz_shadow_divergence_total Decisions where the shadow engine disagreed.",
+                "# TYPE authz_shadow_divergence_total counter",
+            ]
+            lines += [
+                f'authz_shadow_divergence_total{{primary="{p}",shadow="{s}"}} {n}'
+                for (p, s), n in sorted(divergences.items())
+            ]
+        body = ("\n".join(lines) + "\n").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/plain; version=0.0.4")
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def outcome(result):
+    """allow, or deny:<status>:<code>."""
+    if result.get("allowed"):
+        return "allow"
+    code = result.get("dynamic_metadata", {}).get("code")
+    if code is None:
+        try:
+            code = json.loads(result.get("body", "{}")).get("error", "")
+        except ValueError:
+            code = ""
+    return f"deny:{result.get('http_status', 403)}:{code}"
+
+
+def replay(shadow, event, timeout):
+    request = urllib.request.Request(
+        f"{shadow.rstrip('/')}/v1/data/authz/result",
+        data=json.dumps({"input": event["input"]}).encode(),
+        headers={"Content-Type": "application/json"},
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=timeout) as response:
+            shadow_result = json.load(response).get("result", {})
+    except Exception as e:
+        with lock:
+            outcomes["error"] += 1
+        print(f"shadow_diff: replay of {event.get('decision_id')} failed: {e}", file=sys.stderr)
+        return
+    primary, secondary = outcome(event["result"]), outcome(shadow_result)
+    with lock:
+        outcomes["match" if primary == secondary else "diverged"] += 1
+        if primary != secondary:
+            divergences[(primary, secondary)] += 1
+    if primary != secondary:
+        print(json.dumps({
+            "decision_id": event.get("decision_id"),
+            "path": event["input"].get("attributes", {}).get("request", {}).get("http", {}).get("path"),
+            "primary": primary,
+            "shadow": secondary,
+        }), flush=True)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--shadow", required=True, help="base URL of the shadow engine's REST API")
+    parser.add_argument("--workers", type=int, default=4, help="concurrent replays")
+    parser.add_argument("--timeout", type=float, default=2.0, help="seconds to wait for the shadow engine")
+    parser.add_argument("--metrics-port", type=int, help="serve Prometheus metrics on this port")
+    args = parser.parse_args()
+
+    if args.metrics_port:
+        server = HTTPServer(("", args.metrics_port), MetricsHandler)
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+
+    with ThreadPoolExecutor(max_workers=args.workers) as pool:
+        for line in fileinput.input(args.files):
+            try:
+                event = json.loads(line)
+            except ValueError:
+                continue
+            if not isinstance(event, dict) or event.get("msg") != "Decision Log":
+                continue
+            if event.get("path") != "authz/result" or not isinstance(event.get("result"), dict):
+                continue
+            pool.submit(replay, args.shadow, event, args.timeout)
+
+
+if __name__ == "__main__":
+    main()
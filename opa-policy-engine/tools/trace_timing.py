@@ -0,0 +1,139 @@
+#!/usr/bin/env python3
+"""
+Add per-step evaluation times to the evaluation traces of decision logs.
+
+Rego cannot time its own rules, so the traces policies/evaluation.rego records
+with denied decisions carry no durations. This filter reads OPA decision log
+lines from files or stdin and writes every line back to stdout. Decisions with
+a trace are first replayed through the engine's REST API (POST /v1/query with
+metrics), one query per step, and each step gets the median evaluation time of
+--repeat runs as "duration_ms":
+
+- routes.match: the route matching;
+- rules.<name>: that operator rule on its own (its matchers, CEL condition and
+  conditions);
+- any other deny reason, the combiner votes and an exemption override: the
+  package that produced them (the same time for every step of one package);
+- decision: the whole decision.
+
+Replays take no rate-limit tokens and leave DPoP replay records alone. Calls
+OPA has cached answer faster than on the original request, and each query
+evaluates what its step depends on, such as the token, again. A step whose
+replayed result differs from the recorded one, for instance because the logged
+input was masked (authorization headers always are, addresses with
+config.privacy.deidentify), gets "replay": "diverged" instead of a duration.
+
+Queries need the evaluate capability: when the REST API requires tokens
+(run-opa.sh --admin-rbac), the Keycloak access token in $OPA_TOKEN is sent with
+every call, and its roles need evaluate (role policy-editor).
+
+Usage:
+    docker logs -f opa-policy-engine 2>&1 | tools/trace_timing.py --opa http://localhost:8181 \\
+        | tools/archive_decision_logs.py --destination s3://audit-archive/opa-policy-engine
+"""
+
+import argparse
+import fileinput
+import json
+import os
+import re
+import statistics
+import sys
+import urllib.request
+
+MOCKS = (
+    "with data.authz.ratelimit.configured as [] "
+    'with data.authz.dpop.replay as {"status_code": 200, "body": {"fresh": true}}'
+)
+
+PACKAGE = re.compile(r"^[a-z_][a-z0-9_]*$")
+
+
+def query(opa_url, text, input_, timeout):
+    """Evaluates `result := ...` and returns (result or None, evaluation time in ns)."""
+    request = urllib.request.Request(
+        f"{opa_url}/v1/query?metrics=true",
+        data=json.dumps({"query": f"{text} {MOCKS}", "input": input_}).encode(),
+        headers={"Content-Type": "application/json"},
+    )
+    if os.environ.get("OPA_TOKEN"):
+        request.add_header("Authorization", f"Bearer {os.environ['OPA_TOKEN']}")
+    with urllib.request.urlopen(request, timeout=timeout) as response:
+        answer = json.load(response)
+    results = answer.get("result") or [{}]
+    return results[0].get("result"), answer.get("metrics", {}).get("timer_rego_query_eval_ns", 0)
+
+
+def step_query(step):
+    """The query timing a step, and a check of its replayed result against the recorded one."""
+    rule, result = step.get("rule", ""), step.get("result")
+    if rule == "routes.match":
+        return "result := sort(data.authz.routes.matched)", lambda r: r == sorted(step.get("routes", []))
+    if rule == "decision":
+        return "result := data.authz.result.allowed", lambda r: r == (result == "allow")
+    if rule == "overrides.exempt":
+        return "result := data.authz.overrides.exempt.id", lambda r: r == step.get("override")
+    if rule.startswith("rules."):
+        name = json.dumps(rule[len("rules."):])
+        text = f"result := [x | some r in data.authz.rules.configured; r.name == {name}; x := data.authz.evaluation.rule_result(r)][0]"
+        return text, lambda r: r == result
+    package, _, member = rule.partition(".")
+    if not PACKAGE.match(package):
+        return None, None
+    if package == "combiner":
+        return "result := data.authz.combiner.record.votes", lambda r: isinstance(r, dict) and r.get(member) == result
+    return f"result := [r.rule | some r in data.authz.{package}.deny]", lambda r: isinstance(r, list) and rule in r
+
+
+def time_trace(opa_url, event, repeat, timeout):
+    timed = {}
+    for step in event["result"]["dynamic_metadata"]["trace"]:
+        text, matches = step_query(step)
+        if text is None:
+            continue
+        if text not in timed:
+            durations, result = [], None
+            for _ in range(repeat):
+                result, duration = query(opa_url, text, event["input"], timeout)
+                durations.append(duration)
+            timed[text] = (result, statistics.median(durations))
+        result, duration = timed[text]
+        if matches(result):
+            step["duration_ms"] = round(duration / 1e6, 3)
+        else:
+            step["replay"] = "diverged"
+
+
+def traced(event):
+    if not isinstance(event, dict) or event.get("msg") != "Decision Log" or event.get("path") != "authz/result":
+        return False
+    result = event.get("result")
+    return isinstance(result, dict) and isinstance(result.get("dynamic_metadata", {}).get("trace"), list)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+    parser.add_argument("files", nargs="*", help="decision log files (default: stdin)")
+    parser.add_argument("--opa", default=os.environ.get("OPA_URL", "http://localhost:8181"), help="OPA REST API base URL")
+    parser.add_argument("--repeat", type=int, default=3, help="runs per step; the median is recorded")
+    parser.add_argument("--timeout", type=float, default=2.0, help="seconds to wait for each query")
+    args = parser.parse_args()
+
+    for line in fileinput.input(args.files):
+        try:
+            event = json.loads(line)
+        except ValueError:
+            event = None
+        if not traced(event):
+            sys.stdout.write(line)
+            continue
+        try:
+            time_trace(args.opa.rstrip("/"), event, max(args.repeat, 1), args.timeout)
+        except Exception as e:
+            print(f"trace_timing: replay of {event.get('decision_id')} failed: {e}", file=sys.stderr)
+        sys.stdout.write(json.dumps(event) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()